@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,26 +14,43 @@ import (
 
 // Config is the config object passed to agent initialization
 type Config struct {
-	Debug         *bool
-	Enabled       *bool
-	Plugins       []PluginInstantiator
-	Reporter      Reporter
-	TimeoutWindow *time.Duration
-	Token         *string
+	Compression          *string
+	CompressionThreshold *int
+	Debug                *bool
+	Enabled              *bool
+	HookTimeout          *time.Duration
+	Limits               *ReportLimits
+	Metadata             *InvocationMetadata
+	Plugins              []PluginInstantiator
+	PluginsFile          *string
+	Redaction            *RedactionConfig
+	Reporter             Reporter
+	SequentialHooks      *bool
+	TimeoutWindow        *time.Duration
+	Token                *string
 }
 
 // Agent is the IOpipe instance
 type Agent struct {
 	*Config
-	log     *log.Logger
-	plugins []Plugin
+	log          *log.Logger
+	plugins      []Plugin
+	pluginLevels [][]int
+	readyAt      time.Time
+	redaction    *redactor
+	setupHooks   []map[string]PluginHookMeta
+	shutdownOnce sync.Once
 }
 
 var (
-	defaultConfigDebug         = false
-	defaultConfigEnabled       = true
-	defaultConfigTimeoutWindow = time.Duration(150 * time.Millisecond)
-	defaultReporter            = sendReport
+	defaultConfigCompression          = ""
+	defaultConfigCompressionThreshold = 1024
+	defaultConfigDebug                = false
+	defaultConfigEnabled              = true
+	defaultConfigHookTimeout          = time.Duration(10 * time.Second)
+	defaultConfigSequentialHooks      = false
+	defaultConfigTimeoutWindow        = time.Duration(150 * time.Millisecond)
+	defaultReporter                   = sendReport
 )
 
 // NewAgent returns a new IOpipe instance with config
@@ -52,12 +70,68 @@ func NewAgent(config Config) *Agent {
 	}
 
 	a := &Agent{
-		log:     NewLogger(),
-		plugins: plugins,
+		log: NewLogger(),
 	}
 
+	// PluginsFile, listing registered plugins to load along with those in
+	// IOPIPE_PLUGINS
+	pluginsFile := config.PluginsFile
+	if pluginsFile == nil {
+		envPluginsFile := os.Getenv("IOPIPE_PLUGINS_FILE")
+		pluginsFile = &envPluginsFile
+	}
+	plugins = append(plugins, registeredPlugins(*pluginsFile, plugins, a.log)...)
+
+	// HookTimeout, needed by the PreSetup hooks
+	hookTimeout := &defaultConfigHookTimeout
+	envHookTimeoutInt, err := strconv.Atoi(os.Getenv("IOPIPE_HOOK_TIMEOUT"))
+	if err == nil {
+		envHookTimeoutDuration := time.Duration(time.Duration(envHookTimeoutInt) * time.Millisecond)
+		hookTimeout = &envHookTimeoutDuration
+	}
+	if config.HookTimeout != nil {
+		hookTimeout = config.HookTimeout
+	}
+
+	// SequentialHooks, needed to order the plugins
+	sequentialHooks := &defaultConfigSequentialHooks
+	envSequentialHooks := os.Getenv("IOPIPE_SEQUENTIAL_HOOKS")
+	if envSequentialHooks != "" {
+		sequentialHooks = strToBool(envSequentialHooks)
+	}
+	if config.SequentialHooks != nil {
+		sequentialHooks = config.SequentialHooks
+	}
+
+	a.plugins, a.pluginLevels = orderPlugins(plugins, *sequentialHooks, a.log)
+
+	a.Config = &Config{HookTimeout: hookTimeout, PluginsFile: pluginsFile, SequentialHooks: sequentialHooks}
 	a.preSetup()
 
+	// Compression
+	compression := &defaultConfigCompression
+	envCompression := os.Getenv("IOPIPE_COMPRESSION")
+	if envCompression != "" {
+		compression = &envCompression
+	}
+	if config.Compression != nil {
+		compression = config.Compression
+	}
+	if !isSupportedEncoding(*compression) {
+		a.log.Warn(fmt.Sprintf("Unsupported compression %s, payloads will not be compressed", *compression))
+		compression = &defaultConfigCompression
+	}
+
+	// CompressionThreshold
+	compressionThreshold := &defaultConfigCompressionThreshold
+	envCompressionThreshold, err := strconv.Atoi(os.Getenv("IOPIPE_COMPRESSION_THRESHOLD"))
+	if err == nil {
+		compressionThreshold = &envCompressionThreshold
+	}
+	if config.CompressionThreshold != nil {
+		compressionThreshold = config.CompressionThreshold
+	}
+
 	// Debug
 	debug := &defaultConfigDebug
 	envDebug := os.Getenv("IOPIPE_DEBUG")
@@ -81,6 +155,19 @@ func NewAgent(config Config) *Agent {
 		enabled = config.Enabled
 	}
 
+	// Redaction
+	redaction := config.Redaction
+	if redaction == nil && *strToBool(os.Getenv("IOPIPE_REDACTION")) {
+		redaction = &RedactionConfig{}
+	}
+	envRedactionKeys := os.Getenv("IOPIPE_REDACTION_KEYS")
+	if redaction != nil && envRedactionKeys != "" {
+		redactionWithKeys := *redaction
+		redactionWithKeys.Keys = append(strings.Split(envRedactionKeys, ","), redaction.Keys...)
+		redaction = &redactionWithKeys
+	}
+	a.redaction = newRedactor(redaction)
+
 	// Reporter
 	reporter := defaultReporter
 	if config.Reporter != nil {
@@ -107,15 +194,24 @@ func NewAgent(config Config) *Agent {
 	}
 
 	a.Config = &Config{
-		Debug:         debug,
-		Enabled:       enabled,
-		Plugins:       pluginInstantiators,
-		Reporter:      reporter,
-		TimeoutWindow: timeoutWindow,
-		Token:         token,
+		Compression:          compression,
+		CompressionThreshold: compressionThreshold,
+		Debug:                debug,
+		Enabled:              enabled,
+		HookTimeout:          hookTimeout,
+		Limits:               config.Limits,
+		Metadata:             config.Metadata,
+		Plugins:              pluginInstantiators,
+		PluginsFile:          pluginsFile,
+		Redaction:            redaction,
+		Reporter:             reporter,
+		SequentialHooks:      sequentialHooks,
+		TimeoutWindow:        timeoutWindow,
+		Token:                token,
 	}
 
 	a.postSetup()
+	a.readyAt = time.Now()
 
 	return a
 }
@@ -124,53 +220,84 @@ func NewAgent(config Config) *Agent {
 func (a *Agent) WrapHandler(handler interface{}) interface{} {
 	a.log.Debug(fmt.Sprintf("%s wrapped with IOpipe decorator", getFuncName(handler)))
 
+	if !a.isEnabled() {
+		return handler
+	}
+
+	return wrapHandler(handler, a)
+}
+
+// isEnabled returns true if the agent is enabled and has a token
+func (a *Agent) isEnabled() bool {
 	if a.Enabled != nil && !*a.Enabled {
 		a.log.Debug("IOpipe agent disabled, skipping reporting")
-		return handler
+		return false
 	}
 
 	if a.Token != nil && *a.Token == "" {
 		a.log.Debug("Your function is decorated with iopipe, but a valid token was not found. Set the IOPIPE_TOKEN environment variable with your IOpipe project token.")
-		return handler
+		return false
 	}
 
-	return wrapHandler(handler, a)
+	return true
 }
 
 // preSetup runs the PreSetup hooks
 func (a *Agent) preSetup() {
-	var wg sync.WaitGroup
-	wg.Add(len(a.plugins))
-
-	for _, plugin := range a.plugins {
-		go func(plugin Plugin) {
-			defer wg.Done()
-
-			if plugin != nil {
-				plugin.PreSetup(a)
-			}
-		}(plugin)
-	}
+	outcomes := a.runPluginHooks(a.hookTimeout(time.Time{}), func(plugin Plugin) func() {
+		if hook, ok := plugin.(PreSetupHook); ok && !pluginToggledOff(plugin) {
+			return func() { hook.PreSetup(a) }
+		}
+		return nil
+	})
 
-	wg.Wait()
+	a.recordSetupHooks(hookPreSetup, outcomes)
 }
 
 // postSetup runs the PostSetup hooks
 func (a *Agent) postSetup() {
-	var wg sync.WaitGroup
-	wg.Add(len(a.plugins))
+	outcomes := a.runPluginHooks(a.hookTimeout(time.Time{}), func(plugin Plugin) func() {
+		if hook, ok := plugin.(PostSetupHook); ok && !pluginToggledOff(plugin) {
+			return func() { hook.PostSetup(a) }
+		}
+		return nil
+	})
 
-	for _, plugin := range a.plugins {
-		go func(plugin Plugin) {
-			defer wg.Done()
+	a.recordSetupHooks(hookPostSetup, outcomes)
+}
 
-			if plugin != nil {
-				plugin.PostSetup(a)
+// Shutdown runs the OnShutdown hooks of the plugins, for example when the
+// process receives SIGTERM. Only the first call has any effect.
+func (a *Agent) Shutdown() {
+	a.shutdownOnce.Do(func() {
+		a.runEventHooks(hookOnShutdown, time.Time{}, func(plugin Plugin) func() {
+			if hook, ok := plugin.(ShutdownHook); ok && pluginEnabled(plugin) {
+				return hook.OnShutdown
 			}
-		}(plugin)
+			return nil
+		})
+	})
+}
+
+// recordSetupHooks records the outcomes of a setup hook, to be added to the
+// plugin meta data of cold start reports
+func (a *Agent) recordSetupHooks(name string, outcomes []*PluginHookMeta) {
+	a.logHookFailures(name, outcomes)
+
+	if a.setupHooks == nil {
+		a.setupHooks = make([]map[string]PluginHookMeta, len(outcomes))
 	}
 
-	wg.Wait()
+	for index, outcome := range outcomes {
+		if outcome == nil {
+			continue
+		}
+
+		if a.setupHooks[index] == nil {
+			a.setupHooks[index] = make(map[string]PluginHookMeta)
+		}
+		a.setupHooks[index][name] = *outcome
+	}
 }
 
 // wrapHandler decorates the handler with the handler wrapper
@@ -87,6 +87,31 @@ func TestAgent_NewAgent(t *testing.T) {
 	})
 
 	Convey("An agent should check environment variables for configuration", t, func() {
+		Convey("IOPIPE_COMPRESSION should set the compression", func() {
+			oldValue := os.Getenv("IOPIPE_COMPRESSION")
+			os.Setenv("IOPIPE_COMPRESSION", "gzip")
+
+			a := NewAgent(Config{})
+			So(*a.Compression, ShouldEqual, "gzip")
+
+			os.Setenv("IOPIPE_COMPRESSION", "brotli")
+
+			a = NewAgent(Config{})
+			So(*a.Compression, ShouldEqual, "")
+
+			os.Setenv("IOPIPE_COMPRESSION", oldValue)
+		})
+
+		Convey("IOPIPE_COMPRESSION_THRESHOLD should set the compression threshold", func() {
+			oldValue := os.Getenv("IOPIPE_COMPRESSION_THRESHOLD")
+			os.Setenv("IOPIPE_COMPRESSION_THRESHOLD", "64")
+
+			a := NewAgent(Config{})
+			So(*a.CompressionThreshold, ShouldEqual, 64)
+
+			os.Setenv("IOPIPE_COMPRESSION_THRESHOLD", oldValue)
+		})
+
 		Convey("IOPIPE_DEBUG should enable debug mode", func() {
 			oldValue := os.Getenv("IOPIPE_DEBUG")
 			os.Setenv("IOPIPE_DEBUG", "true")
@@ -0,0 +1,181 @@
+package iopipe
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// backgroundTask is a goroutine started with ContextWrapper.Go
+type backgroundTask struct {
+	done     chan struct{}
+	err      error
+	panicked bool
+	duration time.Duration
+}
+
+// Go runs fn in a goroutine with the invocation's context, and waits for it to
+// finish before sending the report, up to the timeout window. Errors, panics
+// and durations of the goroutines are recorded in the report. Panics are
+// recovered rather than crashing the process, and goroutines started after
+// the invocation has finished are not waited for.
+func (cw *ContextWrapper) Go(fn func(context.Context) error) {
+	if cw == nil || cw.IOpipe == nil {
+		go runBackgroundTask(context.Background(), &backgroundTask{done: make(chan struct{})}, fn)
+		return
+	}
+
+	cw.IOpipe.goBackground(fn)
+}
+
+// goBackground starts a background task of the invocation
+func (hw *HandlerWrapper) goBackground(fn func(context.Context) error) {
+	ctx := hw.invocationContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	task := &backgroundTask{done: make(chan struct{})}
+
+	hw.backgroundMutex.Lock()
+	if hw.backgroundWaited {
+		hw.Log.Debug("Background task started after the invocation finished. This task will not be recorded.")
+	} else {
+		hw.background = append(hw.background, task)
+	}
+	hw.backgroundMutex.Unlock()
+
+	go runBackgroundTask(ctx, task, fn)
+}
+
+// runBackgroundTask runs fn, recording its error, panic and duration in task
+func runBackgroundTask(ctx context.Context, task *backgroundTask, fn func(context.Context) error) {
+	start := time.Now()
+
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			task.err = fmt.Errorf("panic: %v", panicErr)
+			task.panicked = true
+		}
+
+		task.duration = time.Since(start)
+		close(task.done)
+	}()
+
+	task.err = fn(ctx)
+}
+
+// waitBackground waits for the invocation's background tasks, including those
+// they start, until the invocation is about to time out, and records their
+// outcome in the report
+func (hw *HandlerWrapper) waitBackground() {
+	hw.backgroundMutex.Lock()
+	if len(hw.background) == 0 {
+		hw.backgroundWaited = true
+		hw.backgroundMutex.Unlock()
+		return
+	}
+	hw.backgroundWaiting = true
+	hw.backgroundMutex.Unlock()
+
+	var timeout <-chan time.Time
+	if !hw.deadline.IsZero() {
+		timeout = time.After(time.Until(hw.timeoutDeadline()))
+	}
+
+	waited := 0
+
+wait:
+	for {
+		hw.backgroundMutex.Lock()
+		if waited == len(hw.background) {
+			hw.backgroundWaited = true
+			hw.backgroundWaiting = false
+			hw.backgroundMutex.Unlock()
+			break
+		}
+		task := hw.background[waited]
+		hw.backgroundMutex.Unlock()
+
+		select {
+		case <-task.done:
+			waited++
+		case <-timeout:
+			hw.backgroundMutex.Lock()
+			hw.backgroundWaited = true
+			hw.backgroundWaiting = false
+			hw.backgroundMutex.Unlock()
+			break wait
+		}
+	}
+
+	hw.recordBackground()
+}
+
+// isWaitingBackground returns true while the invocation is waiting for its
+// background tasks
+func (hw *HandlerWrapper) isWaitingBackground() bool {
+	hw.backgroundMutex.Lock()
+	defer hw.backgroundMutex.Unlock()
+
+	return hw.backgroundWaiting
+}
+
+// recordBackground records the outcome of the invocation's background tasks
+func (hw *HandlerWrapper) recordBackground() {
+	hw.backgroundMutex.Lock()
+	tasks := hw.background
+	hw.backgroundMutex.Unlock()
+
+	if len(tasks) == 0 {
+		return
+	}
+
+	var (
+		errors      int
+		firstError  error
+		maxDuration time.Duration
+		panics      int
+		unfinished  int
+	)
+
+	for _, task := range tasks {
+		select {
+		case <-task.done:
+		default:
+			unfinished++
+			continue
+		}
+
+		if task.duration > maxDuration {
+			maxDuration = task.duration
+		}
+
+		if task.err == nil {
+			continue
+		}
+
+		errors++
+		if task.panicked {
+			panics++
+		}
+		if firstError == nil {
+			firstError = task.err
+		}
+	}
+
+	hw.Metric("@iopipe/background.tasks", len(tasks))
+	hw.Metric("@iopipe/background.errors", errors)
+	hw.Metric("@iopipe/background.panics", panics)
+	hw.Metric("@iopipe/background.unfinished", unfinished)
+	hw.Metric("@iopipe/background.duration.max", int64(maxDuration/time.Millisecond))
+
+	if firstError != nil {
+		hw.Label("@iopipe/background.error")
+		hw.Metric("@iopipe/background.error", firstError.Error())
+	}
+
+	if unfinished > 0 {
+		hw.Label("@iopipe/background.unfinished")
+	}
+}
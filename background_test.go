@@ -0,0 +1,101 @@
+package iopipe
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestContextWrapper_Go(t *testing.T) {
+	var reported *Report
+
+	token := "token"
+	a := NewAgent(Config{
+		Token: &token,
+		Reporter: func(report *Report) error {
+			reported = report
+			return nil
+		},
+	})
+
+	Convey("Go should wait for background tasks before sending the report", t, func() {
+		var hasContext bool
+
+		a.Run(context.Background(), "job", func(ctx context.Context) error {
+			wrapper, _ := FromContext(ctx)
+
+			wrapper.Go(func(ctx context.Context) error {
+				time.Sleep(20 * time.Millisecond)
+
+				wrapper, ok := FromContext(ctx)
+				hasContext = ok
+				wrapper.IOpipe.Metric("published", 1)
+
+				// Tasks started by background tasks are waited for too
+				wrapper.Go(func(ctx context.Context) error {
+					time.Sleep(10 * time.Millisecond)
+					return errors.New("whoops")
+				})
+
+				return nil
+			})
+
+			wrapper.Go(func(ctx context.Context) error {
+				panic("oh no")
+			})
+
+			return nil
+		})
+
+		So(hasContext, ShouldBeTrue)
+		So(reported.CustomMetrics, ShouldContain, CustomMetric{Name: "published", N: int64(1)})
+		So(reported.Labels, ShouldContain, "@iopipe/background.error")
+		So(reported.Labels, ShouldNotContain, "@iopipe/error")
+		So(metricValue(reported, "@iopipe/background.tasks"), ShouldEqual, 3)
+		So(metricValue(reported, "@iopipe/background.errors"), ShouldEqual, 2)
+		So(metricValue(reported, "@iopipe/background.panics"), ShouldEqual, 1)
+		So(metricValue(reported, "@iopipe/background.unfinished"), ShouldEqual, 0)
+		So(metricValue(reported, "@iopipe/background.duration.max"), ShouldBeGreaterThanOrEqualTo, 20)
+		So(metricValue(reported, "@iopipe/background.error"), ShouldBeIn, []string{"whoops", "panic: oh no"})
+	})
+
+	Convey("Go should stop waiting when the invocation is about to time out", t, func() {
+		timeoutWindow := 50 * time.Millisecond
+		a.TimeoutWindow = &timeoutWindow
+		defer func() { a.TimeoutWindow = &defaultConfigTimeoutWindow }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		var finished int32
+		a.Run(ctx, "job", func(ctx context.Context) error {
+			wrapper, _ := FromContext(ctx)
+			wrapper.Go(func(ctx context.Context) error {
+				time.Sleep(200 * time.Millisecond)
+				atomic.StoreInt32(&finished, 1)
+				return nil
+			})
+			return nil
+		})
+
+		So(atomic.LoadInt32(&finished), ShouldEqual, 0)
+		So(reported.Labels, ShouldContain, "@iopipe/background.unfinished")
+		So(reported.Labels, ShouldNotContain, "@iopipe/timeout")
+		So(reported.Errors, ShouldResemble, &struct{}{})
+		So(metricValue(reported, "@iopipe/background.unfinished"), ShouldEqual, 1)
+	})
+
+	Convey("Go should run tasks without a handler wrapper", t, func() {
+		done := make(chan struct{})
+		(*ContextWrapper)(nil).Go(func(ctx context.Context) error {
+			close(done)
+			return nil
+		})
+
+		So(func() { <-done }, ShouldNotPanic)
+	})
+}
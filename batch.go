@@ -0,0 +1,324 @@
+package iopipe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Batch event sources supported by ContextWrapper.Batch
+const (
+	BatchSourceSQS      = "sqs"
+	BatchSourceKinesis  = "kinesis"
+	BatchSourceDynamoDB = "dynamodb"
+)
+
+// maxBatchErrorMetrics is the number of failed records whose errors are
+// recorded as custom metrics
+const maxBatchErrorMetrics = 10
+
+// BatchRecord is a record of an SQS, Kinesis or DynamoDB stream batch
+type BatchRecord struct {
+	// ID identifies the record in partial batch failure responses, the SQS
+	// message ID or the stream record's sequence number
+	ID string
+
+	// Source is the record's event source, one of the BatchSource constants
+	Source string
+
+	// EventSourceARN is the ARN of the queue or stream
+	EventSourceARN string
+
+	// Body is the SQS message body, the decoded Kinesis data or the DynamoDB
+	// stream record's dynamodb object
+	Body []byte
+
+	// ReceiveCount is the number of times the SQS message has been received,
+	// it is 0 for stream records
+	ReceiveCount int
+
+	// Raw is the record as sent in the event, to unmarshal into an
+	// aws-lambda-go events type
+	Raw json.RawMessage
+}
+
+// BatchItemFailure identifies a record to retry
+type BatchItemFailure struct {
+	ItemIdentifier string `json:"itemIdentifier"`
+}
+
+// BatchResponse is a partial batch failure response, return it from the
+// handler when the event source mapping reports batch item failures
+type BatchResponse struct {
+	BatchItemFailures []BatchItemFailure `json:"batchItemFailures"`
+}
+
+type batchRecordKey struct{}
+
+var batchRecordContextKey = &batchRecordKey{}
+
+// BatchRecordFromContext returns the batch record being processed with ctx
+func BatchRecordFromContext(ctx context.Context) (*BatchRecord, bool) {
+	record, ok := ctx.Value(batchRecordContextKey).(*BatchRecord)
+	return record, ok
+}
+
+type batchEvent struct {
+	Records []json.RawMessage `json:"Records"`
+}
+
+type batchEventRecord struct {
+	EventSource    string `json:"eventSource"`
+	EventSourceARN string `json:"eventSourceARN"`
+
+	// SQS
+	MessageID  string            `json:"messageId"`
+	Body       string            `json:"body"`
+	Attributes map[string]string `json:"attributes"`
+
+	// Kinesis
+	Kinesis *struct {
+		Data           []byte `json:"data"`
+		SequenceNumber string `json:"sequenceNumber"`
+	} `json:"kinesis"`
+
+	// DynamoDB
+	DynamoDB json.RawMessage `json:"dynamodb"`
+}
+
+type batchEventDynamoDB struct {
+	SequenceNumber string `json:"SequenceNumber"`
+}
+
+// batchStats counts the outcome of a batch's records
+type batchStats struct {
+	source      string
+	records     int
+	successes   int
+	failures    int
+	retries     int
+	skipped     int
+	duration    time.Duration
+	maxDuration time.Duration
+	errors      []batchError
+}
+
+type batchError struct {
+	id  string
+	err error
+}
+
+// Batch calls fn for each record of an SQS, Kinesis or DynamoDB stream event,
+// recording successes, failures, retries and timings in the report. event is
+// the raw event or an aws-lambda-go events value. Each record gets a child
+// context carrying the record, which is cancelled once fn returns, and panics
+// in fn fail the record.
+//
+// Stream records and records of FIFO queues are processed in order, stopping
+// at the first failure, and records are skipped once ctx is done. The returned
+// response lists the records to retry: every failed and skipped SQS message,
+// or the first stream record that was not processed successfully. An error is
+// returned only if event is not a supported batch event.
+func (cw *ContextWrapper) Batch(ctx context.Context, event interface{}, fn func(context.Context, *BatchRecord) error) (*BatchResponse, error) {
+	records, err := parseBatchEvent(event)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := batchStats{records: len(records)}
+	response := &BatchResponse{BatchItemFailures: []BatchItemFailure{}}
+
+	if len(records) > 0 {
+		stats.source = records[0].Source
+	}
+
+	ordered := false
+	for _, record := range records {
+		if record.ReceiveCount > 1 {
+			stats.retries++
+		}
+
+		if ordered || ctx.Err() != nil {
+			stats.skipped++
+			response.fail(record)
+			continue
+		}
+
+		start := time.Now()
+		err := processBatchRecord(ctx, record, fn)
+		duration := time.Since(start)
+
+		stats.duration += duration
+		if duration > stats.maxDuration {
+			stats.maxDuration = duration
+		}
+
+		if err == nil {
+			stats.successes++
+			continue
+		}
+
+		stats.failures++
+		stats.errors = append(stats.errors, batchError{record.ID, err})
+		response.fail(record)
+
+		// Keep the order of streams and FIFO queues by not processing the
+		// records after a failure
+		if record.isOrdered() {
+			ordered = true
+		}
+	}
+
+	if cw != nil && cw.IOpipe != nil {
+		stats.record(cw.IOpipe)
+	}
+
+	return response, nil
+}
+
+// fail adds record to the records to retry, only the first stream record is
+// needed as the stream is retried from it
+func (r *BatchResponse) fail(record *BatchRecord) {
+	if record.Source != BatchSourceSQS && len(r.BatchItemFailures) > 0 {
+		return
+	}
+
+	r.BatchItemFailures = append(r.BatchItemFailures, BatchItemFailure{ItemIdentifier: record.ID})
+}
+
+// isOrdered returns true if the records after record must not be processed
+// once it fails
+func (r *BatchRecord) isOrdered() bool {
+	if r.Source != BatchSourceSQS {
+		return true
+	}
+
+	return strings.HasSuffix(r.EventSourceARN, ".fifo")
+}
+
+// processBatchRecord calls fn for record with a child context, recovering
+// panics as errors
+func processBatchRecord(ctx context.Context, record *BatchRecord, fn func(context.Context, *BatchRecord) error) (err error) {
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, batchRecordContextKey, record))
+	defer cancel()
+
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			err = fmt.Errorf("panic: %v", panicErr)
+		}
+	}()
+
+	return fn(ctx, record)
+}
+
+// record records the batch's counts and timings in the report
+func (s *batchStats) record(hw *HandlerWrapper) {
+	hw.Label("@iopipe/batch")
+	if s.failures > 0 {
+		hw.Label("@iopipe/batch.failures")
+	}
+
+	if s.source != "" {
+		hw.Metric("@iopipe/batch.source", s.source)
+	}
+	hw.Metric("@iopipe/batch.records", s.records)
+	hw.Metric("@iopipe/batch.successes", s.successes)
+	hw.Metric("@iopipe/batch.failures", s.failures)
+	hw.Metric("@iopipe/batch.retries", s.retries)
+	hw.Metric("@iopipe/batch.skipped", s.skipped)
+	hw.Metric("@iopipe/batch.duration", int64(s.duration/time.Millisecond))
+	hw.Metric("@iopipe/batch.duration.max", int64(s.maxDuration/time.Millisecond))
+
+	for index, batchError := range s.errors {
+		if index == maxBatchErrorMetrics {
+			break
+		}
+
+		// Names are fixed so record IDs don't create a metric name each
+		hw.Metric(fmt.Sprintf("@iopipe/batch.error.%d", index), batchError.id+": "+batchError.err.Error())
+	}
+}
+
+// parseBatchEvent returns the records of an SQS, Kinesis or DynamoDB stream
+// event
+func parseBatchEvent(event interface{}) ([]*BatchRecord, error) {
+	var payload []byte
+
+	switch e := event.(type) {
+	case json.RawMessage:
+		payload = e
+	case []byte:
+		payload = e
+	default:
+		var err error
+		if payload, err = json.Marshal(event); err != nil {
+			return nil, fmt.Errorf("invalid batch event: %v", err)
+		}
+	}
+
+	var e batchEvent
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, fmt.Errorf("invalid batch event: %v", err)
+	}
+
+	if e.Records == nil {
+		return nil, fmt.Errorf("unsupported batch event, expected an SQS, Kinesis or DynamoDB stream event")
+	}
+
+	records := make([]*BatchRecord, 0, len(e.Records))
+	for _, raw := range e.Records {
+		record, err := parseBatchRecord(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// parseBatchRecord returns the batch record of an event record
+func parseBatchRecord(raw json.RawMessage) (*BatchRecord, error) {
+	var r batchEventRecord
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return nil, fmt.Errorf("invalid batch record: %v", err)
+	}
+
+	record := &BatchRecord{
+		EventSourceARN: r.EventSourceARN,
+		Raw:            raw,
+	}
+
+	switch r.EventSource {
+	case "aws:sqs":
+		record.Source = BatchSourceSQS
+		record.ID = r.MessageID
+		record.Body = []byte(r.Body)
+		record.ReceiveCount, _ = strconv.Atoi(r.Attributes["ApproximateReceiveCount"])
+	case "aws:kinesis":
+		if r.Kinesis == nil {
+			return nil, fmt.Errorf("invalid batch record: missing kinesis data")
+		}
+
+		record.Source = BatchSourceKinesis
+		record.ID = r.Kinesis.SequenceNumber
+		record.Body = r.Kinesis.Data
+	case "aws:dynamodb":
+		var d batchEventDynamoDB
+		if err := json.Unmarshal(r.DynamoDB, &d); err != nil {
+			return nil, fmt.Errorf("invalid batch record: %v", err)
+		}
+
+		record.Source = BatchSourceDynamoDB
+		record.ID = d.SequenceNumber
+		record.Body = r.DynamoDB
+	default:
+		return nil, fmt.Errorf("unsupported batch record event source %q", r.EventSource)
+	}
+
+	return record, nil
+}
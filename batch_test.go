@@ -0,0 +1,189 @@
+package iopipe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const sqsBatchEvent = `{"Records": [
+	{"messageId": "m1", "body": "one", "eventSource": "aws:sqs", "eventSourceARN": "arn:aws:sqs:us-east-1:123456789012:queue", "attributes": {"ApproximateReceiveCount": "1"}},
+	{"messageId": "m2", "body": "two", "eventSource": "aws:sqs", "eventSourceARN": "arn:aws:sqs:us-east-1:123456789012:queue", "attributes": {"ApproximateReceiveCount": "3"}},
+	{"messageId": "m3", "body": "three", "eventSource": "aws:sqs", "eventSourceARN": "arn:aws:sqs:us-east-1:123456789012:queue", "attributes": {"ApproximateReceiveCount": "1"}}
+]}`
+
+const kinesisBatchEvent = `{"Records": [
+	{"eventSource": "aws:kinesis", "kinesis": {"data": "b25l", "sequenceNumber": "101"}},
+	{"eventSource": "aws:kinesis", "kinesis": {"data": "dHdv", "sequenceNumber": "102"}},
+	{"eventSource": "aws:kinesis", "kinesis": {"data": "dGhyZWU=", "sequenceNumber": "103"}}
+]}`
+
+const dynamoDBBatchEvent = `{"Records": [
+	{"eventSource": "aws:dynamodb", "dynamodb": {"SequenceNumber": "201", "Keys": {"id": {"S": "1"}}}}
+]}`
+
+// runBatch processes event in a reported unit of work, returning the batch
+// response and the report
+func runBatch(event interface{}, fn func(context.Context, *BatchRecord) error) (*BatchResponse, error, *Report) {
+	var (
+		reported *Report
+		response *BatchResponse
+		batchErr error
+	)
+
+	token := "token"
+	a := NewAgent(Config{
+		Token: &token,
+		Reporter: func(report *Report) error {
+			reported = report
+			return nil
+		},
+	})
+
+	a.Run(context.Background(), "batch", func(ctx context.Context) error {
+		context, _ := FromContext(ctx)
+		response, batchErr = context.Batch(ctx, event, fn)
+		return nil
+	})
+
+	return response, batchErr, reported
+}
+
+func TestBatch_SQS(t *testing.T) {
+	Convey("Batch processes every SQS message and reports the failed ones", t, func() {
+		var bodies []string
+
+		response, err, report := runBatch(json.RawMessage(sqsBatchEvent), func(ctx context.Context, record *BatchRecord) error {
+			bodies = append(bodies, string(record.Body))
+
+			contextRecord, ok := BatchRecordFromContext(ctx)
+			So(ok, ShouldBeTrue)
+			So(contextRecord, ShouldEqual, record)
+
+			if record.ID == "m2" {
+				return errors.New("whoops")
+			}
+			return nil
+		})
+
+		So(err, ShouldBeNil)
+		So(bodies, ShouldResemble, []string{"one", "two", "three"})
+		So(response.BatchItemFailures, ShouldResemble, []BatchItemFailure{{ItemIdentifier: "m2"}})
+
+		So(report.Labels, ShouldContain, "@iopipe/batch")
+		So(report.Labels, ShouldContain, "@iopipe/batch.failures")
+		So(metricValue(report, "@iopipe/batch.source"), ShouldEqual, BatchSourceSQS)
+		So(metricValue(report, "@iopipe/batch.records"), ShouldEqual, 3)
+		So(metricValue(report, "@iopipe/batch.successes"), ShouldEqual, 2)
+		So(metricValue(report, "@iopipe/batch.failures"), ShouldEqual, 1)
+		So(metricValue(report, "@iopipe/batch.retries"), ShouldEqual, 1)
+		So(metricValue(report, "@iopipe/batch.skipped"), ShouldEqual, 0)
+		So(metricValue(report, "@iopipe/batch.error.0"), ShouldEqual, "m2: whoops")
+	})
+
+	Convey("Batch stops at the first failure of a FIFO queue and retries the rest", t, func() {
+		event := map[string]interface{}{}
+		json.Unmarshal([]byte(sqsBatchEvent), &event)
+		for _, record := range event["Records"].([]interface{}) {
+			record.(map[string]interface{})["eventSourceARN"] = "arn:aws:sqs:us-east-1:123456789012:queue.fifo"
+		}
+
+		calls := 0
+		response, err, report := runBatch(event, func(ctx context.Context, record *BatchRecord) error {
+			calls++
+			if record.ID == "m2" {
+				return errors.New("whoops")
+			}
+			return nil
+		})
+
+		So(err, ShouldBeNil)
+		So(calls, ShouldEqual, 2)
+		So(response.BatchItemFailures, ShouldResemble, []BatchItemFailure{{ItemIdentifier: "m2"}, {ItemIdentifier: "m3"}})
+		So(metricValue(report, "@iopipe/batch.skipped"), ShouldEqual, 1)
+	})
+
+	Convey("Batch fails records that panic", t, func() {
+		response, err, report := runBatch([]byte(sqsBatchEvent), func(ctx context.Context, record *BatchRecord) error {
+			if record.ID == "m1" {
+				panic("oh no")
+			}
+			return nil
+		})
+
+		So(err, ShouldBeNil)
+		So(response.BatchItemFailures, ShouldResemble, []BatchItemFailure{{ItemIdentifier: "m1"}})
+		So(metricValue(report, "@iopipe/batch.error.0"), ShouldEqual, "m1: panic: oh no")
+	})
+
+	Convey("Batch skips and retries records once the context is done", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		calls := 0
+		response, err := (*ContextWrapper)(nil).Batch(ctx, json.RawMessage(sqsBatchEvent), func(ctx context.Context, record *BatchRecord) error {
+			calls++
+			return nil
+		})
+
+		So(err, ShouldBeNil)
+		So(calls, ShouldEqual, 0)
+		So(response.BatchItemFailures, ShouldHaveLength, 3)
+	})
+}
+
+func TestBatch_Streams(t *testing.T) {
+	Convey("Batch stops at the first failed Kinesis record and retries from it", t, func() {
+		var bodies []string
+
+		response, err, report := runBatch(json.RawMessage(kinesisBatchEvent), func(ctx context.Context, record *BatchRecord) error {
+			bodies = append(bodies, string(record.Body))
+			if record.ID == "102" {
+				return errors.New("whoops")
+			}
+			return nil
+		})
+
+		So(err, ShouldBeNil)
+		So(bodies, ShouldResemble, []string{"one", "two"})
+		So(response.BatchItemFailures, ShouldResemble, []BatchItemFailure{{ItemIdentifier: "102"}})
+		So(metricValue(report, "@iopipe/batch.source"), ShouldEqual, BatchSourceKinesis)
+		So(metricValue(report, "@iopipe/batch.successes"), ShouldEqual, 1)
+		So(metricValue(report, "@iopipe/batch.failures"), ShouldEqual, 1)
+		So(metricValue(report, "@iopipe/batch.skipped"), ShouldEqual, 1)
+	})
+
+	Convey("Batch passes the DynamoDB stream record as the body", t, func() {
+		var body string
+
+		response, err, _ := runBatch(json.RawMessage(dynamoDBBatchEvent), func(ctx context.Context, record *BatchRecord) error {
+			body = string(record.Body)
+			So(record.ID, ShouldEqual, "201")
+			So(record.Source, ShouldEqual, BatchSourceDynamoDB)
+			return nil
+		})
+
+		So(err, ShouldBeNil)
+		So(body, ShouldEqual, `{"SequenceNumber": "201", "Keys": {"id": {"S": "1"}}}`)
+		So(response.BatchItemFailures, ShouldBeEmpty)
+	})
+}
+
+func TestBatch_parseBatchEvent(t *testing.T) {
+	Convey("Events that aren't batch events are rejected", t, func() {
+		_, err := parseBatchEvent(json.RawMessage(`{"httpMethod": "GET"}`))
+		So(err, ShouldNotBeNil)
+
+		_, err = parseBatchEvent(json.RawMessage(`{"Records": [{"eventSource": "aws:s3"}]}`))
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("An empty batch has no records", t, func() {
+		records, err := parseBatchEvent(json.RawMessage(`{"Records": []}`))
+		So(err, ShouldBeNil)
+		So(records, ShouldBeEmpty)
+	})
+}
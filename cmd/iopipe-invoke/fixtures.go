@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// fixtures are the built-in sample events, keyed by name
+var fixtures = map[string]string{
+	"apigateway": apiGatewayFixture,
+	"s3":         s3Fixture,
+	"sqs":        sqsFixture,
+}
+
+// fixtureNames returns the sorted names of the built-in fixtures
+func fixtureNames() []string {
+	names := make([]string, 0, len(fixtures))
+	for name := range fixtures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// loadEvent returns the event payload from a built-in fixture, a file, stdin ("-") or an inline JSON string
+func loadEvent(fixture, path, inline string) ([]byte, error) {
+	switch {
+	case fixture != "":
+		event, ok := fixtures[fixture]
+		if !ok {
+			return nil, fmt.Errorf("unknown fixture %q, available fixtures: %s", fixture, strings.Join(fixtureNames(), ", "))
+		}
+
+		return []byte(event), nil
+	case path == "-":
+		return ioutil.ReadAll(os.Stdin)
+	case path != "":
+		return ioutil.ReadFile(path)
+	case inline != "":
+		return []byte(inline), nil
+	default:
+		return []byte("{}"), nil
+	}
+}
+
+const apiGatewayFixture = `{
+  "resource": "/{proxy+}",
+  "path": "/hello/world",
+  "httpMethod": "GET",
+  "headers": {
+    "Accept": "application/json",
+    "Host": "1234567890.execute-api.us-east-1.amazonaws.com",
+    "User-Agent": "iopipe-invoke",
+    "X-Forwarded-Proto": "https"
+  },
+  "multiValueHeaders": {
+    "Accept": ["application/json"],
+    "Host": ["1234567890.execute-api.us-east-1.amazonaws.com"],
+    "User-Agent": ["iopipe-invoke"],
+    "X-Forwarded-Proto": ["https"]
+  },
+  "queryStringParameters": {"name": "me"},
+  "multiValueQueryStringParameters": {"name": ["me"]},
+  "pathParameters": {"proxy": "hello/world"},
+  "stageVariables": null,
+  "requestContext": {
+    "accountId": "123456789012",
+    "resourceId": "123456",
+    "stage": "prod",
+    "requestId": "c6af9ac6-7b61-11e6-9a41-93e8deadbeef",
+    "identity": {
+      "sourceIp": "127.0.0.1",
+      "userAgent": "iopipe-invoke"
+    },
+    "resourcePath": "/{proxy+}",
+    "httpMethod": "GET",
+    "apiId": "1234567890"
+  },
+  "body": "",
+  "isBase64Encoded": false
+}`
+
+const s3Fixture = `{
+  "Records": [
+    {
+      "eventVersion": "2.0",
+      "eventSource": "aws:s3",
+      "awsRegion": "us-east-1",
+      "eventTime": "1970-01-01T00:00:00.123Z",
+      "eventName": "ObjectCreated:Put",
+      "userIdentity": {"principalId": "EXAMPLE"},
+      "requestParameters": {"sourceIPAddress": "127.0.0.1"},
+      "responseElements": {
+        "x-amz-request-id": "C3D13FE58DE4C810",
+        "x-amz-id-2": "FMyUVURIY8/IgAtTv8xRjskZQpcIZ9KG4V5Wp6S7S/JRWeUWerMUE5JgHvANOjpD"
+      },
+      "s3": {
+        "s3SchemaVersion": "1.0",
+        "configurationId": "testConfigRule",
+        "bucket": {
+          "name": "sourcebucket",
+          "ownerIdentity": {"principalId": "EXAMPLE"},
+          "arn": "arn:aws:s3:::sourcebucket"
+        },
+        "object": {
+          "key": "HappyFace.jpg",
+          "size": 1024,
+          "eTag": "d41d8cd98f00b204e9800998ecf8427e",
+          "sequencer": "0A1B2C3D4E5F678901"
+        }
+      }
+    }
+  ]
+}`
+
+const sqsFixture = `{
+  "Records": [
+    {
+      "messageId": "19dd0b57-b21e-4ac1-bd88-01bbb068cb78",
+      "receiptHandle": "MessageReceiptHandle",
+      "body": "Hello from SQS!",
+      "attributes": {
+        "ApproximateReceiveCount": "1",
+        "SentTimestamp": "1523232000000",
+        "SenderId": "123456789012",
+        "ApproximateFirstReceiveTimestamp": "1523232000001"
+      },
+      "messageAttributes": {},
+      "md5OfBody": "7b270e59b47ff90a553787216d55d91d",
+      "eventSource": "aws:sqs",
+      "eventSourceARN": "arn:aws:sqs:us-east-1:123456789012:MyQueue",
+      "awsRegion": "us-east-1"
+    }
+  ]
+}`
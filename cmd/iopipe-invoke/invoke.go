@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/rpc"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda/messages"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+const (
+	functionInvokeRPC = "Function.Invoke"
+	functionPingRPC   = "Function.Ping"
+)
+
+// InvokeConfig is the configuration of a single RPC invocation
+type InvokeConfig struct {
+	ClientContext      *lambdacontext.ClientContext
+	InvokedFunctionArn string
+	RequestID          string
+	Timeout            time.Duration
+	TraceID            string
+}
+
+// waitForFunction pings the function at addr until it responds or the wait time elapses
+func waitForFunction(addr string, wait time.Duration) error {
+	var err error
+
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) {
+		var client *rpc.Client
+
+		client, err = rpc.Dial("tcp", addr)
+		if err == nil {
+			err = client.Call(functionPingRPC, &messages.PingRequest{}, &messages.PingResponse{})
+			client.Close()
+
+			if err == nil {
+				return nil
+			}
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return fmt.Errorf("function at %s did not respond within %s: %v", addr, wait, err)
+}
+
+// invoke calls the function at addr over RPC with the payload and returns the response payload
+func invoke(addr string, payload []byte, config InvokeConfig) ([]byte, error) {
+	request, err := createInvokeRequest(payload, config, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := rpc.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	var response messages.InvokeResponse
+
+	if err = client.Call(functionInvokeRPC, request, &response); err != nil {
+		return nil, err
+	}
+
+	if response.Error != nil {
+		return response.Payload, errors.New(response.Error.Message)
+	}
+
+	return response.Payload, nil
+}
+
+func createInvokeRequest(payload []byte, config InvokeConfig, now time.Time) (*messages.InvokeRequest, error) {
+	if !json.Valid(payload) {
+		return nil, fmt.Errorf("event payload is not valid JSON")
+	}
+
+	var clientContextEncoded []byte
+	if config.ClientContext != nil {
+		b, err := json.Marshal(config.ClientContext)
+		if err != nil {
+			return nil, err
+		}
+
+		clientContextEncoded = b
+	}
+
+	deadline := now.Add(config.Timeout)
+
+	return &messages.InvokeRequest{
+		Payload:      payload,
+		RequestId:    config.RequestID,
+		XAmznTraceId: config.TraceID,
+		Deadline: messages.InvokeRequest_Timestamp{
+			Seconds: deadline.Unix(),
+			Nanos:   int64(deadline.Nanosecond()),
+		},
+		InvokedFunctionArn: config.InvokedFunctionArn,
+		ClientContext:      clientContextEncoded,
+	}, nil
+}
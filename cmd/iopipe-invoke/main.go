@@ -0,0 +1,288 @@
+// Command iopipe-invoke invokes a Go Lambda function binary locally over RPC
+// and prints the handler response along with the IOpipe reports it sent.
+//
+// Launch a handler binary and invoke it with a built-in API Gateway event:
+//
+//	iopipe-invoke -binary ./bin/hello -fixture apigateway
+//
+// Or attach to a handler already listening on _LAMBDA_SERVER_PORT=8001, started
+// with AWS_REGION=mock and MOCK_SERVER=http://127.0.0.1:8002:
+//
+//	iopipe-invoke -port 8001 -collector 127.0.0.1:8002 -event event.json
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/iopipe/iopipe-go/mockcollector"
+)
+
+type options struct {
+	binary        string
+	port          int
+	collectorAddr string
+	collectorDir  string
+	fixture       string
+	eventPath     string
+	event         string
+	timeout       time.Duration
+	functionName  string
+	arn           string
+	requestID     string
+	clientContext string
+	memorySize    int
+	startWait     time.Duration
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	opts, err := parseFlags(args, stderr)
+	if err != nil {
+		return 2
+	}
+
+	payload, err := loadEvent(opts.fixture, opts.eventPath, opts.event)
+	if err != nil {
+		fmt.Fprintln(stderr, "error loading event:", err)
+		return 1
+	}
+
+	clientContext, err := parseClientContext(opts.clientContext)
+	if err != nil {
+		fmt.Fprintln(stderr, "error loading client context:", err)
+		return 1
+	}
+
+	c, err := mockcollector.New(mockcollector.Config{Dir: opts.collectorDir})
+	if err != nil {
+		fmt.Fprintln(stderr, "error loading persisted reports:", err)
+		return 1
+	}
+
+	if err := c.Start(opts.collectorAddr); err != nil {
+		fmt.Fprintln(stderr, "error starting collector:", err)
+		return 1
+	}
+	defer c.Close()
+
+	port := opts.port
+	if opts.binary != "" {
+		if port == 0 {
+			port, err = freePort()
+			if err != nil {
+				fmt.Fprintln(stderr, "error finding free port:", err)
+				return 1
+			}
+		}
+
+		cmd := functionCommand(opts, port, c.URL(), stderr)
+		if err := cmd.Start(); err != nil {
+			fmt.Fprintln(stderr, "error launching function:", err)
+			return 1
+		}
+		defer stopFunction(cmd, stderr)
+	} else {
+		fmt.Fprintf(stderr, "attaching to function on port %d, collector listening on %s\n", port, c.URL())
+	}
+
+	addr := fmt.Sprintf("127.0.0.1:%d", port)
+	if err := waitForFunction(addr, opts.startWait); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	response, invokeErr := invoke(addr, payload, InvokeConfig{
+		ClientContext:      clientContext,
+		InvokedFunctionArn: opts.arn,
+		RequestID:          opts.requestID,
+		Timeout:            opts.timeout,
+	})
+
+	fmt.Fprintln(stdout, "Response:")
+	fmt.Fprintln(stdout, indentJSON(response))
+
+	if invokeErr != nil {
+		fmt.Fprintln(stdout, "Error:")
+		fmt.Fprintln(stdout, invokeErr)
+	}
+
+	fmt.Fprintln(stdout, "Reports:")
+	for _, received := range c.ReportsFor(opts.requestID) {
+		fmt.Fprintln(stdout, indentJSON(received.Raw))
+
+		for _, err := range received.Errors {
+			fmt.Fprintln(stderr, "invalid report:", err)
+		}
+	}
+
+	for _, upload := range c.UploadsFor(opts.requestID) {
+		fmt.Fprintf(stdout, "Upload %s:\n", upload.Key)
+		fmt.Fprintln(stdout, string(upload.Body))
+	}
+
+	if invokeErr != nil {
+		return 1
+	}
+
+	return 0
+}
+
+func parseFlags(args []string, stderr io.Writer) (*options, error) {
+	opts := &options{}
+
+	fs := flag.NewFlagSet("iopipe-invoke", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	fs.StringVar(&opts.binary, "binary", "", "path to the handler binary to launch")
+	fs.IntVar(&opts.port, "port", 0, "RPC port of the function (_LAMBDA_SERVER_PORT), required when attaching")
+	fs.StringVar(&opts.collectorAddr, "collector", "127.0.0.1:0", "listen address of the local collector")
+	fs.StringVar(&opts.collectorDir, "dir", "", "directory to persist received reports and uploads to")
+	fs.StringVar(&opts.fixture, "fixture", "", fmt.Sprintf("built-in event fixture (%s)", strings.Join(fixtureNames(), ", ")))
+	fs.StringVar(&opts.eventPath, "event", "", "path to an event JSON file, or - for stdin")
+	fs.StringVar(&opts.event, "payload", "", "inline event JSON")
+	fs.DurationVar(&opts.timeout, "timeout", 3*time.Second, "function timeout used for the invocation deadline")
+	fs.StringVar(&opts.functionName, "function-name", "", "function name (defaults to the binary name)")
+	fs.StringVar(&opts.arn, "arn", "", "invoked function ARN")
+	fs.StringVar(&opts.requestID, "request-id", "", "AWS request ID (defaults to a timestamp based ID)")
+	fs.StringVar(&opts.clientContext, "client-context", "", "client context JSON, or @path to read it from a file")
+	fs.IntVar(&opts.memorySize, "memory", 128, "function memory size in MB")
+	fs.DurationVar(&opts.startWait, "start-wait", 10*time.Second, "how long to wait for the function to accept connections")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if opts.binary == "" && opts.port == 0 {
+		fmt.Fprintln(stderr, "either -binary or -port is required")
+		fs.Usage()
+		return nil, flag.ErrHelp
+	}
+
+	// A function started elsewhere has to be pointed at the collector with
+	// MOCK_SERVER, so the collector can't listen on a random port
+	if opts.binary == "" && !flagSet(fs, "collector") {
+		fmt.Fprintln(stderr, "-collector is required with -port, start the function with MOCK_SERVER set to it")
+		fs.Usage()
+		return nil, flag.ErrHelp
+	}
+
+	if opts.functionName == "" {
+		opts.functionName = "iopipe-invoke"
+		if opts.binary != "" {
+			opts.functionName = filepath.Base(opts.binary)
+		}
+	}
+
+	if opts.arn == "" {
+		opts.arn = fmt.Sprintf("arn:aws:lambda:local:0:function:%s", opts.functionName)
+	}
+
+	if opts.requestID == "" {
+		opts.requestID = fmt.Sprintf("iopipe-invoke-%d", time.Now().UnixNano())
+	}
+
+	return opts, nil
+}
+
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
+}
+
+func parseClientContext(value string) (*lambdacontext.ClientContext, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	data := []byte(value)
+	if strings.HasPrefix(value, "@") {
+		var err error
+
+		data, err = ioutil.ReadFile(value[1:])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var clientContext lambdacontext.ClientContext
+	if err := json.Unmarshal(data, &clientContext); err != nil {
+		return nil, err
+	}
+
+	return &clientContext, nil
+}
+
+// functionCommand returns the command that launches the handler binary in RPC mode
+func functionCommand(opts *options, port int, collectorURL string, stderr io.Writer) *exec.Cmd {
+	cmd := exec.Command(opts.binary)
+	cmd.Stdout = stderr
+	cmd.Stderr = stderr
+
+	env := []string{
+		"_LAMBDA_SERVER_PORT=" + strconv.Itoa(port),
+		"AWS_REGION=mock",
+		"MOCK_SERVER=" + collectorURL,
+		"AWS_LAMBDA_FUNCTION_NAME=" + opts.functionName,
+		"AWS_LAMBDA_FUNCTION_VERSION=$LATEST",
+		"AWS_LAMBDA_FUNCTION_MEMORY_SIZE=" + strconv.Itoa(opts.memorySize),
+	}
+
+	if os.Getenv("IOPIPE_TOKEN") == "" {
+		env = append(env, "IOPIPE_TOKEN=iopipe-invoke")
+	}
+
+	cmd.Env = append(os.Environ(), env...)
+
+	return cmd
+}
+
+// stopFunction kills the launched function and waits for it, so the process is
+// reaped and how it exited is reported.
+func stopFunction(cmd *exec.Cmd, stderr io.Writer) {
+	cmd.Process.Kill()
+	cmd.Wait()
+
+	fmt.Fprintln(stderr, "function exited:", cmd.ProcessState)
+}
+
+func freePort() (int, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+func indentJSON(data []byte) string {
+	var out bytes.Buffer
+
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		return string(data)
+	}
+
+	return out.String()
+}
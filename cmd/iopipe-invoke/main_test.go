@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/iopipe/iopipe-go"
+	"github.com/iopipe/iopipe-go/mockcollector"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInvoke_createInvokeRequest(t *testing.T) {
+	Convey("An invoke request carries the configured deadline, ARN and client context", t, func() {
+		now := time.Unix(1000, 500)
+		request, err := createInvokeRequest([]byte(`{"foo":"bar"}`), InvokeConfig{
+			ClientContext:      &lambdacontext.ClientContext{Custom: map[string]string{"foo": "bar"}},
+			InvokedFunctionArn: "arn:aws:lambda:us-east-1:123456789012:function:hello",
+			RequestID:          "abc-123",
+			Timeout:            3 * time.Second,
+		}, now)
+
+		So(err, ShouldBeNil)
+		So(request.RequestId, ShouldEqual, "abc-123")
+		So(request.InvokedFunctionArn, ShouldEqual, "arn:aws:lambda:us-east-1:123456789012:function:hello")
+		So(request.Deadline.Seconds, ShouldEqual, 1003)
+		So(request.Deadline.Nanos, ShouldEqual, 500)
+		So(string(request.ClientContext), ShouldContainSubstring, `"foo":"bar"`)
+	})
+
+	Convey("An invalid payload is rejected", t, func() {
+		_, err := createInvokeRequest([]byte(`{`), InvokeConfig{}, time.Now())
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestFlags_parseFlags(t *testing.T) {
+	Convey("Attaching to a function requires an explicit collector address", t, func() {
+		stderr := &bytes.Buffer{}
+		_, err := parseFlags([]string{"-port", "8001"}, stderr)
+		So(err, ShouldEqual, flag.ErrHelp)
+		So(stderr.String(), ShouldContainSubstring, "-collector is required with -port")
+
+		opts, err := parseFlags([]string{"-port", "8001", "-collector", "127.0.0.1:8002"}, ioutil.Discard)
+		So(err, ShouldBeNil)
+		So(opts.collectorAddr, ShouldEqual, "127.0.0.1:8002")
+	})
+
+	Convey("A launched function can use a collector on a random port", t, func() {
+		opts, err := parseFlags([]string{"-binary", "bin/hello"}, ioutil.Discard)
+		So(err, ShouldBeNil)
+		So(opts.collectorAddr, ShouldEqual, "127.0.0.1:0")
+	})
+}
+
+func TestFixtures_loadEvent(t *testing.T) {
+	Convey("Built-in fixtures are valid JSON", t, func() {
+		for _, name := range fixtureNames() {
+			event, err := loadEvent(name, "", "")
+			So(err, ShouldBeNil)
+			So(json.Valid(event), ShouldBeTrue)
+		}
+	})
+
+	Convey("Unknown fixtures are an error", t, func() {
+		_, err := loadEvent("nope", "", "")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Inline payloads and the empty default are supported", t, func() {
+		event, _ := loadEvent("", "", `"hi"`)
+		So(string(event), ShouldEqual, `"hi"`)
+
+		event, _ = loadEvent("", "", "")
+		So(string(event), ShouldEqual, "{}")
+	})
+}
+
+func TestInvoke_invoke(t *testing.T) {
+	Convey("Given a wrapped handler served over RPC and a local collector", t, func() {
+		c, err := mockcollector.New(mockcollector.Config{})
+		So(err, ShouldBeNil)
+		So(c.Start("127.0.0.1:0"), ShouldBeNil)
+		defer c.Close()
+
+		oldRegion := os.Getenv("AWS_REGION")
+		oldMockServer := os.Getenv("MOCK_SERVER")
+		defer os.Setenv("AWS_REGION", oldRegion)
+		defer os.Setenv("MOCK_SERVER", oldMockServer)
+		os.Setenv("AWS_REGION", "mock")
+		os.Setenv("MOCK_SERVER", c.URL())
+
+		token := "iopipe-invoke"
+		agent := iopipe.NewAgent(iopipe.Config{Token: &token})
+		handler := agent.WrapHandler(func(ctx context.Context, payload map[string]string) (string, error) {
+			if payload["fail"] != "" {
+				return "", errors.New(payload["fail"])
+			}
+
+			return "hello " + payload["name"], nil
+		})
+
+		server := rpc.NewServer()
+		server.Register(lambda.NewFunction(lambda.NewHandler(handler)))
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer listener.Close()
+		go server.Accept(listener)
+
+		addr := listener.Addr().String()
+		So(waitForFunction(addr, time.Second), ShouldBeNil)
+
+		Convey("The response and report are captured", func() {
+			response, err := invoke(addr, []byte(`{"name":"world"}`), InvokeConfig{
+				InvokedFunctionArn: "arn:aws:lambda:local:0:function:hello",
+				RequestID:          "abc-123",
+				Timeout:            3 * time.Second,
+			})
+
+			So(err, ShouldBeNil)
+			So(string(response), ShouldEqual, `"hello world"`)
+
+			reports := c.ReportsFor("abc-123")
+			So(len(reports), ShouldEqual, 1)
+			So(reports[0].Valid, ShouldBeTrue)
+			So(reports[0].Report.AWS.InvokedFunctionArn, ShouldEqual, "arn:aws:lambda:local:0:function:hello")
+		})
+
+		Convey("Handler errors are returned", func() {
+			_, err := invoke(addr, []byte(`{"fail":"whoops"}`), InvokeConfig{RequestID: "def-456", Timeout: 3 * time.Second})
+
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "whoops")
+		})
+	})
+}
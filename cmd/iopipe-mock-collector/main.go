@@ -0,0 +1,47 @@
+// Command iopipe-mock-collector runs a mock IOpipe collector and signer for
+// local development:
+//
+//	iopipe-mock-collector -addr 127.0.0.1:8002 -dir .mock-collector
+//
+// Point an agent at it by setting AWS_REGION=mock and
+// MOCK_SERVER=http://127.0.0.1:8002, then query the received reports with
+// GET /reports.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/iopipe/iopipe-go/mockcollector"
+)
+
+func main() {
+	var (
+		addr  = flag.String("addr", "127.0.0.1:8002", "listen address")
+		dir   = flag.String("dir", "", "directory to persist reports and uploads to")
+		token = flag.String("token", "", "project token to require, any token is accepted if empty")
+	)
+	flag.Parse()
+
+	c, err := mockcollector.New(mockcollector.Config{Dir: *dir, Token: *token})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error loading persisted reports:", err)
+		os.Exit(1)
+	}
+
+	if err := c.Start(*addr); err != nil {
+		fmt.Fprintln(os.Stderr, "error starting collector:", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+
+	fmt.Fprintf(os.Stderr, "mock collector listening on %s\n", c.URL())
+	fmt.Fprintf(os.Stderr, "export AWS_REGION=mock MOCK_SERVER=%s\n", c.URL())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+}
@@ -0,0 +1,127 @@
+package iopipe
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iopipe/iopipe-go/internal/coldstart"
+)
+
+// initPhase is a phase of the function's initialization timed by user code
+type initPhase struct {
+	name     string
+	duration time.Duration
+}
+
+var (
+	initPhasesMutex sync.Mutex
+
+	// initPhases are the phases ended before the cold start report
+	initPhases []initPhase
+
+	// initPhasesClosed is true once the cold start report took the phases
+	initPhasesClosed bool
+)
+
+// StartInitPhase starts timing a phase of the function's initialization, such
+// as creating SDK clients or loading config, and returns the function ending
+// it. Phases ended before the cold start invocation finishes are reported as
+// @iopipe/coldstart.phase.<name>-ms custom metrics, later ones are dropped.
+func StartInitPhase(name string) func() {
+	start := time.Now()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			addInitPhase(initPhase{name: name, duration: time.Since(start)})
+		})
+	}
+}
+
+// addInitPhase records an ended phase, replacing an earlier one of the same
+// name
+func addInitPhase(phase initPhase) {
+	initPhasesMutex.Lock()
+	defer initPhasesMutex.Unlock()
+
+	if initPhasesClosed {
+		return
+	}
+
+	for index := range initPhases {
+		if initPhases[index].name == phase.name {
+			initPhases[index] = phase
+			return
+		}
+	}
+
+	initPhases = append(initPhases, phase)
+}
+
+// takeInitPhases returns the ended phases, dropping those ended later
+func takeInitPhases() []initPhase {
+	initPhasesMutex.Lock()
+	defer initPhasesMutex.Unlock()
+
+	phases := initPhases
+	initPhases = nil
+	initPhasesClosed = true
+
+	return phases
+}
+
+// reopenInitPhases records phases again, for the next cold start
+func reopenInitPhases() {
+	initPhasesMutex.Lock()
+	defer initPhasesMutex.Unlock()
+
+	initPhasesClosed = false
+}
+
+func init() {
+	coldstart.OnReset(reopenInitPhases)
+}
+
+// recordColdStart labels cold start reports and adds the breakdown, once per
+// invocation, before the report is sent by the handler returning, erroring,
+// panicking or timing out
+func (hw *HandlerWrapper) recordColdStart() {
+	if !hw.coldStart || !atomic.CompareAndSwapInt32(&hw.coldStartRecorded, 0, 1) {
+		return
+	}
+
+	hw.Label("@iopipe/coldstart")
+	hw.coldStartMetrics()
+}
+
+// coldStartMetrics adds the cold start breakdown to the report: the time from
+// process start to the package being loaded, the agent being ready and the
+// handler being entered, and the durations of the init phases
+func (hw *HandlerWrapper) coldStartMetrics() {
+	if !processStart.IsZero() {
+		hw.Metric("@iopipe/coldstart.package-load-ms", sinceProcessStart(loadedAt))
+		if hw.agent != nil && !hw.agent.readyAt.IsZero() {
+			hw.Metric("@iopipe/coldstart.agent-ready-ms", sinceProcessStart(hw.agent.readyAt))
+		}
+		hw.Metric("@iopipe/coldstart.handler-entry-ms", sinceProcessStart(hw.report.startTime))
+	}
+
+	for _, phase := range takeInitPhases() {
+		hw.Metric("@iopipe/coldstart.phase."+phase.name+"-ms", milliseconds(phase.duration))
+	}
+}
+
+// sinceProcessStart returns the milliseconds from process start to t
+func sinceProcessStart(t time.Time) float64 {
+	if t.Before(processStart) {
+		return 0
+	}
+
+	return milliseconds(t.Sub(processStart))
+}
+
+// milliseconds returns d in milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,126 @@
+package iopipe
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iopipe/iopipe-go/internal/coldstart"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestColdStart_Metrics(t *testing.T) {
+	invokeWith := func(ctx context.Context, a *Agent, handler func(ctx context.Context) error) *Report {
+		var (
+			mutex    sync.Mutex
+			reported *Report
+		)
+
+		a.Reporter = func(report *Report) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			reported = report
+			return nil
+		}
+
+		NewHandlerWrapper(handler, a).Invoke(ctx, nil)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return reported
+	}
+
+	invoke := func(handler func(ctx context.Context) error) *Report {
+		return invokeWith(context.Background(), NewAgent(Config{}), handler)
+	}
+
+	metrics := func(report *Report) map[string]interface{} {
+		values := make(map[string]interface{})
+		for _, metric := range report.CustomMetrics {
+			values[metric.Name] = metric.N
+		}
+		return values
+	}
+
+	Convey("Cold start reports break down the time since the process started", t, func() {
+		coldstart.Set(true)
+
+		endConfig := StartInitPhase("config")
+		time.Sleep(5 * time.Millisecond)
+		endConfig()
+		endConfig()
+
+		report := invoke(func(ctx context.Context) error {
+			// Phases ended during the cold start invocation are reported too
+			StartInitPhase("client")()
+			return nil
+		})
+
+		values := metrics(report)
+		So(values["@iopipe/coldstart.package-load-ms"], ShouldBeGreaterThanOrEqualTo, 0)
+		So(values["@iopipe/coldstart.agent-ready-ms"], ShouldBeGreaterThanOrEqualTo, values["@iopipe/coldstart.package-load-ms"])
+		So(values["@iopipe/coldstart.handler-entry-ms"], ShouldBeGreaterThanOrEqualTo, values["@iopipe/coldstart.agent-ready-ms"])
+		So(values["@iopipe/coldstart.phase.config-ms"], ShouldBeGreaterThanOrEqualTo, 5)
+		So(values, ShouldContainKey, "@iopipe/coldstart.phase.client-ms")
+		So(report.Labels, ShouldNotContain, "@iopipe/metrics")
+		So(report.Validate(), ShouldBeNil)
+
+		Convey("Warm reports have no breakdown and later phases are dropped", func() {
+			StartInitPhase("late")()
+
+			coldstart.Set(false)
+			report := invoke(func(ctx context.Context) error { return nil })
+			So(report.CustomMetrics, ShouldBeEmpty)
+
+			coldstart.Set(true)
+			report = invoke(func(ctx context.Context) error { return nil })
+			So(metrics(report), ShouldNotContainKey, "@iopipe/coldstart.phase.late-ms")
+			So(metrics(report), ShouldContainKey, "@iopipe/coldstart.handler-entry-ms")
+		})
+	})
+
+	Convey("Cold start reports sent by a panic have the breakdown", t, func() {
+		coldstart.Set(true)
+
+		var report *Report
+		a := NewAgent(Config{
+			Reporter: func(reported *Report) error {
+				report = reported
+				return nil
+			},
+		})
+
+		So(func() {
+			NewHandlerWrapper(func(ctx context.Context) error {
+				panic("boom")
+			}, a).Invoke(context.Background(), nil)
+		}, ShouldPanicWith, "boom")
+
+		So(report.Labels, ShouldContain, "@iopipe/error")
+		So(report.Labels, ShouldContain, "@iopipe/coldstart")
+		So(metrics(report), ShouldContainKey, "@iopipe/coldstart.handler-entry-ms")
+	})
+
+	Convey("Cold start reports sent by a timeout have the breakdown", t, func() {
+		coldstart.Set(true)
+
+		a := NewAgent(Config{})
+		timeoutWindow := 60 * time.Millisecond
+		a.TimeoutWindow = &timeoutWindow
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		report := invokeWith(ctx, a, func(ctx context.Context) error {
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		})
+
+		So(report.Labels, ShouldContain, "@iopipe/timeout")
+		So(report.Labels, ShouldContain, "@iopipe/coldstart")
+		So(metrics(report), ShouldContainKey, "@iopipe/coldstart.handler-entry-ms")
+	})
+}
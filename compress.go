@@ -0,0 +1,106 @@
+package iopipe
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+const (
+	// CompressionDeflate compresses payloads with zlib wrapped deflate, as
+	// HTTP's deflate Content-Encoding is defined
+	CompressionDeflate = "deflate"
+	// CompressionGzip compresses payloads with gzip
+	CompressionGzip = "gzip"
+)
+
+func isSupportedEncoding(encoding string) bool {
+	switch encoding {
+	case "", CompressionDeflate, CompressionGzip:
+		return true
+	default:
+		return false
+	}
+}
+
+// compressPayload compresses data with encoding if it is at least threshold
+// bytes, returning the payload and the Content-Encoding it was sent with
+func compressPayload(data []byte, encoding string, threshold int) ([]byte, string, error) {
+	if encoding == "" || len(data) < threshold {
+		return data, "", nil
+	}
+
+	var (
+		buf bytes.Buffer
+		w   io.WriteCloser
+	)
+
+	switch encoding {
+	case CompressionGzip:
+		w = gzip.NewWriter(&buf)
+	case CompressionDeflate:
+		w = zlib.NewWriter(&buf)
+	default:
+		return nil, "", fmt.Errorf("Unsupported compression: %s", encoding)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, "", err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), encoding, nil
+}
+
+// DecompressPayload decompresses data sent with the Content-Encoding encoding
+func DecompressPayload(data []byte, encoding string) ([]byte, error) {
+	var r io.ReadCloser
+
+	switch encoding {
+	case "", "identity":
+		return data, nil
+	case CompressionGzip:
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		r = gr
+	case CompressionDeflate:
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	default:
+		return nil, fmt.Errorf("Unsupported compression: %s", encoding)
+	}
+
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+// compressionSettings returns the agent's compression and threshold
+func (a *Agent) compressionSettings() (string, int) {
+	if a == nil || a.Config == nil {
+		return defaultConfigCompression, defaultConfigCompressionThreshold
+	}
+
+	compression := defaultConfigCompression
+	if a.Compression != nil {
+		compression = *a.Compression
+	}
+
+	threshold := defaultConfigCompressionThreshold
+	if a.CompressionThreshold != nil {
+		threshold = *a.CompressionThreshold
+	}
+
+	return compression, threshold
+}
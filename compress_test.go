@@ -0,0 +1,62 @@
+package iopipe
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCompress_compressPayload(t *testing.T) {
+	data := []byte(strings.Repeat(`{"message":"hello there"}`, 100))
+
+	Convey("Payloads are compressed and decompressed with each encoding", t, func() {
+		for _, encoding := range []string{CompressionGzip, CompressionDeflate} {
+			compressed, contentEncoding, err := compressPayload(data, encoding, 0)
+			So(err, ShouldBeNil)
+			So(contentEncoding, ShouldEqual, encoding)
+			So(len(compressed), ShouldBeLessThan, len(data))
+
+			decompressed, err := DecompressPayload(compressed, contentEncoding)
+			So(err, ShouldBeNil)
+			So(string(decompressed), ShouldEqual, string(data))
+		}
+	})
+
+	Convey("Deflate payloads are zlib wrapped, as HTTP defines deflate", t, func() {
+		compressed, _, err := compressPayload(data, CompressionDeflate, 0)
+		So(err, ShouldBeNil)
+
+		r, err := zlib.NewReader(bytes.NewReader(compressed))
+		So(err, ShouldBeNil)
+
+		decompressed, err := ioutil.ReadAll(r)
+		So(err, ShouldBeNil)
+		So(string(decompressed), ShouldEqual, string(data))
+	})
+
+	Convey("Payloads under the threshold are not compressed", t, func() {
+		payload, contentEncoding, err := compressPayload(data, CompressionGzip, len(data)+1)
+		So(err, ShouldBeNil)
+		So(contentEncoding, ShouldEqual, "")
+		So(string(payload), ShouldEqual, string(data))
+	})
+
+	Convey("Payloads are not compressed when compression is disabled", t, func() {
+		payload, contentEncoding, err := compressPayload(data, "", 0)
+		So(err, ShouldBeNil)
+		So(contentEncoding, ShouldEqual, "")
+		So(string(payload), ShouldEqual, string(data))
+	})
+
+	Convey("Unsupported encodings return an error", t, func() {
+		_, _, err := compressPayload(data, "brotli", 0)
+		So(err, ShouldNotBeNil)
+
+		_, err = DecompressPayload(data, "brotli")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -3,20 +3,25 @@ package iopipe
 import (
 	"runtime"
 	"time"
+
+	"github.com/iopipe/iopipe-go/internal/coldstart"
 )
 
 var (
 	// bootID is the kernel's boot_id
 	bootID = readBootID()
 
-	// coldStart is true if this is a cold start
-	coldStart = true
-
 	// hostname is the system's hostname
 	hostname = readHostname()
 
+	// loadedAt is when the module was loaded
+	loadedAt = time.Now()
+
 	// loadTime is the unix time the module was loaded
-	loadTime = int(time.Now().UnixNano() / 1e6)
+	loadTime = int(loadedAt.UnixNano() / 1e6)
+
+	// processStart is when the process started, zero if unknown
+	processStart = readProcessStart()
 
 	// processID is the ID for this process
 	processID = generateUUID()
@@ -32,3 +37,9 @@ const (
 	// RUNTIME is the runtime of the IOpipe agent
 	RUNTIME = "go"
 )
+
+// takeColdStart returns true for the first invocation only, even if several
+// start concurrently
+func takeColdStart() bool {
+	return coldstart.Take()
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
@@ -14,12 +15,25 @@ import (
 
 // HandlerWrapper is the IOpipe handler wrapper
 type HandlerWrapper struct {
-	agent           *Agent
-	deadline        time.Time
-	lambdaContext   *lambdacontext.LambdaContext
-	originalHandler interface{}
-	report          *Report
-	wrappedHandler  lambdaHandler
+	agent             *Agent
+	background        []*backgroundTask
+	backgroundMutex   sync.Mutex
+	backgroundWaited  bool
+	backgroundWaiting bool
+	cancel            context.CancelFunc
+	coldStart         bool
+	coldStartRecorded int32
+	deadline          time.Time
+	hooks             []map[string]PluginHookMeta
+	hooksMutex        sync.Mutex
+	invocationContext context.Context
+	lambdaContext     *lambdacontext.LambdaContext
+	metadata          *InvocationMetadata
+	originalHandler   interface{}
+	pluginState       map[Plugin]interface{}
+	pluginStateMutex  sync.RWMutex
+	report            *Report
+	wrappedHandler    lambdaHandler
 
 	Log *log.Logger
 }
@@ -37,73 +51,121 @@ func NewHandlerWrapper(handler interface{}, agent *Agent) *HandlerWrapper {
 // Invoke invokes the wrapped handler, handling panics and timeouts
 func (hw *HandlerWrapper) Invoke(ctx context.Context, payload interface{}) (response interface{}, err error) {
 	lc, _ := lambdacontext.FromContext(ctx)
+	ctx = hw.start(ctx, lc, payload)
+
+	// Handle and report a panic if it occurs
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			hw.reportPanic(panicErr, NewPanicInvocationError(panicErr))
+			panic(panicErr)
+		}
+	}()
+
+	response, err = hw.wrappedHandler(ctx, payload)
+
+	hw.finish(ctx, payload, err)
+
+	return response, err
+}
+
+// reportPanic sends the report of an invocation that panicked. The invocation
+// error is created by the deferred function recovering the panic, so its stack
+// starts where the panic was raised.
+func (hw *HandlerWrapper) reportPanic(panicErr interface{}, invocationError *InvocationError) {
+	hw.onPanic(panicErr)
+	hw.Label("@iopipe/error")
+	hw.recordColdStart()
+	hw.report.prepare(invocationError)
+	hw.report.send()
+}
+
+// start starts an invocation, creating its report, running the PreInvoke hooks
+// and starting the timeout clock
+func (hw *HandlerWrapper) start(ctx context.Context, lc *lambdacontext.LambdaContext, payload interface{}) context.Context {
 	hw.lambdaContext = lc
 
 	cw := NewContextWrapper(lc, hw)
 	ctx = NewContext(ctx, cw)
 	hw.deadline, _ = ctx.Deadline()
+	hw.invocationContext = ctx
 
+	hw.Log = newInvocationLogger(hw.agent.log)
+	hw.coldStart = takeColdStart()
+	atomic.StoreInt32(&hw.coldStartRecorded, 0)
 	hw.report = NewReport(hw)
 
+	if hw.coldStart {
+		hw.onColdStart(ctx)
+	}
+
 	hw.preInvoke(ctx, payload)
 
-	// Handle and report a panic if it occurs
-	defer func() {
-		if panicErr := recover(); panicErr != nil {
-			hw.Label("@iopipe/error")
-			hw.report.prepare(NewPanicInvocationError(panicErr))
-			hw.report.send()
-			panic(panicErr)
-		}
-	}()
+	go hw.handleTimeout(ctx)
 
-	// Start the timeout clock and handle timeouts
-	go func() {
-		if hw.deadline.IsZero() {
-			hw.Log.Debug("Deadline is zero, disabling timeout handling")
-			return
-		}
-
-		timeoutWindow := 0 * time.Millisecond
+	return ctx
+}
 
-		if hw.agent != nil && hw.agent.TimeoutWindow != nil {
-			timeoutWindow = *hw.agent.TimeoutWindow
-		}
+// handleTimeout sends the report if the invocation is about to time out
+func (hw *HandlerWrapper) handleTimeout(ctx context.Context) {
+	if hw.deadline.IsZero() {
+		hw.Log.Debug("Deadline is zero, disabling timeout handling")
+		return
+	}
 
-		timeoutDuration := hw.deadline.Add(-timeoutWindow)
+	timeoutDuration := hw.timeoutDeadline()
 
-		// If timeout duration is in the past, disable timeout handling
-		if time.Now().After(timeoutDuration) {
-			hw.Log.Debug("Timeout deadline is in the past, disabling timeout handling")
-			return
-		}
+	// If timeout duration is in the past, disable timeout handling
+	if time.Now().After(timeoutDuration) {
+		hw.Log.Debug("Timeout deadline is in the past, disabling timeout handling")
+		return
+	}
 
-		hw.Log.Debug("Setting function to timeout in ", time.Until(timeoutDuration).String())
+	hw.Log.Debug("Setting function to timeout in ", time.Until(timeoutDuration).String())
 
-		timeoutChannel := time.After(time.Until(timeoutDuration))
+	timeoutChannel := time.After(time.Until(timeoutDuration))
 
-		select {
-		// We're within the timeout window
-		case <-timeoutChannel:
-			hw.Log.Debug("Function is about to timeout, sending report")
-			hw.Label("@iopipe/timeout")
-			hw.report.prepare(fmt.Errorf("Timeout Exceeded"))
-			hw.report.send()
-			return
-		case <-ctx.Done():
+	select {
+	// We're within the timeout window
+	case <-timeoutChannel:
+		// The handler has returned and the report is sent once waiting for
+		// its background tasks stops
+		if hw.isWaitingBackground() {
 			return
 		}
-	}()
 
-	response, err = hw.wrappedHandler(ctx, payload)
+		hw.Log.Debug("Function is about to timeout, sending report")
+		hw.onTimeout(ctx)
+		hw.Label("@iopipe/timeout")
+		hw.recordColdStart()
+		hw.report.prepare(fmt.Errorf("Timeout Exceeded"))
+		hw.report.send()
+		return
+	case <-ctx.Done():
+		return
+	}
+}
+
+// timeoutDeadline returns when the invocation is considered about to time
+// out, the deadline less the timeout window
+func (hw *HandlerWrapper) timeoutDeadline() time.Time {
+	timeoutWindow := 0 * time.Millisecond
 
-	if coldStart {
-		hw.Label("@iopipe/coldstart")
+	if hw.agent != nil && hw.agent.TimeoutWindow != nil {
+		timeoutWindow = *hw.agent.TimeoutWindow
 	}
 
-	coldStart = false
+	return hw.deadline.Add(-timeoutWindow)
+}
+
+// finish finishes an invocation, waiting for its background tasks, running
+// the PostInvoke hooks and sending the report
+func (hw *HandlerWrapper) finish(ctx context.Context, payload interface{}, err error) {
+	hw.waitBackground()
+
+	hw.recordColdStart()
 
 	if err != nil {
+		hw.onError(err)
 		hw.Label("@iopipe/error")
 	}
 
@@ -113,8 +175,6 @@ func (hw *HandlerWrapper) Invoke(ctx context.Context, payload interface{}) (resp
 		hw.report.prepare(err)
 		hw.report.send()
 	}
-
-	return response, err
 }
 
 // Error adds an  error to the report
@@ -124,7 +184,9 @@ func (hw *HandlerWrapper) Error(err error) {
 		return
 	}
 
+	hw.onError(err)
 	hw.Label("@iopipe/error")
+	hw.recordColdStart()
 	hw.report.prepare(err)
 	hw.report.send()
 }
@@ -141,10 +203,12 @@ func (hw *HandlerWrapper) Label(name string) {
 		return
 	}
 
-	// USing map to ensure uniqueness of labels
-	if _, ok := hw.report.labels[name]; !ok {
-		hw.report.labels[name] = struct{}{}
+	if !hw.report.addLabel(name) {
+		hw.Log.Debug(fmt.Sprintf("Label %s was added after the report was finalized. This label will not be recorded.", name))
+		return
 	}
+
+	hw.onLabel(name)
 }
 
 // Metric adds a custom metric to the report
@@ -159,57 +223,75 @@ func (hw *HandlerWrapper) Metric(name string, value interface{}) {
 		return
 	}
 
-	s := coerceString(value)
-	if s != nil {
-		if !strings.HasPrefix(name, "@iopipe") {
-			hw.Label("@iopipe/metrics")
-		}
+	metric := CustomMetric{Name: name}
+	if s := coerceString(value); s != nil {
+		metric.S = s
+	} else if n := coerceNumeric(value); n != nil {
+		metric.N = n
+	} else {
+		return
+	}
 
-		hw.report.CustomMetrics = append(hw.report.CustomMetrics, CustomMetric{Name: name, S: s})
+	if !hw.report.addMetric(metric, !strings.HasPrefix(name, "@iopipe")) {
+		hw.Log.Debug(fmt.Sprintf("Metric %s was added after the report was finalized. This metric will not be recorded.", name))
+		return
 	}
 
-	n := coerceNumeric(value)
-	if n != nil {
-		if !strings.HasPrefix(name, "@iopipe") {
-			hw.Label("@iopipe/metrics")
-		}
+	hw.onMetric(metric)
+}
+
+// SetPluginState stores state for plugin that lasts for this invocation only,
+// plugins should use it instead of their own fields when invocations may run
+// concurrently
+func (hw *HandlerWrapper) SetPluginState(plugin Plugin, state interface{}) {
+	hw.pluginStateMutex.Lock()
+	defer hw.pluginStateMutex.Unlock()
 
-		hw.report.CustomMetrics = append(hw.report.CustomMetrics, CustomMetric{Name: name, N: n})
+	if hw.pluginState == nil {
+		hw.pluginState = make(map[Plugin]interface{})
 	}
+
+	hw.pluginState[plugin] = state
 }
 
-// preInvoke runs the PreInvoke hooks
-func (hw *HandlerWrapper) preInvoke(ctx context.Context, payload interface{}) {
-	var wg sync.WaitGroup
-	wg.Add(len(hw.agent.plugins))
+// PluginState returns the state stored for plugin during this invocation
+func (hw *HandlerWrapper) PluginState(plugin Plugin) interface{} {
+	hw.pluginStateMutex.RLock()
+	defer hw.pluginStateMutex.RUnlock()
 
-	for _, plugin := range hw.agent.plugins {
-		go func(plugin Plugin) {
-			defer wg.Done()
+	return hw.pluginState[plugin]
+}
 
-			if plugin != nil {
-				plugin.PreInvoke(ctx, payload)
-			}
-		}(plugin)
-	}
+// preInvoke runs the PreInvoke hooks
+func (hw *HandlerWrapper) preInvoke(ctx context.Context, payload interface{}) {
+	outcomes := hw.agent.runPluginHooks(hw.agent.hookTimeout(hw.invokeHookDeadline()), func(plugin Plugin) func() {
+		if hook, ok := plugin.(PreInvokeHook); ok && !pluginToggledOff(plugin) {
+			return func() { hook.PreInvoke(ctx, payload) }
+		}
+		return nil
+	})
 
-	wg.Wait()
+	hw.recordHooks(hookPreInvoke, outcomes)
 }
 
 // postInvoke runs the PostInvoke hooks
 func (hw *HandlerWrapper) postInvoke(ctx context.Context, payload interface{}) {
-	var wg sync.WaitGroup
-	wg.Add(len(hw.agent.plugins))
+	outcomes := hw.agent.runPluginHooks(hw.agent.hookTimeout(hw.invokeHookDeadline()), func(plugin Plugin) func() {
+		if hook, ok := plugin.(PostInvokeHook); ok && pluginEnabled(plugin) {
+			return func() { hook.PostInvoke(ctx, payload) }
+		}
+		return nil
+	})
 
-	for _, plugin := range hw.agent.plugins {
-		go func(plugin Plugin) {
-			defer wg.Done()
+	hw.recordHooks(hookPostInvoke, outcomes)
+}
 
-			if plugin != nil && plugin.Enabled() {
-				plugin.PostInvoke(ctx, payload)
-			}
-		}(plugin)
+// invokeHookDeadline returns when the invoke hooks have to finish by, the
+// timeout deadline, or zero without a deadline
+func (hw *HandlerWrapper) invokeHookDeadline() time.Time {
+	if hw.deadline.IsZero() {
+		return time.Time{}
 	}
 
-	wg.Wait()
+	return hw.timeoutDeadline()
 }
@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"math/rand"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/iopipe/iopipe-go/internal/coldstart"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -406,3 +408,169 @@ func TestHandlerWrapper_Metric(t *testing.T) {
 		})
 	})
 }
+
+func TestHandlerWrapper_Concurrency(t *testing.T) {
+	Convey("Labels and metrics can be added from many goroutines", t, func() {
+		var reported *Report
+
+		a := NewAgent(Config{
+			Reporter: func(report *Report) error {
+				reported = report
+				return nil
+			},
+		})
+
+		hw := NewHandlerWrapper(func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					context.IOpipe.Label(fmt.Sprintf("label-%d", i))
+					context.IOpipe.Metric(fmt.Sprintf("metric-%d", i), i)
+					context.IOpipe.Log.Info("hello")
+				}(i)
+			}
+			wg.Wait()
+
+			return nil
+		}, a)
+
+		hw.Invoke(context.Background(), nil)
+
+		So(len(reported.CustomMetrics), ShouldEqual, 50)
+		So(reported.Labels, ShouldContain, "label-49")
+		So(reported.Labels, ShouldContain, "@iopipe/metrics")
+	})
+
+	Convey("Writes racing a timeout are recorded or counted as late", t, func() {
+		var (
+			reported *Report
+			done     = make(chan struct{})
+		)
+
+		timeoutWindow := 50 * time.Millisecond
+		a := NewAgent(Config{
+			TimeoutWindow: &timeoutWindow,
+			Reporter: func(report *Report) error {
+				reported = report
+				return nil
+			},
+		})
+
+		hw := NewHandlerWrapper(func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+
+			go func() {
+				defer close(done)
+				for i := 0; i < 1000; i++ {
+					context.IOpipe.Metric("counter", i)
+					context.IOpipe.Label("busy")
+				}
+			}()
+
+			<-ctx.Done()
+			<-done
+			return nil
+		}, a)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		hw.Invoke(ctx, nil)
+
+		recorded := 0
+		lateWrites := int64(0)
+		for _, metric := range reported.CustomMetrics {
+			switch metric.Name {
+			case "counter":
+				recorded++
+			case "@iopipe/late-writes":
+				lateWrites = metric.N.(int64)
+			}
+		}
+
+		So(reported.Labels, ShouldContain, "@iopipe/timeout")
+		So(recorded, ShouldBeLessThanOrEqualTo, 1000)
+		So(int64(recorded)+hw.report.lateWrites, ShouldBeGreaterThanOrEqualTo, 1000)
+		So(lateWrites, ShouldBeLessThanOrEqualTo, hw.report.lateWrites)
+	})
+
+	Convey("Writes after the report is finalized are dropped", t, func() {
+		a := NewAgent(Config{Reporter: func(report *Report) error { return nil }})
+		hw := &HandlerWrapper{agent: a, Log: a.log}
+		r := NewReport(hw)
+		hw.report = r
+
+		hw.Metric("before", 1)
+		r.prepare(nil)
+		r.prepare(nil)
+		hw.Metric("after", 1)
+		hw.Label("after")
+
+		So(len(r.CustomMetrics), ShouldEqual, 1)
+		So(r.Labels, ShouldResemble, []string{"@iopipe/metrics"})
+		So(r.lateWrites, ShouldEqual, 2)
+	})
+}
+
+func TestHandlerWrapper_ConcurrentInvocations(t *testing.T) {
+	Convey("Only one of many concurrent invocations is a cold start", t, func() {
+		var (
+			mutex   sync.Mutex
+			reports []*Report
+		)
+
+		token := "token"
+		a := NewAgent(Config{
+			Token: &token,
+			Reporter: func(report *Report) error {
+				mutex.Lock()
+				defer mutex.Unlock()
+				reports = append(reports, report)
+				return nil
+			},
+		})
+
+		handler := a.WrapHandler(func() error { return nil }).(lambdaHandler)
+
+		coldstart.Set(true)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				handler(context.Background(), nil)
+			}()
+		}
+		wg.Wait()
+
+		coldStarts := 0
+		for _, report := range reports {
+			if report.ColdStart {
+				coldStarts++
+				So(report.Labels, ShouldContain, "@iopipe/coldstart")
+			}
+		}
+
+		So(len(reports), ShouldEqual, 20)
+		So(coldStarts, ShouldEqual, 1)
+	})
+
+	Convey("Plugin state is kept per invocation", t, func() {
+		plugin := &testPlugin{}
+		hw1 := &HandlerWrapper{}
+		hw2 := &HandlerWrapper{}
+
+		So(hw1.PluginState(plugin), ShouldBeNil)
+
+		hw1.SetPluginState(plugin, "one")
+		hw2.SetPluginState(plugin, "two")
+
+		So(hw1.PluginState(plugin), ShouldEqual, "one")
+		So(hw2.PluginState(plugin), ShouldEqual, "two")
+	})
+}
@@ -0,0 +1,307 @@
+package iopipe
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Plugin hook names, as recorded in the plugin meta data of reports
+const (
+	hookPreSetup   = "preSetup"
+	hookPostSetup  = "postSetup"
+	hookPreInvoke  = "preInvoke"
+	hookPostInvoke = "postInvoke"
+	hookPreReport  = "preReport"
+	hookPostReport = "postReport"
+
+	hookOnColdStart = "onColdStart"
+	hookOnTimeout   = "onTimeout"
+	hookOnPanic     = "onPanic"
+	hookOnError     = "onError"
+	hookOnShutdown  = "onShutdown"
+	hookOnLabel     = "onLabel"
+	hookOnMetric    = "onMetric"
+)
+
+// minHookTimeout is the least time hooks are given when the invocation is
+// already past their deadline
+const minHookTimeout = time.Millisecond
+
+// hookResult is the outcome of the hook of the plugin at index
+type hookResult struct {
+	index int
+	meta  PluginHookMeta
+}
+
+// runPluginHooks runs the hooks returned by hook for each plugin, level by
+// level in the agent's plugin order, each in its own goroutine recovering
+// panics. It waits for them until timeout elapses if it is positive. hook
+// returns nil for plugins that don't implement the hook. The outcomes are
+// returned in the order of plugins, nil for plugins whose hook didn't run.
+// Hooks still running at the timeout are left running, and they and the hooks
+// of later levels are reported as timed out.
+func (a *Agent) runPluginHooks(timeout time.Duration, hook func(Plugin) func()) []*PluginHookMeta {
+	var (
+		outcomes = make([]*PluginHookMeta, len(a.plugins))
+		results  = make(chan hookResult, len(a.plugins))
+		runs     = make([]func(), len(a.plugins))
+		start    = time.Now()
+	)
+
+	pending := 0
+	for index, plugin := range a.plugins {
+		if plugin == nil {
+			continue
+		}
+
+		if runs[index] = hook(plugin); runs[index] != nil {
+			pending++
+		}
+	}
+
+	var timer <-chan time.Time
+	if timeout > 0 && pending > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	for _, level := range a.pluginLevels {
+		running := 0
+		for _, index := range level {
+			if runs[index] != nil {
+				running++
+				go runPluginHook(index, runs[index], results)
+			}
+		}
+
+		for running > 0 {
+			select {
+			case result := <-results:
+				outcomes[result.index] = &result.meta
+				running--
+			case <-timer:
+				for index := range a.plugins {
+					if runs[index] != nil && outcomes[index] == nil {
+						outcomes[index] = &PluginHookMeta{
+							Duration: int(time.Since(start).Nanoseconds()),
+							Error:    fmt.Sprintf("timed out after %s", timeout),
+							TimedOut: true,
+						}
+					}
+				}
+				return outcomes
+			}
+		}
+	}
+
+	return outcomes
+}
+
+// runPluginHook runs the hook of a plugin, sending its outcome to results
+func runPluginHook(index int, run func(), results chan<- hookResult) {
+	start := time.Now()
+	result := hookResult{index: index}
+
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			result.meta.Error = fmt.Sprintf("panic: %v", panicErr)
+		}
+
+		result.meta.Duration = int(time.Since(start).Nanoseconds())
+		results <- result
+	}()
+
+	run()
+}
+
+// runEventHooks runs an event hook of the plugins until deadline, logging the
+// hooks that failed
+func (a *Agent) runEventHooks(name string, deadline time.Time, hook func(Plugin) func()) {
+	outcomes := a.runPluginHooks(a.hookTimeout(deadline), hook)
+	a.logHookFailures(name, outcomes)
+}
+
+// runInlineHooks runs an event hook of the plugins in the calling goroutine,
+// recovering and logging panics
+func (a *Agent) runInlineHooks(name string, hook func(Plugin) func()) {
+	for _, plugin := range a.plugins {
+		if plugin == nil {
+			continue
+		}
+
+		if run := hook(plugin); run != nil {
+			a.runInlineHook(name, plugin, run)
+		}
+	}
+}
+
+func (a *Agent) runInlineHook(name string, plugin Plugin, run func()) {
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			a.log.Warn(fmt.Sprintf("Plugin %s %s hook failed: panic: %v", pluginName(plugin), name, panicErr))
+		}
+	}()
+
+	run()
+}
+
+// hookTimeout returns how long hooks may run to finish by deadline, capped by
+// the agent's hook timeout, ignoring the deadline if it is zero
+func (a *Agent) hookTimeout(deadline time.Time) time.Duration {
+	timeout := defaultConfigHookTimeout
+	if a.Config != nil && a.Config.HookTimeout != nil {
+		timeout = *a.Config.HookTimeout
+	}
+
+	if deadline.IsZero() {
+		return timeout
+	}
+
+	remaining := time.Until(deadline)
+	if remaining < minHookTimeout {
+		remaining = minHookTimeout
+	}
+
+	if timeout <= 0 || remaining < timeout {
+		return remaining
+	}
+
+	return timeout
+}
+
+// logHookFailures logs the hooks that panicked or timed out
+func (a *Agent) logHookFailures(name string, outcomes []*PluginHookMeta) {
+	for index, outcome := range outcomes {
+		if outcome == nil || outcome.Error == "" {
+			continue
+		}
+
+		a.log.Warn(fmt.Sprintf("Plugin %s %s hook failed: %s", pluginName(a.plugins[index]), name, outcome.Error))
+	}
+}
+
+// recordHooks records the outcomes of a hook run for the invocation, to be
+// added to the plugin meta data of the report
+func (hw *HandlerWrapper) recordHooks(name string, outcomes []*PluginHookMeta) {
+	hw.agent.logHookFailures(name, outcomes)
+
+	hw.hooksMutex.Lock()
+	defer hw.hooksMutex.Unlock()
+
+	if hw.hooks == nil {
+		hw.hooks = make([]map[string]PluginHookMeta, len(outcomes))
+	}
+
+	for index, outcome := range outcomes {
+		if outcome == nil {
+			continue
+		}
+
+		if hw.hooks[index] == nil {
+			hw.hooks[index] = make(map[string]PluginHookMeta)
+		}
+		hw.hooks[index][name] = *outcome
+	}
+}
+
+// pluginHooks returns the outcomes of the hooks of the plugin at index that
+// ran for the invocation, including the setup hooks for cold starts
+func (hw *HandlerWrapper) pluginHooks(index int) map[string]PluginHookMeta {
+	hooks := make(map[string]PluginHookMeta)
+
+	if hw.coldStart && index < len(hw.agent.setupHooks) {
+		for name, outcome := range hw.agent.setupHooks[index] {
+			hooks[name] = outcome
+		}
+	}
+
+	hw.hooksMutex.Lock()
+	defer hw.hooksMutex.Unlock()
+
+	if index < len(hw.hooks) {
+		for name, outcome := range hw.hooks[index] {
+			hooks[name] = outcome
+		}
+	}
+
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	return hooks
+}
+
+// onColdStart runs the OnColdStart hooks
+func (hw *HandlerWrapper) onColdStart(ctx context.Context) {
+	hw.agent.runEventHooks(hookOnColdStart, hw.invokeHookDeadline(), func(plugin Plugin) func() {
+		if hook, ok := plugin.(ColdStartHook); ok && pluginEnabled(plugin) {
+			return func() { hook.OnColdStart(ctx) }
+		}
+		return nil
+	})
+}
+
+// onTimeout runs the OnTimeout hooks, which have until the deadline itself
+func (hw *HandlerWrapper) onTimeout(ctx context.Context) {
+	hw.agent.runEventHooks(hookOnTimeout, hw.deadline, func(plugin Plugin) func() {
+		if hook, ok := plugin.(TimeoutHook); ok && pluginEnabled(plugin) {
+			return func() { hook.OnTimeout(ctx) }
+		}
+		return nil
+	})
+}
+
+// onPanic runs the OnPanic hooks
+func (hw *HandlerWrapper) onPanic(recovered interface{}) {
+	ctx := hw.hookContext()
+
+	hw.agent.runEventHooks(hookOnPanic, hw.invokeHookDeadline(), func(plugin Plugin) func() {
+		if hook, ok := plugin.(PanicHook); ok && pluginEnabled(plugin) {
+			return func() { hook.OnPanic(ctx, recovered) }
+		}
+		return nil
+	})
+}
+
+// onError runs the OnError hooks
+func (hw *HandlerWrapper) onError(err error) {
+	ctx := hw.hookContext()
+
+	hw.agent.runEventHooks(hookOnError, hw.invokeHookDeadline(), func(plugin Plugin) func() {
+		if hook, ok := plugin.(ErrorHook); ok && pluginEnabled(plugin) {
+			return func() { hook.OnError(ctx, err) }
+		}
+		return nil
+	})
+}
+
+// onLabel runs the OnLabel hooks
+func (hw *HandlerWrapper) onLabel(name string) {
+	hw.agent.runInlineHooks(hookOnLabel, func(plugin Plugin) func() {
+		if hook, ok := plugin.(LabelHook); ok && pluginEnabled(plugin) {
+			return func() { hook.OnLabel(hw, name) }
+		}
+		return nil
+	})
+}
+
+// onMetric runs the OnMetric hooks
+func (hw *HandlerWrapper) onMetric(metric CustomMetric) {
+	hw.agent.runInlineHooks(hookOnMetric, func(plugin Plugin) func() {
+		if hook, ok := plugin.(MetricHook); ok && pluginEnabled(plugin) {
+			return func() { hook.OnMetric(hw, metric) }
+		}
+		return nil
+	})
+}
+
+// hookContext returns the invocation's context for event hooks
+func (hw *HandlerWrapper) hookContext() context.Context {
+	if hw.invocationContext == nil {
+		return context.Background()
+	}
+
+	return hw.invocationContext
+}
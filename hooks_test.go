@@ -0,0 +1,470 @@
+package iopipe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iopipe/iopipe-go/internal/coldstart"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type hookTestPlugin struct {
+	preInvoke func()
+	preReport func()
+	preSetup  func()
+	report    func(*Report)
+}
+
+func (p *hookTestPlugin) Meta() *PluginMeta {
+	return &PluginMeta{Name: "@iopipe/test-hooks", Enabled: true, Uploads: []string{}}
+}
+
+func (p *hookTestPlugin) Enabled() bool { return true }
+
+func (p *hookTestPlugin) PreSetup(agent *Agent) {
+	if p.preSetup != nil {
+		p.preSetup()
+	}
+}
+
+func (p *hookTestPlugin) PostSetup(agent *Agent) {}
+
+func (p *hookTestPlugin) PreInvoke(ctx context.Context, payload interface{}) {
+	if p.preInvoke != nil {
+		p.preInvoke()
+	}
+}
+
+func (p *hookTestPlugin) PostInvoke(ctx context.Context, payload interface{}) {}
+
+func (p *hookTestPlugin) PreReport(report *Report) {
+	if p.preReport != nil {
+		p.preReport()
+	}
+	if p.report != nil {
+		p.report(report)
+	}
+}
+
+func (p *hookTestPlugin) PostReport(report *Report) {}
+
+// nilMetaHookPlugin has no meta data and hooks that panic
+type nilMetaHookPlugin struct{}
+
+func (p *nilMetaHookPlugin) Meta() *PluginMeta { return nil }
+
+func (p *nilMetaHookPlugin) PreInvoke(ctx context.Context, payload interface{}) {
+	panic("pre-invoke")
+}
+
+func (p *nilMetaHookPlugin) OnLabel(hw *HandlerWrapper, name string) {
+	panic("label")
+}
+
+func TestHooks_Isolation(t *testing.T) {
+	invoke := func(config Config, plugin Plugin) *Report {
+		var reported *Report
+
+		config.Plugins = []PluginInstantiator{func() Plugin { return plugin }}
+		config.Reporter = func(report *Report) error {
+			reported = report
+			return nil
+		}
+
+		NewHandlerWrapper(func(ctx context.Context) error {
+			return nil
+		}, NewAgent(config)).Invoke(context.Background(), nil)
+
+		return reported
+	}
+
+	Convey("Panicking hooks are recovered and recorded in the plugin meta data", t, func() {
+		report := invoke(Config{}, &hookTestPlugin{
+			preInvoke: func() { panic("boom") },
+		})
+
+		So(report, ShouldNotBeNil)
+
+		hooks := report.Plugins[0].Hooks
+		So(hooks[hookPreInvoke].Error, ShouldEqual, "panic: boom")
+		So(hooks[hookPostInvoke].Error, ShouldBeEmpty)
+		So(hooks[hookPreReport].Duration, ShouldBeGreaterThan, 0)
+		So(report.Validate(), ShouldBeNil)
+	})
+
+	Convey("Plugins without meta data can fail hooks and be reported", t, func() {
+		var report *Report
+		a := NewAgent(Config{
+			Plugins: []PluginInstantiator{func() Plugin { return &nilMetaHookPlugin{} }},
+			Reporter: func(reported *Report) error {
+				report = reported
+				return nil
+			},
+		})
+
+		So(func() {
+			NewHandlerWrapper(func(ctx context.Context) error {
+				context, _ := FromContext(ctx)
+				context.IOpipe.Label("labeled")
+				return nil
+			}, a).Invoke(context.Background(), nil)
+		}, ShouldNotPanic)
+
+		So(report.Plugins[0].Name, ShouldEqual, "*iopipe.nilMetaHookPlugin")
+		So(report.Plugins[0].Enabled, ShouldBeTrue)
+		So(report.Plugins[0].Hooks[hookPreInvoke].Error, ShouldEqual, "panic: pre-invoke")
+		So(report.Labels, ShouldContain, "labeled")
+		So(report.Validate(), ShouldBeNil)
+	})
+
+	Convey("Hooks still running at their deadline are left behind and recorded as timed out", t, func() {
+		release := make(chan struct{})
+		defer close(release)
+
+		hookTimeout := 50 * time.Millisecond
+		start := time.Now()
+
+		report := invoke(Config{HookTimeout: &hookTimeout}, &hookTestPlugin{
+			preReport: func() { <-release },
+		})
+
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+
+		hooks := report.Plugins[0].Hooks
+		So(hooks[hookPreReport].TimedOut, ShouldBeTrue)
+		So(hooks[hookPreReport].Error, ShouldEqual, "timed out after 50ms")
+		So(hooks[hookPreInvoke].TimedOut, ShouldBeFalse)
+	})
+
+	Convey("Setup hooks are recorded in cold start reports only", t, func() {
+		plugin := &hookTestPlugin{preSetup: func() { panic("setup") }}
+
+		coldstart.Set(true)
+		report := invoke(Config{}, plugin)
+		So(report.Plugins[0].Hooks[hookPreSetup].Error, ShouldEqual, "panic: setup")
+		So(report.Plugins[0].Hooks, ShouldContainKey, hookPostSetup)
+
+		report = invoke(Config{}, plugin)
+		So(report.Plugins[0].Hooks, ShouldNotContainKey, hookPreSetup)
+	})
+}
+
+func TestHooks_HookTimeout(t *testing.T) {
+	Convey("Hook timeouts are derived from the time left before the deadline", t, func() {
+		hookTimeout := time.Second
+		a := NewAgent(Config{HookTimeout: &hookTimeout})
+
+		So(a.hookTimeout(time.Time{}), ShouldEqual, time.Second)
+		So(a.hookTimeout(time.Now().Add(time.Minute)), ShouldEqual, time.Second)
+		So(a.hookTimeout(time.Now().Add(100*time.Millisecond)), ShouldBeBetweenOrEqual, 90*time.Millisecond, 100*time.Millisecond)
+		So(a.hookTimeout(time.Now().Add(-time.Second)), ShouldEqual, minHookTimeout)
+
+		noTimeout := time.Duration(0)
+		a = NewAgent(Config{HookTimeout: &noTimeout})
+
+		So(a.hookTimeout(time.Time{}), ShouldEqual, 0)
+		So(a.hookTimeout(time.Now().Add(time.Minute)), ShouldBeGreaterThan, 59*time.Second)
+	})
+}
+
+type eventTestPlugin struct {
+	mutex  sync.Mutex
+	events []string
+}
+
+func (p *eventTestPlugin) Meta() *PluginMeta {
+	return &PluginMeta{Name: "@iopipe/test-events", Enabled: true, Uploads: []string{}}
+}
+
+func (p *eventTestPlugin) record(event string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.events = append(p.events, event)
+}
+
+func (p *eventTestPlugin) recorded() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return append([]string{}, p.events...)
+}
+
+func (p *eventTestPlugin) OnColdStart(ctx context.Context) { p.record("coldstart") }
+func (p *eventTestPlugin) OnTimeout(ctx context.Context)   { p.record("timeout") }
+func (p *eventTestPlugin) OnShutdown()                     { p.record("shutdown") }
+
+func (p *eventTestPlugin) OnPanic(ctx context.Context, recovered interface{}) {
+	p.record(fmt.Sprintf("panic %v", recovered))
+}
+
+func (p *eventTestPlugin) OnError(ctx context.Context, err error) {
+	p.record("error " + err.Error())
+}
+
+func (p *eventTestPlugin) OnLabel(hw *HandlerWrapper, name string) {
+	p.record("label " + name)
+}
+
+func (p *eventTestPlugin) OnMetric(hw *HandlerWrapper, metric CustomMetric) {
+	p.record(fmt.Sprintf("metric %s %v", metric.Name, metric.N))
+}
+
+// disabledEventTestPlugin records events but is disabled by its Enabled method
+type disabledEventTestPlugin struct {
+	eventTestPlugin
+}
+
+func (p *disabledEventTestPlugin) Enabled() bool { return false }
+
+func TestHooks_Events(t *testing.T) {
+	newAgent := func(plugin Plugin) *Agent {
+		return NewAgent(Config{
+			Plugins:  []PluginInstantiator{func() Plugin { return plugin }},
+			Reporter: func(report *Report) error { return nil },
+		})
+	}
+
+	Convey("Plugins only implementing the event hooks they need receive them", t, func() {
+		plugin := &eventTestPlugin{}
+		a := newAgent(plugin)
+
+		coldstart.Set(true)
+		NewHandlerWrapper(func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+			context.IOpipe.Label("checkout")
+			context.IOpipe.Metric("items", 3)
+			return errors.New("out of stock")
+		}, a).Invoke(context.Background(), nil)
+
+		// The cold start breakdown metrics depend on timing
+		var events []string
+		for _, event := range plugin.recorded() {
+			if !strings.HasPrefix(event, "metric @iopipe/coldstart.") {
+				events = append(events, event)
+			}
+		}
+
+		So(events, ShouldResemble, []string{
+			"coldstart",
+			"label checkout",
+			"metric items 3",
+			"label @iopipe/coldstart",
+			"error out of stock",
+			"label @iopipe/error",
+		})
+	})
+
+	Convey("Plugins whose Enabled method returns false don't receive events", t, func() {
+		plugin := &disabledEventTestPlugin{}
+		a := newAgent(plugin)
+
+		coldstart.Set(true)
+		NewHandlerWrapper(func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+			context.IOpipe.Label("checkout")
+			context.IOpipe.Metric("items", 3)
+			return errors.New("out of stock")
+		}, a).Invoke(context.Background(), nil)
+
+		So(plugin.recorded(), ShouldBeEmpty)
+	})
+
+	Convey("Panics are passed to the OnPanic hooks before being re-raised", t, func() {
+		plugin := &eventTestPlugin{}
+		a := newAgent(plugin)
+
+		coldstart.Set(false)
+		So(func() {
+			NewHandlerWrapper(func(ctx context.Context) error {
+				panic("boom")
+			}, a).Invoke(context.Background(), nil)
+		}, ShouldPanicWith, "boom")
+
+		So(plugin.recorded(), ShouldResemble, []string{"panic boom", "label @iopipe/error"})
+	})
+
+	Convey("Invocations about to time out run the OnTimeout hooks", t, func() {
+		plugin := &eventTestPlugin{}
+		a := newAgent(plugin)
+
+		timeoutWindow := 60 * time.Millisecond
+		a.TimeoutWindow = &timeoutWindow
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		coldstart.Set(false)
+		NewHandlerWrapper(func(ctx context.Context) error {
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		}, a).Invoke(ctx, nil)
+
+		So(plugin.recorded(), ShouldContain, "timeout")
+	})
+
+	Convey("Shutting down the agent runs the OnShutdown hooks once", t, func() {
+		plugin := &eventTestPlugin{}
+		a := newAgent(plugin)
+
+		a.Shutdown()
+		a.Shutdown()
+
+		So(plugin.recorded(), ShouldResemble, []string{"shutdown"})
+	})
+
+	Convey("Panics in inline hooks are recovered", t, func() {
+		a := newAgent(&hookTestPlugin{})
+		a.plugins = append(a.plugins, &panickingLabelPlugin{})
+
+		So(func() {
+			NewHandlerWrapper(func(ctx context.Context) error {
+				context, _ := FromContext(ctx)
+				context.IOpipe.Label("checkout")
+				return nil
+			}, a).Invoke(context.Background(), nil)
+		}, ShouldNotPanic)
+	})
+}
+
+type panickingLabelPlugin struct{}
+
+func (p *panickingLabelPlugin) Meta() *PluginMeta {
+	return &PluginMeta{Name: "@iopipe/test-panicking-label", Enabled: true, Uploads: []string{}}
+}
+
+func (p *panickingLabelPlugin) OnLabel(hw *HandlerWrapper, name string) {
+	panic("label")
+}
+
+type orderedReportPlugin struct {
+	orderTestPlugin
+	delay  time.Duration
+	record func(string)
+}
+
+func (p *orderedReportPlugin) PreReport(report *Report) {
+	time.Sleep(p.delay)
+	p.record(p.name)
+}
+
+func TestHooks_Order(t *testing.T) {
+	Convey("Hooks of plugins run after the hooks of the plugins they depend on", t, func() {
+		var (
+			mutex sync.Mutex
+			ran   []string
+		)
+
+		record := func(name string) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			ran = append(ran, name)
+		}
+
+		plugins := []Plugin{
+			&orderedReportPlugin{orderTestPlugin: orderTestPlugin{name: "upload", after: []string{"redact"}}, record: record},
+			&orderedReportPlugin{orderTestPlugin: orderTestPlugin{name: "redact"}, delay: 20 * time.Millisecond, record: record},
+		}
+
+		var reported *Report
+		a := NewAgent(Config{
+			Plugins: []PluginInstantiator{
+				func() Plugin { return plugins[0] },
+				func() Plugin { return plugins[1] },
+			},
+			Reporter: func(report *Report) error {
+				reported = report
+				return nil
+			},
+		})
+
+		NewHandlerWrapper(func(ctx context.Context) error {
+			return nil
+		}, a).Invoke(context.Background(), nil)
+
+		So(ran, ShouldResemble, []string{"redact", "upload"})
+		So(reported.Plugins[0].Name, ShouldEqual, "redact")
+		So(reported.Plugins[1].Hooks, ShouldContainKey, hookPreReport)
+	})
+
+	Convey("Hooks of later levels are reported as timed out when an earlier level times out", t, func() {
+		release := make(chan struct{})
+		defer close(release)
+
+		hookTimeout := 20 * time.Millisecond
+		a := NewAgent(Config{
+			HookTimeout: &hookTimeout,
+			Plugins: []PluginInstantiator{
+				func() Plugin { return &hookTestPlugin{preReport: func() { <-release }} },
+				func() Plugin {
+					return &orderedReportPlugin{orderTestPlugin: orderTestPlugin{name: "upload", priority: 1}, record: func(string) {}}
+				},
+			},
+		})
+
+		outcomes := a.runPluginHooks(a.hookTimeout(time.Time{}), func(plugin Plugin) func() {
+			if hook, ok := plugin.(PreReportHook); ok {
+				return func() { hook.PreReport(nil) }
+			}
+			return nil
+		})
+
+		So(outcomes[0].TimedOut, ShouldBeTrue)
+		So(outcomes[1].TimedOut, ShouldBeTrue)
+		So(outcomes[1].Duration, ShouldBeGreaterThanOrEqualTo, int(hookTimeout))
+	})
+}
+
+func TestHooks_LateReportWrites(t *testing.T) {
+	Convey("PreReport hooks writing to the report after timing out don't change it", t, func() {
+		release := make(chan struct{})
+		done := make(chan struct{})
+
+		hookTimeout := 20 * time.Millisecond
+		metrics := make(chan []CustomMetric, 1)
+
+		a := NewAgent(Config{
+			HookTimeout: &hookTimeout,
+			Plugins: []PluginInstantiator{
+				func() Plugin {
+					return &hookTestPlugin{}
+				},
+			},
+			Reporter: func(report *Report) error {
+				// Serialize the report while the hook writes to it
+				close(release)
+				for i := 0; i < 20; i++ {
+					json.Marshal(report)
+					time.Sleep(time.Millisecond)
+				}
+				metrics <- append([]CustomMetric{}, report.CustomMetrics...)
+				return nil
+			},
+		})
+
+		a.plugins[0].(*hookTestPlugin).report = func(report *Report) {
+			defer close(done)
+			<-release
+			for i := 0; i < 20; i++ {
+				report.addPreReportMetric(CustomMetric{Name: "late", N: i})
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		NewHandlerWrapper(func(ctx context.Context) error {
+			return nil
+		}, a).Invoke(context.Background(), nil)
+
+		<-done
+
+		for _, metric := range <-metrics {
+			So(metric.Name, ShouldNotEqual, "late")
+		}
+	})
+}
@@ -0,0 +1,39 @@
+// Package coldstart tracks whether the next invocation is a cold start. It is
+// internal so the iopipetest package can override it without it being part of
+// the agent's API.
+package coldstart
+
+import "sync/atomic"
+
+var (
+	// cold is 1 until the first invocation starts, accessed atomically
+	cold int32 = 1
+
+	// reset runs when the next invocation is set to be a cold start again
+	reset func()
+)
+
+// OnReset sets the function run when the next invocation is set to be a cold
+// start again
+func OnReset(fn func()) {
+	reset = fn
+}
+
+// Set overrides whether the next invocation is reported as a cold start
+func Set(isCold bool) {
+	if !isCold {
+		atomic.StoreInt32(&cold, 0)
+		return
+	}
+
+	atomic.StoreInt32(&cold, 1)
+	if reset != nil {
+		reset()
+	}
+}
+
+// Take returns true for the first invocation only, even if several start
+// concurrently
+func Take() bool {
+	return atomic.CompareAndSwapInt32(&cold, 1, 0)
+}
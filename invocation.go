@@ -0,0 +1,124 @@
+package iopipe
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// InvocationMetadata identifies units of work reported outside of Lambda, such
+// as background jobs, batch steps and ECS tasks
+type InvocationMetadata struct {
+	// AccountID is the AWS account ID, defaults to 0
+	AccountID string
+
+	// FunctionName is the name reported for the unit of work, defaults to
+	// the name passed to Run or StartInvocation
+	FunctionName string
+
+	// FunctionVersion defaults to $LATEST
+	FunctionVersion string
+
+	LogGroupName    string
+	LogStreamName   string
+	MemoryLimitInMB int
+
+	// Region is the AWS region, defaults to AWS_REGION or local
+	Region string
+}
+
+// withDefaults returns the metadata for the unit of work called name
+func (m *InvocationMetadata) withDefaults(name string) *InvocationMetadata {
+	metadata := InvocationMetadata{}
+	if m != nil {
+		metadata = *m
+	}
+
+	if metadata.AccountID == "" {
+		metadata.AccountID = "0"
+	}
+	if metadata.FunctionName == "" {
+		metadata.FunctionName = name
+	}
+	if metadata.FunctionVersion == "" {
+		metadata.FunctionVersion = "$LATEST"
+	}
+	if metadata.Region == "" {
+		metadata.Region = os.Getenv("AWS_REGION")
+	}
+	if metadata.Region == "" {
+		metadata.Region = "local"
+	}
+
+	return &metadata
+}
+
+// arn returns the synthetic ARN of the unit of work
+func (m *InvocationMetadata) arn() string {
+	return fmt.Sprintf("arn:aws:lambda:%s:%s:function:%s", m.Region, m.AccountID, m.FunctionName)
+}
+
+// StartInvocation starts reporting a unit of work that is not a Lambda
+// invocation. The returned context carries the handler wrapper like a wrapped
+// handler's would, call End on the handler wrapper to send the report. If the
+// agent is disabled, ctx is returned as is along with a nil handler wrapper,
+// which is safe to call End on.
+func (a *Agent) StartInvocation(ctx context.Context, name string) (context.Context, *HandlerWrapper) {
+	if !a.isEnabled() {
+		return ctx, nil
+	}
+
+	metadata := a.Metadata.withDefaults(name)
+
+	lc := &lambdacontext.LambdaContext{
+		AwsRequestID:       generateUUID(),
+		InvokedFunctionArn: metadata.arn(),
+	}
+
+	hw := &HandlerWrapper{
+		agent:    a,
+		metadata: metadata,
+		Log:      a.log,
+	}
+
+	ctx, hw.cancel = context.WithCancel(lambdacontext.NewContext(ctx, lc))
+
+	return hw.start(ctx, lc, nil), hw
+}
+
+// End finishes a unit of work started with StartInvocation and sends its
+// report, err is reported as the unit of work's error
+func (hw *HandlerWrapper) End(err error) {
+	if hw == nil || hw.invocationContext == nil {
+		return
+	}
+
+	hw.finish(hw.invocationContext, nil, err)
+	hw.cancel()
+}
+
+// Run runs fn as a unit of work called name, reporting it like a Lambda
+// invocation, including errors, panics and timeouts if ctx has a deadline
+func (a *Agent) Run(ctx context.Context, name string, fn func(context.Context) error) (err error) {
+	ctx, hw := a.StartInvocation(ctx, name)
+	if hw == nil {
+		return fn(ctx)
+	}
+
+	// Handle and report a panic if it occurs
+	defer func() {
+		if panicErr := recover(); panicErr != nil {
+			hw.reportPanic(panicErr, NewPanicInvocationError(panicErr))
+			hw.cancel()
+			panic(panicErr)
+		}
+	}()
+
+	err = fn(ctx)
+
+	hw.End(err)
+
+	return err
+}
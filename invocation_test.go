@@ -0,0 +1,137 @@
+package iopipe
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInvocation_Run(t *testing.T) {
+	var reported *Report
+
+	token := "token"
+	a := NewAgent(Config{
+		Token: &token,
+		Metadata: &InvocationMetadata{
+			AccountID:       "123456789012",
+			MemoryLimitInMB: 512,
+			Region:          "us-east-1",
+		},
+		Reporter: func(report *Report) error {
+			reported = report
+			return nil
+		},
+	})
+
+	Convey("Run reports a unit of work with a synthetic identity", t, func() {
+		var requestID string
+
+		err := a.Run(context.Background(), "nightly-export", func(ctx context.Context) error {
+			context, ok := FromContext(ctx)
+			So(ok, ShouldBeTrue)
+
+			lc, ok := lambdacontext.FromContext(ctx)
+			So(ok, ShouldBeTrue)
+			requestID = lc.AwsRequestID
+
+			context.IOpipe.Metric("rows", 42)
+			return nil
+		})
+
+		So(err, ShouldBeNil)
+		So(reported.AWS.FunctionName, ShouldEqual, "nightly-export")
+		So(reported.AWS.FunctionVersion, ShouldEqual, "$LATEST")
+		So(reported.AWS.InvokedFunctionArn, ShouldEqual, "arn:aws:lambda:us-east-1:123456789012:function:nightly-export")
+		So(reported.AWS.MemoryLimitInMB, ShouldEqual, 512)
+		So(reported.AWS.AWSRequestID, ShouldEqual, requestID)
+		So(reported.CustomMetrics, ShouldContain, CustomMetric{Name: "rows", N: int64(42)})
+		So(reported.Validate(), ShouldBeNil)
+	})
+
+	Convey("Run reports and returns errors", t, func() {
+		err := a.Run(context.Background(), "job", func(ctx context.Context) error {
+			return errors.New("whoops")
+		})
+
+		So(err.Error(), ShouldEqual, "whoops")
+		So(reported.Labels, ShouldContain, "@iopipe/error")
+		So(reported.Errors.(*InvocationError).Message, ShouldEqual, "whoops")
+	})
+
+	Convey("Run reports and repanics panics", t, func() {
+		So(func() {
+			a.Run(context.Background(), "job", func(ctx context.Context) error {
+				panic("oh no")
+			})
+		}, ShouldPanicWith, "oh no")
+
+		So(reported.Labels, ShouldContain, "@iopipe/error")
+		So(reported.Errors.(*InvocationError).Message, ShouldEqual, "oh no")
+	})
+
+	Convey("Run reports timeouts when the context has a deadline", t, func() {
+		timeoutWindow := 50 * time.Millisecond
+		a.TimeoutWindow = &timeoutWindow
+		defer func() { a.TimeoutWindow = &defaultConfigTimeoutWindow }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		a.Run(ctx, "job", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+		So(reported.Labels, ShouldContain, "@iopipe/timeout")
+	})
+}
+
+func TestInvocation_StartInvocation(t *testing.T) {
+	Convey("StartInvocation reports when End is called", t, func() {
+		var reported *Report
+
+		token := "token"
+		a := NewAgent(Config{
+			Token: &token,
+			Reporter: func(report *Report) error {
+				reported = report
+				return nil
+			},
+		})
+
+		ctx, hw := a.StartInvocation(context.Background(), "worker")
+		hw.Label("started")
+		So(reported, ShouldBeNil)
+
+		hw.End(nil)
+		So(reported.AWS.FunctionName, ShouldEqual, "worker")
+		So(reported.AWS.InvokedFunctionArn, ShouldStartWith, "arn:aws:lambda:")
+		So(reported.AWS.InvokedFunctionArn, ShouldEndWith, ":0:function:worker")
+		So(reported.Labels, ShouldContain, "started")
+		So(ctx.Err(), ShouldNotBeNil)
+	})
+
+	Convey("StartInvocation does nothing when the agent is disabled", t, func() {
+		a := NewAgent(Config{Enabled: False()})
+
+		parent, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		ctx, hw := a.StartInvocation(parent, "worker")
+		So(hw, ShouldBeNil)
+		So(ctx == parent, ShouldBeTrue)
+		So(func() { hw.End(nil) }, ShouldNotPanic)
+
+		called := false
+		err := a.Run(context.Background(), "job", func(ctx context.Context) error {
+			called = true
+			return errors.New("whoops")
+		})
+		So(called, ShouldBeTrue)
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,233 @@
+package iopipehttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Event sources served by the adapter
+const (
+	SourceAPIGatewayV1 = "apigateway-v1"
+	SourceAPIGatewayV2 = "apigateway-v2"
+	SourceALB          = "alb"
+	SourceFunctionURL  = "function-url"
+)
+
+// event is the union of the API Gateway REST (v1) and HTTP (v2), ALB and
+// Function URL event fields used by the adapter
+type event struct {
+	Version                         string              `json:"version"`
+	RouteKey                        string              `json:"routeKey"`
+	Resource                        string              `json:"resource"`
+	Path                            string              `json:"path"`
+	HTTPMethod                      string              `json:"httpMethod"`
+	RawPath                         string              `json:"rawPath"`
+	RawQueryString                  string              `json:"rawQueryString"`
+	Cookies                         []string            `json:"cookies"`
+	Headers                         map[string]string   `json:"headers"`
+	MultiValueHeaders               map[string][]string `json:"multiValueHeaders"`
+	QueryStringParameters           map[string]string   `json:"queryStringParameters"`
+	MultiValueQueryStringParameters map[string][]string `json:"multiValueQueryStringParameters"`
+	Body                            string              `json:"body"`
+	IsBase64Encoded                 bool                `json:"isBase64Encoded"`
+	RequestContext                  eventRequestContext `json:"requestContext"`
+}
+
+type eventRequestContext struct {
+	DomainName   string        `json:"domainName"`
+	ResourcePath string        `json:"resourcePath"`
+	Stage        string        `json:"stage"`
+	Identity     eventIdentity `json:"identity"`
+	HTTP         *eventHTTP    `json:"http"`
+	ELB          *eventELB     `json:"elb"`
+}
+
+type eventIdentity struct {
+	SourceIP string `json:"sourceIp"`
+}
+
+type eventHTTP struct {
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	SourceIP string `json:"sourceIp"`
+}
+
+type eventELB struct {
+	TargetGroupArn string `json:"targetGroupArn"`
+}
+
+// source returns the event source the event was sent by
+func (e *event) source() (string, error) {
+	switch {
+	case e.RequestContext.ELB != nil:
+		return SourceALB, nil
+	case e.RequestContext.HTTP != nil && strings.Contains(e.RequestContext.DomainName, ".lambda-url."):
+		return SourceFunctionURL, nil
+	case e.RequestContext.HTTP != nil:
+		return SourceAPIGatewayV2, nil
+	case e.HTTPMethod != "":
+		return SourceAPIGatewayV1, nil
+	default:
+		return "", fmt.Errorf("iopipehttp: unsupported event, expected an API Gateway, ALB or Function URL event")
+	}
+}
+
+// route returns the route template the event matched, or "" if the event
+// source doesn't route requests, so paths with IDs never become labels
+func (e *event) route(source string) string {
+	switch source {
+	case SourceAPIGatewayV1:
+		return e.Resource
+	case SourceAPIGatewayV2:
+		if e.RouteKey != "$default" {
+			return e.RouteKey
+		}
+	}
+
+	return ""
+}
+
+// request converts the event into an HTTP request
+func (e *event) request(ctx context.Context, source string) (*http.Request, error) {
+	body := []byte(e.Body)
+	if e.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(e.Body)
+		if err != nil {
+			return nil, fmt.Errorf("iopipehttp: invalid base64 body: %v", err)
+		}
+		body = decoded
+	}
+
+	var (
+		method      string
+		path        string
+		pathEncoded bool
+		rawQuery    string
+		remoteAddr  string
+		header      = make(http.Header)
+	)
+
+	switch source {
+	case SourceAPIGatewayV2, SourceFunctionURL:
+		method = e.RequestContext.HTTP.Method
+		path = e.RawPath
+		pathEncoded = true
+		rawQuery = e.RawQueryString
+		remoteAddr = e.RequestContext.HTTP.SourceIP
+
+		// Repeated headers are joined with commas, which is equivalent
+		for name, value := range e.Headers {
+			header.Set(name, value)
+		}
+
+		if len(e.Cookies) > 0 {
+			header.Set("Cookie", strings.Join(e.Cookies, "; "))
+		}
+	default:
+		method = e.HTTPMethod
+		// API Gateway decodes the path, ALB passes it on as requested
+		path = e.Path
+		pathEncoded = source == SourceALB
+		remoteAddr = e.RequestContext.Identity.SourceIP
+
+		if len(e.MultiValueHeaders) > 0 {
+			for name, values := range e.MultiValueHeaders {
+				for _, v := range values {
+					header.Add(name, v)
+				}
+			}
+		} else {
+			for name, value := range e.Headers {
+				header.Set(name, value)
+			}
+		}
+
+		rawQuery = e.rawQuery(source == SourceALB)
+	}
+
+	if path == "" {
+		path = "/"
+	}
+
+	// Decoded paths are used as is, since parsing them would fail on or
+	// decode again any % they contain
+	u := &url.URL{Path: path, RawQuery: rawQuery}
+	if pathEncoded {
+		decoded, err := url.PathUnescape(path)
+		if err != nil {
+			return nil, fmt.Errorf("iopipehttp: invalid request path: %v", err)
+		}
+		u.Path = decoded
+		u.RawPath = path
+	}
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header = header
+	req.Host = header.Get("Host")
+	if req.Host == "" {
+		req.Host = e.RequestContext.DomainName
+	}
+	req.URL.Host = req.Host
+	req.RemoteAddr = remoteAddr
+	req.RequestURI = u.RequestURI()
+	if header.Get("X-Forwarded-Proto") == "https" || source == SourceFunctionURL {
+		req.URL.Scheme = "https"
+	}
+
+	return req.WithContext(ctx), nil
+}
+
+// rawQuery encodes the query string parameters, ALB sends them already
+// URL encoded while API Gateway decodes them
+func (e *event) rawQuery(encoded bool) string {
+	escape := url.QueryEscape
+	if encoded {
+		escape = func(s string) string { return s }
+	}
+
+	var parts []string
+
+	if len(e.MultiValueQueryStringParameters) > 0 {
+		for _, name := range sortedKeys(e.MultiValueQueryStringParameters) {
+			for _, value := range e.MultiValueQueryStringParameters[name] {
+				parts = append(parts, escape(name)+"="+escape(value))
+			}
+		}
+	} else {
+		for _, name := range sortedStringKeys(e.QueryStringParameters) {
+			parts = append(parts, escape(name)+"="+escape(e.QueryStringParameters[name]))
+		}
+	}
+
+	return strings.Join(parts, "&")
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,89 @@
+// Package iopipehttp serves an http.Handler from Lambda behind API Gateway
+// REST (v1) and HTTP (v2) APIs, Application Load Balancers and Function URLs,
+// recording each request's route, status code and response size:
+//
+//	var agent = iopipe.NewAgent(iopipe.Config{})
+//
+//	func main() {
+//		lambda.Start(iopipehttp.Wrap(agent, router))
+//	}
+//
+// Handlers can instrument requests further with the handler wrapper in the
+// request context:
+//
+//	context, _ := iopipe.FromContext(req.Context())
+//	context.IOpipe.Label("signup")
+package iopipehttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/iopipe/iopipe-go"
+)
+
+// Handler returns a Lambda handler that serves h for API Gateway, ALB and
+// Function URL events
+func Handler(h http.Handler) func(context.Context, json.RawMessage) (interface{}, error) {
+	return func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+		var e event
+		if err := json.Unmarshal(payload, &e); err != nil {
+			return nil, fmt.Errorf("iopipehttp: invalid event: %v", err)
+		}
+
+		source, err := e.source()
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := e.request(ctx, source)
+		if err != nil {
+			return nil, err
+		}
+
+		w := newResponseWriter()
+		h.ServeHTTP(w, req)
+
+		if context, ok := iopipe.FromContext(ctx); ok && context.IOpipe != nil {
+			record(context.IOpipe, source, e.route(source), req, w)
+		}
+
+		return w.response(&e, source), nil
+	}
+}
+
+// Wrap returns h served by Handler and wrapped with the agent, ready to pass
+// to lambda.Start
+func Wrap(agent *iopipe.Agent, h http.Handler) interface{} {
+	return agent.WrapHandler(Handler(h))
+}
+
+// record records the request's route, path, status code and response size.
+// Only route templates are labeled, paths would create a label per ID.
+func record(hw *iopipe.HandlerWrapper, source, route string, req *http.Request, w *responseWriter) {
+	status := w.statusCode()
+
+	hw.Label("@iopipe/http")
+	hw.Label(fmt.Sprintf("@iopipe/http.status.%dxx", status/100))
+	if route != "" {
+		hw.Label(truncate("@iopipe/http.route:"+route, 128))
+		hw.Metric("@iopipe/http.route", route)
+	}
+
+	hw.Metric("@iopipe/http.source", source)
+	hw.Metric("@iopipe/http.method", req.Method)
+	hw.Metric("@iopipe/http.path", req.URL.Path)
+	hw.Metric("@iopipe/http.status", status)
+	hw.Metric("@iopipe/http.response-size", w.body.Len())
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+
+	return string(runes[:max])
+}
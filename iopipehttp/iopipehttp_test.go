@@ -0,0 +1,186 @@
+package iopipehttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/iopipe/iopipe-go"
+	"github.com/iopipe/iopipe-go/iopipetest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func loadEvent(name string) interface{} {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name+".json"))
+	if err != nil {
+		panic(err)
+	}
+
+	var payload interface{}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		panic(err)
+	}
+
+	return payload
+}
+
+// echo responds with a description of the request it received
+var echo = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+
+	context, ok := iopipe.FromContext(req.Context())
+	if ok {
+		context.IOpipe.Label("echo")
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Add("Set-Cookie", "a=1")
+	w.Header().Add("Set-Cookie", "b=2")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintf(w, "%s %s q=%s host=%s body=%s", req.Method, req.URL.Path, req.URL.Query().Get("q"), req.Host, body)
+})
+
+func TestHandler(t *testing.T) {
+	h := iopipetest.NewHarness(iopipe.Config{})
+
+	Convey("Given each supported event source", t, func() {
+		tests := []struct {
+			source string
+			route  string
+			host   string
+		}{
+			{SourceAPIGatewayV1, "/users/{id}", "abc123.execute-api.us-east-1.amazonaws.com"},
+			{SourceAPIGatewayV2, "POST /users/{id}", "abc123.execute-api.us-east-1.amazonaws.com"},
+			{SourceALB, "", "lb-123.us-east-1.elb.amazonaws.com"},
+			{SourceFunctionURL, "", "abc123.lambda-url.us-east-1.on.aws"},
+		}
+
+		for _, test := range tests {
+			Convey(fmt.Sprintf("%s events are served and recorded", test.source), func() {
+				inv := h.Invoke(Handler(echo), loadEvent(test.source), iopipetest.InvokeConfig{})
+				So(inv.Err, ShouldBeNil)
+
+				expectedBody := fmt.Sprintf(`POST /users/42 q=hello world host=%s body={"name":"jane"}`, test.host)
+
+				response, _ := json.Marshal(inv.Response)
+
+				switch res := inv.Response.(type) {
+				case *restResponse:
+					So(res.StatusCode, ShouldEqual, http.StatusCreated)
+					So(res.Body, ShouldEqual, expectedBody)
+					So(res.IsBase64Encoded, ShouldBeFalse)
+				case *httpResponse:
+					So(res.StatusCode, ShouldEqual, http.StatusCreated)
+					So(res.Body, ShouldEqual, expectedBody)
+					So(res.Cookies, ShouldResemble, []string{"a=1", "b=2"})
+					So(res.Headers["Content-Type"], ShouldEqual, "text/plain")
+				default:
+					So(string(response), ShouldEqual, "an API Gateway, ALB or Function URL response")
+				}
+
+				So(inv.Report, ShouldNotBeNil)
+				So(iopipetest.HasLabel(inv.Report, "echo"), ShouldBeTrue)
+				So(iopipetest.HasLabel(inv.Report, "@iopipe/http"), ShouldBeTrue)
+				So(iopipetest.HasLabel(inv.Report, "@iopipe/http.status.2xx"), ShouldBeTrue)
+				So(iopipetest.MetricValues(inv.Report, "@iopipe/http.source"), ShouldResemble, []interface{}{test.source})
+				So(iopipetest.MetricValues(inv.Report, "@iopipe/http.path"), ShouldResemble, []interface{}{"/users/42"})
+				if test.route != "" {
+					So(iopipetest.HasLabel(inv.Report, "@iopipe/http.route:"+test.route), ShouldBeTrue)
+					So(iopipetest.MetricValues(inv.Report, "@iopipe/http.route"), ShouldResemble, []interface{}{test.route})
+				} else {
+					// Untemplated paths never become labels
+					for _, label := range inv.Report.Labels {
+						So(label, ShouldNotStartWith, "@iopipe/http.route")
+					}
+					So(iopipetest.MetricValues(inv.Report, "@iopipe/http.route"), ShouldBeEmpty)
+				}
+				So(iopipetest.MetricValues(inv.Report, "@iopipe/http.status"), ShouldResemble, []interface{}{int64(201)})
+				So(iopipetest.MetricValues(inv.Report, "@iopipe/http.response-size"), ShouldResemble, []interface{}{int64(len(expectedBody))})
+			})
+		}
+	})
+
+	Convey("ALB responses include a status description and match the request's header style", t, func() {
+		inv := h.Invoke(Handler(echo), loadEvent(SourceALB), iopipetest.InvokeConfig{})
+
+		res := inv.Response.(*restResponse)
+		So(res.StatusDescription, ShouldEqual, "201 Created")
+		So(res.Headers["Set-Cookie"], ShouldEqual, "b=2")
+		So(res.MultiValueHeaders, ShouldBeNil)
+	})
+
+	Convey("Paths containing % are decoded exactly once", t, func() {
+		var path string
+		handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			path = req.URL.Path
+		})
+
+		tests := []struct {
+			source   string
+			field    string
+			sent     string
+			expected string
+		}{
+			{SourceAPIGatewayV1, "path", "/100%", "/100%"},
+			{SourceAPIGatewayV1, "path", "/a%2541", "/a%2541"},
+			{SourceALB, "path", "/100%25", "/100%"},
+			{SourceAPIGatewayV2, "rawPath", "/100%25", "/100%"},
+			{SourceAPIGatewayV2, "rawPath", "/a%2541", "/a%41"},
+			{SourceFunctionURL, "rawPath", "/a%2541", "/a%41"},
+		}
+
+		for _, test := range tests {
+			payload := loadEvent(test.source).(map[string]interface{})
+			payload[test.field] = test.sent
+
+			inv := h.Invoke(Handler(handler), payload, iopipetest.InvokeConfig{})
+
+			So(inv.Err, ShouldBeNil)
+			So(path, ShouldEqual, test.expected)
+		}
+	})
+
+	Convey("API Gateway v1 multi-value headers are passed through", t, func() {
+		var tags []string
+		handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			tags = req.Header["X-Tag"]
+		})
+
+		inv := h.Invoke(Handler(handler), loadEvent(SourceAPIGatewayV1), iopipetest.InvokeConfig{})
+
+		So(tags, ShouldResemble, []string{"a", "b"})
+		So(inv.Response.(*restResponse).StatusCode, ShouldEqual, http.StatusOK)
+	})
+
+	Convey("Binary responses are base64 encoded", t, func() {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte{0xff, 0xfe, 0x00})
+		})
+
+		inv := h.Invoke(Handler(handler), loadEvent(SourceAPIGatewayV2), iopipetest.InvokeConfig{})
+
+		res := inv.Response.(*httpResponse)
+		So(res.IsBase64Encoded, ShouldBeTrue)
+		So(res.Body, ShouldEqual, "//4A")
+		So(res.Headers["Content-Type"], ShouldEqual, "application/octet-stream")
+	})
+
+	Convey("Server errors are labeled by status class", t, func() {
+		handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			http.Error(w, "whoops", http.StatusInternalServerError)
+		})
+
+		inv := h.Invoke(Handler(handler), loadEvent(SourceFunctionURL), iopipetest.InvokeConfig{})
+
+		So(iopipetest.HasLabel(inv.Report, "@iopipe/http.status.5xx"), ShouldBeTrue)
+	})
+
+	Convey("Unsupported events return an error", t, func() {
+		_, err := Handler(echo)(context.Background(), json.RawMessage(`{"Records":[]}`))
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,137 @@
+package iopipehttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// responseWriter buffers the handler's response so it can be returned to
+// the event source
+type responseWriter struct {
+	body        bytes.Buffer
+	header      http.Header
+	status      int
+	wroteHeader bool
+}
+
+func newResponseWriter() *responseWriter {
+	return &responseWriter{header: make(http.Header)}
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.body.Write(p)
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+
+	w.status = status
+	w.wroteHeader = true
+}
+
+// statusCode returns the response status, 200 if the handler never wrote one
+func (w *responseWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
+
+// encodedBody returns the body and whether it is base64 encoded, binary and
+// compressed bodies can't be returned as JSON strings
+func (w *responseWriter) encodedBody() (string, bool) {
+	body := w.body.Bytes()
+
+	if w.header.Get("Content-Encoding") != "" || !utf8.Valid(body) {
+		return base64.StdEncoding.EncodeToString(body), true
+	}
+
+	return string(body), false
+}
+
+// restResponse is the response to API Gateway REST (v1) and ALB events
+type restResponse struct {
+	StatusCode        int                 `json:"statusCode"`
+	StatusDescription string              `json:"statusDescription,omitempty"`
+	Headers           map[string]string   `json:"headers,omitempty"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders,omitempty"`
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded"`
+}
+
+// httpResponse is the response to API Gateway HTTP (v2) and Function URL events
+type httpResponse struct {
+	StatusCode      int               `json:"statusCode"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Cookies         []string          `json:"cookies,omitempty"`
+	Body            string            `json:"body"`
+	IsBase64Encoded bool              `json:"isBase64Encoded"`
+}
+
+// response converts the buffered response into the response expected by the
+// event source
+func (w *responseWriter) response(e *event, source string) interface{} {
+	// Sniff the content type like net/http does
+	if w.header.Get("Content-Type") == "" && w.body.Len() > 0 {
+		w.header.Set("Content-Type", http.DetectContentType(w.body.Bytes()))
+	}
+
+	body, isBase64Encoded := w.encodedBody()
+
+	switch source {
+	case SourceAPIGatewayV2, SourceFunctionURL:
+		res := &httpResponse{
+			StatusCode:      w.statusCode(),
+			Headers:         make(map[string]string),
+			Body:            body,
+			IsBase64Encoded: isBase64Encoded,
+		}
+
+		for name, values := range w.header {
+			if name == "Set-Cookie" {
+				res.Cookies = append(res.Cookies, values...)
+				continue
+			}
+			res.Headers[name] = strings.Join(values, ",")
+		}
+
+		return res
+	default:
+		res := &restResponse{
+			StatusCode:      w.statusCode(),
+			Body:            body,
+			IsBase64Encoded: isBase64Encoded,
+		}
+
+		if source == SourceALB {
+			res.StatusDescription = fmt.Sprintf("%d %s", res.StatusCode, http.StatusText(res.StatusCode))
+		}
+
+		// ALB only accepts multi-value headers if the target group sends them
+		if source == SourceALB && len(e.MultiValueHeaders) == 0 {
+			res.Headers = make(map[string]string)
+			for name, values := range w.header {
+				res.Headers[name] = values[len(values)-1]
+			}
+		} else {
+			res.MultiValueHeaders = w.header
+		}
+
+		return res
+	}
+}
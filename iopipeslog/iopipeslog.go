@@ -0,0 +1,220 @@
+//go:build go1.21
+// +build go1.21
+
+// Package iopipeslog provides a log/slog handler that writes records logged
+// during an invocation to the invocation's log, where the logger plugin
+// captures them with the request ID and attributes as structured fields:
+//
+//	logger := slog.New(iopipeslog.NewHandler(slog.NewJSONHandler(os.Stderr, nil)))
+//
+//	func Hello(ctx context.Context) error {
+//		logger.InfoContext(ctx, "checkout", "items", 3)
+//		return nil
+//	}
+//
+// Records need the invocation's context to be captured, those logged without
+// it go to the fallback handler.
+package iopipeslog
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"time"
+
+	"github.com/iopipe/iopipe-go"
+	"github.com/sirupsen/logrus"
+)
+
+// Handler is a slog.Handler writing to the invocation's log
+type Handler struct {
+	fallback slog.Handler
+	name     string
+	attrs    []groupOrAttrs
+}
+
+// groupOrAttrs is a group or attributes added with WithGroup or WithAttrs
+type groupOrAttrs struct {
+	group string
+	attrs []slog.Attr
+}
+
+// NewHandler returns a handler writing records logged with an invocation's
+// context to the invocation's log, and other records to fallback, which may
+// be nil to drop them
+func NewHandler(fallback slog.Handler) *Handler {
+	return &Handler{fallback: fallback}
+}
+
+// WithName returns a handler recording name as the logger name
+func (h *Handler) WithName(name string) *Handler {
+	handler := *h
+	handler.name = name
+	return &handler
+}
+
+// Enabled reports whether the handler handles records at level
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	if context, ok := iopipe.FromContext(ctx); ok && context.IOpipe != nil {
+		return context.IOpipe.Log.IsLevelEnabled(logrusLevel(level))
+	}
+
+	return h.fallback != nil && h.fallback.Enabled(ctx, level)
+}
+
+// Handle writes the record to the invocation's log
+func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
+	context, ok := iopipe.FromContext(ctx)
+	if !ok || context.IOpipe == nil {
+		if h.fallback == nil {
+			return nil
+		}
+		return h.fallback.Handle(ctx, record)
+	}
+
+	entry := &logrus.Entry{
+		Time:    record.Time,
+		Level:   logrusLevel(record.Level),
+		Message: record.Message,
+		Data:    h.fields(record),
+	}
+
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	if record.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{record.PC}).Next()
+		entry.Caller = &frame
+	}
+
+	return context.IOpipe.WriteLogEntry(h.name, entry)
+}
+
+// WithAttrs returns a handler adding attrs to every record
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	return h.with(groupOrAttrs{attrs: attrs}, func(fallback slog.Handler) slog.Handler {
+		return fallback.WithAttrs(attrs)
+	})
+}
+
+// WithGroup returns a handler nesting the attributes added after it in name
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	return h.with(groupOrAttrs{group: name}, func(fallback slog.Handler) slog.Handler {
+		return fallback.WithGroup(name)
+	})
+}
+
+func (h *Handler) with(goa groupOrAttrs, withFallback func(slog.Handler) slog.Handler) *Handler {
+	handler := *h
+	handler.attrs = append(append([]groupOrAttrs{}, h.attrs...), goa)
+	if h.fallback != nil {
+		handler.fallback = withFallback(h.fallback)
+	}
+
+	return &handler
+}
+
+// fields returns the handler's and record's attributes as nested fields
+func (h *Handler) fields(record slog.Record) logrus.Fields {
+	var (
+		fields  = logrus.Fields{}
+		current = map[string]interface{}(fields)
+	)
+
+	// Groups without attributes are left out
+	groups := h.attrs
+	if record.NumAttrs() == 0 {
+		for len(groups) > 0 && groups[len(groups)-1].group != "" {
+			groups = groups[:len(groups)-1]
+		}
+	}
+
+	for _, goa := range groups {
+		if goa.group != "" {
+			group := map[string]interface{}{}
+			current[goa.group] = group
+			current = group
+			continue
+		}
+
+		for _, attr := range goa.attrs {
+			addAttr(current, attr)
+		}
+	}
+
+	record.Attrs(func(attr slog.Attr) bool {
+		addAttr(current, attr)
+		return true
+	})
+
+	return fields
+}
+
+// addAttr adds attr to fields, inlining groups without a key
+func addAttr(fields map[string]interface{}, attr slog.Attr) {
+	value := attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	if value.Kind() != slog.KindGroup {
+		fields[attr.Key] = attrValue(value)
+		return
+	}
+
+	group := value.Group()
+	if len(group) == 0 {
+		return
+	}
+
+	target := fields
+	if attr.Key != "" {
+		target = map[string]interface{}{}
+		fields[attr.Key] = target
+	}
+
+	for _, groupAttr := range group {
+		addAttr(target, groupAttr)
+	}
+}
+
+// attrValue returns the JSON friendly value of an attribute
+func attrValue(value slog.Value) interface{} {
+	switch value.Kind() {
+	case slog.KindDuration:
+		return value.Duration().String()
+	case slog.KindTime:
+		return value.Time().Format(time.RFC3339Nano)
+	case slog.KindAny:
+		if err, ok := value.Any().(error); ok {
+			return err.Error()
+		}
+	}
+
+	return value.Any()
+}
+
+// logrusLevel returns the logrus level of a slog level
+func logrusLevel(level slog.Level) logrus.Level {
+	switch {
+	case level >= slog.LevelError:
+		return logrus.ErrorLevel
+	case level >= slog.LevelWarn:
+		return logrus.WarnLevel
+	case level >= slog.LevelInfo:
+		return logrus.InfoLevel
+	case level >= slog.LevelDebug:
+		return logrus.DebugLevel
+	default:
+		return logrus.TraceLevel
+	}
+}
@@ -0,0 +1,82 @@
+//go:build go1.21
+// +build go1.21
+
+package iopipeslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/iopipe/iopipe-go"
+	"github.com/iopipe/iopipe-go/iopipetest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// capture invokes fn with the invocation's log written as JSON to a buffer,
+// returning the logged entries
+func capture(fn func(ctx context.Context)) []map[string]interface{} {
+	var buf bytes.Buffer
+
+	h := iopipetest.NewHarness(iopipe.Config{})
+	h.Invoke(func(ctx context.Context) error {
+		context, _ := iopipe.FromContext(ctx)
+		context.IOpipe.Log.SetOutput(&buf)
+		context.IOpipe.Log.Formatter = iopipe.JSONFormatter{}
+		context.IOpipe.Log.ReportCaller = true
+
+		fn(ctx)
+		return nil
+	}, nil, iopipetest.InvokeConfig{AwsRequestID: "request-1"})
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var entry map[string]interface{}
+		json.Unmarshal([]byte(line), &entry)
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestHandler(t *testing.T) {
+	Convey("Records logged with an invocation's context are written to its log", t, func() {
+		entries := capture(func(ctx context.Context) {
+			logger := slog.New(NewHandler(nil).WithName("checkout")).With("service", "cart")
+			logger.WithGroup("order").InfoContext(ctx, "checkout failed", "items", 3, "err", errors.New("out of stock"))
+			logger.DebugContext(ctx, "not logged")
+		})
+
+		So(entries, ShouldHaveLength, 1)
+		So(entries[0]["name"], ShouldEqual, "checkout")
+		So(entries[0]["message"], ShouldEqual, "checkout failed")
+		So(entries[0]["severity"], ShouldEqual, "info")
+		So(entries[0]["requestId"], ShouldEqual, "request-1")
+		So(entries[0]["fields"], ShouldResemble, map[string]interface{}{
+			"service": "cart",
+			"order":   map[string]interface{}{"items": float64(3), "err": "out of stock"},
+		})
+
+		caller := entries[0]["caller"].(map[string]interface{})
+		So(caller["file"], ShouldEndWith, "iopipeslog_test.go")
+	})
+
+	Convey("Records logged without an invocation's context go to the fallback", t, func() {
+		var buf bytes.Buffer
+
+		logger := slog.New(NewHandler(slog.NewTextHandler(&buf, nil)))
+		logger.Info("outside", "items", 3)
+
+		So(buf.String(), ShouldContainSubstring, "msg=outside items=3")
+
+		So(func() { slog.New(NewHandler(nil)).Info("dropped") }, ShouldNotPanic)
+	})
+}
@@ -0,0 +1,186 @@
+package iopipetest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/iopipe/iopipe-go"
+)
+
+// HasLabel returns true if the report contains the label
+func HasLabel(report *iopipe.Report, label string) bool {
+	if report == nil {
+		return false
+	}
+
+	for _, l := range report.Labels {
+		if l == label {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MetricValues returns the values recorded for the named custom metric
+func MetricValues(report *iopipe.Report, name string) []interface{} {
+	var values []interface{}
+
+	if report == nil {
+		return values
+	}
+
+	for _, metric := range report.CustomMetrics {
+		if metric.Name != name {
+			continue
+		}
+
+		if metric.S != nil {
+			values = append(values, metric.S)
+		} else {
+			values = append(values, metric.N)
+		}
+	}
+
+	return values
+}
+
+// InvocationError returns the error recorded in the report, or nil
+func InvocationError(report *iopipe.Report) *iopipe.InvocationError {
+	if report == nil {
+		return nil
+	}
+
+	invErr, _ := report.Errors.(*iopipe.InvocationError)
+	return invErr
+}
+
+// AssertLabel fails the test if the report does not contain the label
+func AssertLabel(t testing.TB, report *iopipe.Report, label string) {
+	t.Helper()
+
+	if !HasLabel(report, label) {
+		t.Errorf("expected report to have label %q, got %v", label, labels(report))
+	}
+}
+
+// AssertNoLabel fails the test if the report contains the label
+func AssertNoLabel(t testing.TB, report *iopipe.Report, label string) {
+	t.Helper()
+
+	if HasLabel(report, label) {
+		t.Errorf("expected report not to have label %q", label)
+	}
+}
+
+// AssertMetric fails the test if the report has no custom metric named name
+// with the value. Numeric values are compared after coercion to float64.
+func AssertMetric(t testing.TB, report *iopipe.Report, name string, value interface{}) {
+	t.Helper()
+
+	values := MetricValues(report, name)
+	for _, v := range values {
+		if metricValueEqual(v, value) {
+			return
+		}
+	}
+
+	if len(values) == 0 {
+		t.Errorf("expected report to have metric %q", name)
+		return
+	}
+
+	t.Errorf("expected metric %q to have value %v, got %v", name, value, values)
+}
+
+// AssertNoMetric fails the test if the report has a custom metric named name
+func AssertNoMetric(t testing.TB, report *iopipe.Report, name string) {
+	t.Helper()
+
+	if values := MetricValues(report, name); len(values) > 0 {
+		t.Errorf("expected report not to have metric %q, got %v", name, values)
+	}
+}
+
+// AssertError fails the test if the report has no error with the message
+func AssertError(t testing.TB, report *iopipe.Report, message string) {
+	t.Helper()
+
+	invErr := InvocationError(report)
+	if invErr == nil {
+		t.Errorf("expected report to have error %q, got none", message)
+		return
+	}
+
+	if invErr.Message != message {
+		t.Errorf("expected report to have error %q, got %q", message, invErr.Message)
+	}
+}
+
+// AssertNoError fails the test if the report has an error
+func AssertNoError(t testing.TB, report *iopipe.Report) {
+	t.Helper()
+
+	if invErr := InvocationError(report); invErr != nil {
+		t.Errorf("expected report to have no error, got %q", invErr.Message)
+	}
+}
+
+// AssertHookCalled fails the test if hook was not called times times
+func AssertHookCalled(t testing.TB, recorder *HookRecorder, hook string, times int) {
+	t.Helper()
+
+	if calls := recorder.Calls(hook); calls != times {
+		t.Errorf("expected hook %s to be called %d times, got %d", hook, times, calls)
+	}
+}
+
+func labels(report *iopipe.Report) []string {
+	if report == nil {
+		return nil
+	}
+
+	return report.Labels
+}
+
+func metricValueEqual(actual, expected interface{}) bool {
+	actualFloat, actualIsNumber := toFloat(actual)
+	expectedFloat, expectedIsNumber := toFloat(expected)
+
+	if actualIsNumber && expectedIsNumber {
+		return actualFloat == expectedFloat
+	}
+
+	return fmt.Sprintf("%v", actual) == fmt.Sprintf("%v", expected) && !actualIsNumber && !expectedIsNumber
+}
+
+func toFloat(x interface{}) (float64, bool) {
+	switch x := x.(type) {
+	case int:
+		return float64(x), true
+	case int8:
+		return float64(x), true
+	case int16:
+		return float64(x), true
+	case int32:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case uint:
+		return float64(x), true
+	case uint8:
+		return float64(x), true
+	case uint16:
+		return float64(x), true
+	case uint32:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case float32:
+		return float64(x), true
+	case float64:
+		return x, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,199 @@
+// Package iopipetest provides a local invocation harness for unit-testing
+// handlers wrapped by the IOpipe agent.
+package iopipetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/iopipe/iopipe-go"
+	"github.com/iopipe/iopipe-go/internal/coldstart"
+)
+
+// InvokeConfig configures a single local invocation
+type InvokeConfig struct {
+	AwsRequestID       string
+	ClientContext      lambdacontext.ClientContext
+	ColdStart          *bool
+	FunctionName       string
+	Identity           lambdacontext.CognitoIdentity
+	InvokedFunctionArn string
+	MemoryLimitInMB    int
+	Timeout            time.Duration
+}
+
+// Invocation is the outcome of a local invocation
+type Invocation struct {
+	Response interface{}
+	Err      error
+	Panic    interface{}
+
+	// Report is the last report sent for the invocation
+	Report *iopipe.Report
+
+	// Reports are all reports sent for the invocation, in the order sent
+	Reports []*iopipe.Report
+}
+
+// invokeMutex serializes invocations of every harness, as they set the
+// lambdacontext globals and the cold start flag, which are process wide
+var invokeMutex sync.Mutex
+
+// Harness invokes handlers with an IOpipe agent and captures the reports in memory
+type Harness struct {
+	Agent    *iopipe.Agent
+	Recorder *HookRecorder
+
+	mutex   sync.Mutex
+	reports []*iopipe.Report
+}
+
+// NewHarness returns a new harness with an agent created from config. Reports
+// are captured in memory before being passed to config.Reporter, if any.
+func NewHarness(config iopipe.Config) *Harness {
+	h := &Harness{
+		Recorder: NewHookRecorder(),
+	}
+
+	if config.Token == nil {
+		token := "iopipetest"
+		config.Token = &token
+	}
+
+	if config.Enabled == nil {
+		config.Enabled = iopipe.True()
+	}
+
+	reporter := config.Reporter
+	config.Reporter = func(report *iopipe.Report) error {
+		h.mutex.Lock()
+		h.reports = append(h.reports, report)
+		h.mutex.Unlock()
+
+		if reporter != nil {
+			return reporter(report)
+		}
+
+		return nil
+	}
+
+	plugins := make([]iopipe.PluginInstantiator, len(config.Plugins), len(config.Plugins)+1)
+	copy(plugins, config.Plugins)
+	config.Plugins = append(plugins, h.Recorder.Instantiator())
+
+	h.Agent = iopipe.NewAgent(config)
+
+	return h
+}
+
+// Invoke invokes handler with payload using a synthetic lambda context.
+// Panics raised by the handler are recovered and returned in the invocation.
+// Invocations of every harness run one at a time, so parallel tests can use
+// harnesses, but handlers must not call Invoke themselves.
+func (h *Harness) Invoke(handler interface{}, payload interface{}, config InvokeConfig) (invocation *Invocation) {
+	invokeMutex.Lock()
+	defer invokeMutex.Unlock()
+
+	invocation = &Invocation{}
+
+	if config.AwsRequestID == "" {
+		config.AwsRequestID = fmt.Sprintf("iopipetest-%d", time.Now().UnixNano())
+	}
+
+	if config.FunctionName == "" {
+		config.FunctionName = "iopipetest"
+	}
+
+	if config.InvokedFunctionArn == "" {
+		config.InvokedFunctionArn = fmt.Sprintf("arn:aws:lambda:local:0:function:%s", config.FunctionName)
+	}
+
+	if config.ColdStart != nil {
+		coldstart.Set(*config.ColdStart)
+	}
+
+	restore := setLambdaGlobals(config)
+	defer restore()
+
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{
+		AwsRequestID:       config.AwsRequestID,
+		ClientContext:      config.ClientContext,
+		Identity:           config.Identity,
+		InvokedFunctionArn: config.InvokedFunctionArn,
+	})
+
+	var cancel context.CancelFunc
+	if config.Timeout > 0 {
+		ctx, cancel = context.WithDeadline(ctx, time.Now().Add(config.Timeout))
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	defer func() {
+		invocation.Panic = recover()
+		cancel()
+
+		invocation.Reports = h.ReportsFor(config.AwsRequestID)
+		if len(invocation.Reports) > 0 {
+			invocation.Report = invocation.Reports[len(invocation.Reports)-1]
+		}
+	}()
+
+	hw := iopipe.NewHandlerWrapper(handler, h.Agent)
+	invocation.Response, invocation.Err = hw.Invoke(ctx, payload)
+
+	return invocation
+}
+
+// Reports returns all reports captured by the harness
+func (h *Harness) Reports() []*iopipe.Report {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	reports := make([]*iopipe.Report, len(h.reports))
+	copy(reports, h.reports)
+
+	return reports
+}
+
+// ReportsFor returns the reports captured for the request ID
+func (h *Harness) ReportsFor(requestID string) []*iopipe.Report {
+	var reports []*iopipe.Report
+
+	for _, report := range h.Reports() {
+		if report.AWS != nil && report.AWS.AWSRequestID == requestID {
+			reports = append(reports, report)
+		}
+	}
+
+	return reports
+}
+
+// Reset discards captured reports and recorded hook calls
+func (h *Harness) Reset() {
+	h.mutex.Lock()
+	h.reports = nil
+	h.mutex.Unlock()
+
+	h.Recorder.Reset()
+}
+
+// setLambdaGlobals sets the lambdacontext globals read by the agent and
+// returns a function that restores them
+func setLambdaGlobals(config InvokeConfig) func() {
+	oldFunctionName := lambdacontext.FunctionName
+	oldMemoryLimitInMB := lambdacontext.MemoryLimitInMB
+
+	lambdacontext.FunctionName = config.FunctionName
+	if config.MemoryLimitInMB > 0 {
+		lambdacontext.MemoryLimitInMB = config.MemoryLimitInMB
+	}
+
+	return func() {
+		lambdacontext.FunctionName = oldFunctionName
+		lambdacontext.MemoryLimitInMB = oldMemoryLimitInMB
+	}
+}
@@ -0,0 +1,165 @@
+package iopipetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iopipe/iopipe-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type fakeT struct {
+	testing.TB
+	failures []string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.failures = append(t.failures, fmt.Sprintf(format, args...))
+}
+
+func TestHarness_Invoke(t *testing.T) {
+	Convey("Given a harness", t, func() {
+		h := NewHarness(iopipe.Config{})
+
+		Convey("Setup hooks are called once", func() {
+			AssertHookCalled(t, h.Recorder, PreSetup, 1)
+			AssertHookCalled(t, h.Recorder, PostSetup, 1)
+		})
+
+		Convey("A successful invocation captures the report", func() {
+			inv := h.Invoke(func(ctx context.Context, payload string) (string, error) {
+				context, _ := iopipe.FromContext(ctx)
+				context.IOpipe.Label("greeted")
+				context.IOpipe.Metric("name", payload)
+				context.IOpipe.Metric("answer", 42)
+
+				return fmt.Sprintf("Hello %s", payload), nil
+			}, "world", InvokeConfig{AwsRequestID: "abc-123"})
+
+			So(inv.Err, ShouldBeNil)
+			So(inv.Panic, ShouldBeNil)
+			So(inv.Response, ShouldEqual, "Hello world")
+			So(inv.Report, ShouldNotBeNil)
+			So(inv.Report.AWS.AWSRequestID, ShouldEqual, "abc-123")
+			So(inv.Report.AWS.FunctionName, ShouldEqual, "iopipetest")
+
+			AssertLabel(t, inv.Report, "greeted")
+			AssertLabel(t, inv.Report, "@iopipe/metrics")
+			AssertMetric(t, inv.Report, "name", "world")
+			AssertMetric(t, inv.Report, "answer", 42)
+			AssertMetric(t, inv.Report, "answer", 42.0)
+			AssertNoMetric(t, inv.Report, "missing")
+			AssertNoError(t, inv.Report)
+
+			AssertHookCalled(t, h.Recorder, PreInvoke, 1)
+			AssertHookCalled(t, h.Recorder, PostInvoke, 1)
+			AssertHookCalled(t, h.Recorder, PreReport, 1)
+			AssertHookCalled(t, h.Recorder, PostReport, 1)
+		})
+
+		Convey("Cold and warm state can be configured", func() {
+			handler := func() error { return nil }
+
+			inv := h.Invoke(handler, nil, InvokeConfig{ColdStart: iopipe.True()})
+			So(inv.Report.ColdStart, ShouldBeTrue)
+			AssertLabel(t, inv.Report, "@iopipe/coldstart")
+
+			inv = h.Invoke(handler, nil, InvokeConfig{})
+			So(inv.Report.ColdStart, ShouldBeFalse)
+
+			inv = h.Invoke(handler, nil, InvokeConfig{ColdStart: iopipe.False()})
+			So(inv.Report.ColdStart, ShouldBeFalse)
+			AssertNoLabel(t, inv.Report, "@iopipe/coldstart")
+		})
+
+		Convey("Returned errors are reported", func() {
+			inv := h.Invoke(func() error {
+				return fmt.Errorf("whoops")
+			}, nil, InvokeConfig{})
+
+			So(inv.Err, ShouldNotBeNil)
+			AssertError(t, inv.Report, "whoops")
+			AssertLabel(t, inv.Report, "@iopipe/error")
+		})
+
+		Convey("Panics are recovered and reported", func() {
+			inv := h.Invoke(func() error {
+				panic("meow")
+			}, nil, InvokeConfig{})
+
+			So(inv.Panic, ShouldEqual, "meow")
+			AssertError(t, inv.Report, "meow")
+		})
+
+		Convey("A deadline triggers timeout reporting", func() {
+			inv := h.Invoke(func() error {
+				time.Sleep(200 * time.Millisecond)
+				return nil
+			}, nil, InvokeConfig{Timeout: 180 * time.Millisecond})
+
+			So(len(inv.Reports), ShouldEqual, 1)
+			AssertError(t, inv.Report, "Timeout Exceeded")
+			AssertLabel(t, inv.Report, "@iopipe/timeout")
+		})
+
+		Convey("Concurrent invocations keep their own cold start and function name", func() {
+			var wg sync.WaitGroup
+			invocations := make([]*Invocation, 20)
+
+			for index := range invocations {
+				wg.Add(1)
+				go func(index int) {
+					defer wg.Done()
+
+					coldStart := index%2 == 0
+					invocations[index] = h.Invoke(func() error { return nil }, nil, InvokeConfig{
+						AwsRequestID: fmt.Sprintf("concurrent-%d", index),
+						ColdStart:    &coldStart,
+						FunctionName: fmt.Sprintf("function-%d", index),
+					})
+				}(index)
+			}
+			wg.Wait()
+
+			for index, inv := range invocations {
+				So(inv.Report.ColdStart, ShouldEqual, index%2 == 0)
+				So(inv.Report.AWS.FunctionName, ShouldEqual, fmt.Sprintf("function-%d", index))
+			}
+		})
+
+		Convey("Reset clears captured reports and hook calls", func() {
+			h.Invoke(func() error { return nil }, nil, InvokeConfig{})
+			h.Reset()
+
+			So(h.Reports(), ShouldBeEmpty)
+			AssertHookCalled(t, h.Recorder, PreInvoke, 0)
+		})
+	})
+}
+
+func TestAssert_Failures(t *testing.T) {
+	Convey("Assertion helpers report failures", t, func() {
+		report := &iopipe.Report{
+			CustomMetrics: []iopipe.CustomMetric{{Name: "foo", N: int64(1)}},
+			Errors:        &struct{}{},
+			Labels:        []string{"foo"},
+		}
+		ft := &fakeT{}
+
+		AssertLabel(ft, report, "bar")
+		AssertNoLabel(ft, report, "foo")
+		AssertMetric(ft, report, "foo", 2)
+		AssertMetric(ft, report, "foo", "1")
+		AssertMetric(ft, report, "bar", 1)
+		AssertNoMetric(ft, report, "foo")
+		AssertError(ft, report, "whoops")
+		AssertHookCalled(ft, NewHookRecorder(), PreInvoke, 1)
+
+		So(len(ft.failures), ShouldEqual, 8)
+	})
+}
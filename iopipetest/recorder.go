@@ -0,0 +1,163 @@
+package iopipetest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/iopipe/iopipe-go"
+)
+
+// Hook names recorded by the HookRecorder
+const (
+	PreSetup   = "PreSetup"
+	PostSetup  = "PostSetup"
+	PreInvoke  = "PreInvoke"
+	PostInvoke = "PostInvoke"
+	PreReport  = "PreReport"
+	PostReport = "PostReport"
+
+	OnColdStart = "OnColdStart"
+	OnTimeout   = "OnTimeout"
+	OnPanic     = "OnPanic"
+	OnError     = "OnError"
+	OnShutdown  = "OnShutdown"
+	OnLabel     = "OnLabel"
+	OnMetric    = "OnMetric"
+)
+
+// HookRecorder is a plugin that records the hooks called by the agent
+type HookRecorder struct {
+	calls map[string]int
+	hooks []string
+	mutex sync.Mutex
+}
+
+// NewHookRecorder returns a new hook recorder
+func NewHookRecorder() *HookRecorder {
+	return &HookRecorder{
+		calls: make(map[string]int),
+	}
+}
+
+// Instantiator returns a plugin instantiator that always returns the recorder
+func (r *HookRecorder) Instantiator() iopipe.PluginInstantiator {
+	return func() iopipe.Plugin {
+		return r
+	}
+}
+
+// Calls returns the number of times hook was called
+func (r *HookRecorder) Calls(hook string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.calls[hook]
+}
+
+// Hooks returns the names of the hooks called, in the order called
+func (r *HookRecorder) Hooks() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	hooks := make([]string, len(r.hooks))
+	copy(hooks, r.hooks)
+
+	return hooks
+}
+
+// Reset clears the recorded hook calls
+func (r *HookRecorder) Reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.calls = make(map[string]int)
+	r.hooks = nil
+}
+
+func (r *HookRecorder) record(hook string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.calls[hook]++
+	r.hooks = append(r.hooks, hook)
+}
+
+// Meta returns the recorder plugin meta data
+func (r *HookRecorder) Meta() *iopipe.PluginMeta {
+	return &iopipe.PluginMeta{
+		Name:     "@iopipe/iopipetest-recorder",
+		Version:  iopipe.VERSION,
+		Homepage: "https://github.com/iopipe/iopipe-go",
+		Enabled:  r.Enabled(),
+	}
+}
+
+// Enabled returns true
+func (r *HookRecorder) Enabled() bool {
+	return true
+}
+
+// PreSetup records the PreSetup hook
+func (r *HookRecorder) PreSetup(agent *iopipe.Agent) {
+	r.record(PreSetup)
+}
+
+// PostSetup records the PostSetup hook
+func (r *HookRecorder) PostSetup(agent *iopipe.Agent) {
+	r.record(PostSetup)
+}
+
+// PreInvoke records the PreInvoke hook
+func (r *HookRecorder) PreInvoke(ctx context.Context, payload interface{}) {
+	r.record(PreInvoke)
+}
+
+// PostInvoke records the PostInvoke hook
+func (r *HookRecorder) PostInvoke(ctx context.Context, payload interface{}) {
+	r.record(PostInvoke)
+}
+
+// PreReport records the PreReport hook
+func (r *HookRecorder) PreReport(report *iopipe.Report) {
+	r.record(PreReport)
+}
+
+// PostReport records the PostReport hook
+func (r *HookRecorder) PostReport(report *iopipe.Report) {
+	r.record(PostReport)
+}
+
+// OnColdStart records the OnColdStart hook
+func (r *HookRecorder) OnColdStart(ctx context.Context) {
+	r.record(OnColdStart)
+}
+
+// OnTimeout records the OnTimeout hook
+func (r *HookRecorder) OnTimeout(ctx context.Context) {
+	r.record(OnTimeout)
+}
+
+// OnPanic records the OnPanic hook
+func (r *HookRecorder) OnPanic(ctx context.Context, recovered interface{}) {
+	r.record(OnPanic)
+}
+
+// OnError records the OnError hook
+func (r *HookRecorder) OnError(ctx context.Context, err error) {
+	r.record(OnError)
+}
+
+// OnShutdown records the OnShutdown hook
+func (r *HookRecorder) OnShutdown() {
+	r.record(OnShutdown)
+}
+
+// OnLabel records the OnLabel hook
+func (r *HookRecorder) OnLabel(hw *iopipe.HandlerWrapper, name string) {
+	r.record(OnLabel)
+}
+
+// OnMetric records the OnMetric hook
+func (r *HookRecorder) OnMetric(hw *iopipe.HandlerWrapper, metric iopipe.CustomMetric) {
+	r.record(OnMetric)
+}
@@ -0,0 +1,123 @@
+// Package iopipezap provides a zap core that writes entries to an
+// invocation's log, where the logger plugin captures them with the request ID
+// and fields as structured fields. zap loggers don't carry a context, so
+// create a logger for each invocation:
+//
+//	func Hello(ctx context.Context) error {
+//		logger := zap.New(iopipezap.NewCore(ctx, nil), zap.AddCaller())
+//		logger.Info("checkout", zap.Int("items", 3))
+//		return nil
+//	}
+//
+// The logger plugin passes the entries through to stderr, so the core
+// replaces zap's own output rather than being teed with it.
+package iopipezap
+
+import (
+	"context"
+	"runtime"
+
+	"github.com/iopipe/iopipe-go"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap/zapcore"
+)
+
+// Core is a zapcore.Core writing to an invocation's log
+type Core struct {
+	hw     *iopipe.HandlerWrapper
+	fields []zapcore.Field
+}
+
+// NewCore returns a core writing to the log of the invocation of ctx, or
+// fallback if ctx isn't an invocation's context, which may be nil to drop the
+// entries
+func NewCore(ctx context.Context, fallback zapcore.Core) zapcore.Core {
+	context, ok := iopipe.FromContext(ctx)
+	if !ok || context.IOpipe == nil {
+		if fallback == nil {
+			return zapcore.NewNopCore()
+		}
+		return fallback
+	}
+
+	return &Core{hw: context.IOpipe}
+}
+
+// Enabled reports whether entries at level are written
+func (c *Core) Enabled(level zapcore.Level) bool {
+	return c.hw.Log.IsLevelEnabled(logrusLevel(level))
+}
+
+// With returns a core adding fields to every entry
+func (c *Core) With(fields []zapcore.Field) zapcore.Core {
+	return &Core{
+		hw:     c.hw,
+		fields: append(append([]zapcore.Field{}, c.fields...), fields...),
+	}
+}
+
+// Check adds the core to the checked entry if the entry's level is enabled
+func (c *Core) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+
+	return checked
+}
+
+// Write writes the entry to the invocation's log
+func (c *Core) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	encoder := zapcore.NewMapObjectEncoder()
+	for _, field := range c.fields {
+		field.AddTo(encoder)
+	}
+	for _, field := range fields {
+		field.AddTo(encoder)
+	}
+
+	logEntry := &logrus.Entry{
+		Time:    entry.Time,
+		Level:   logrusLevel(entry.Level),
+		Message: entry.Message,
+		Data:    logrus.Fields(encoder.Fields),
+	}
+
+	if entry.Caller.Defined {
+		logEntry.Caller = &runtime.Frame{
+			PC:       entry.Caller.PC,
+			File:     entry.Caller.File,
+			Line:     entry.Caller.Line,
+			Function: entry.Caller.Function,
+		}
+	}
+
+	return c.hw.WriteLogEntry(entry.LoggerName, logEntry)
+}
+
+// Sync is a no-op, the logger plugin uploads the log with the report
+func (c *Core) Sync() error {
+	return nil
+}
+
+// logrusLevel returns the logrus level of a zap level
+func logrusLevel(level zapcore.Level) logrus.Level {
+	switch level {
+	case zapcore.DebugLevel:
+		return logrus.DebugLevel
+	case zapcore.InfoLevel:
+		return logrus.InfoLevel
+	case zapcore.WarnLevel:
+		return logrus.WarnLevel
+	case zapcore.ErrorLevel, zapcore.DPanicLevel:
+		return logrus.ErrorLevel
+	case zapcore.PanicLevel:
+		return logrus.PanicLevel
+	case zapcore.FatalLevel:
+		return logrus.FatalLevel
+	default:
+		if level < zapcore.DebugLevel {
+			return logrus.TraceLevel
+		}
+		return logrus.ErrorLevel
+	}
+}
@@ -0,0 +1,80 @@
+package iopipezap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/iopipe/iopipe-go"
+	"github.com/iopipe/iopipe-go/iopipetest"
+	. "github.com/smartystreets/goconvey/convey"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// capture invokes fn with the invocation's log written as JSON to a buffer,
+// returning the logged entries
+func capture(fn func(ctx context.Context)) []map[string]interface{} {
+	var buf bytes.Buffer
+
+	h := iopipetest.NewHarness(iopipe.Config{})
+	h.Invoke(func(ctx context.Context) error {
+		context, _ := iopipe.FromContext(ctx)
+		context.IOpipe.Log.SetOutput(&buf)
+		context.IOpipe.Log.Formatter = iopipe.JSONFormatter{}
+		context.IOpipe.Log.ReportCaller = true
+
+		fn(ctx)
+		return nil
+	}, nil, iopipetest.InvokeConfig{AwsRequestID: "request-1"})
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var entry map[string]interface{}
+		json.Unmarshal([]byte(line), &entry)
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestCore(t *testing.T) {
+	Convey("Entries are written to the invocation's log", t, func() {
+		entries := capture(func(ctx context.Context) {
+			logger := zap.New(NewCore(ctx, nil), zap.AddCaller()).Named("checkout").With(zap.String("service", "cart"))
+			logger.Warn("checkout failed", zap.Int("items", 3), zap.Error(errors.New("out of stock")))
+			logger.Debug("not logged")
+		})
+
+		So(entries, ShouldHaveLength, 1)
+		So(entries[0]["name"], ShouldEqual, "checkout")
+		So(entries[0]["message"], ShouldEqual, "checkout failed")
+		So(entries[0]["severity"], ShouldEqual, "warning")
+		So(entries[0]["requestId"], ShouldEqual, "request-1")
+		So(entries[0]["fields"], ShouldResemble, map[string]interface{}{
+			"service": "cart",
+			"items":   float64(3),
+			"error":   "out of stock",
+		})
+
+		caller := entries[0]["caller"].(map[string]interface{})
+		So(caller["file"], ShouldEndWith, "iopipezap_test.go")
+	})
+
+	Convey("Entries logged without an invocation's context go to the fallback", t, func() {
+		fallback, logs := observer.New(zapcore.InfoLevel)
+
+		zap.New(NewCore(context.Background(), fallback)).Info("outside")
+		So(logs.Len(), ShouldEqual, 1)
+
+		So(func() { zap.New(NewCore(context.Background(), nil)).Info("dropped") }, ShouldNotPanic)
+	})
+}
@@ -0,0 +1,141 @@
+// Package iopipezerolog provides a zerolog writer that writes events to an
+// invocation's log, where the logger plugin captures them with the request ID
+// and fields as structured fields:
+//
+//	func Hello(ctx context.Context) error {
+//		logger := zerolog.New(iopipezerolog.NewWriter(ctx, os.Stderr)).With().Timestamp().Logger()
+//		logger.Info().Int("items", 3).Msg("checkout")
+//		return nil
+//	}
+//
+// The logger plugin passes the events through to stderr, so the writer
+// replaces zerolog's own output rather than being combined with it.
+package iopipezerolog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iopipe/iopipe-go"
+	"github.com/rs/zerolog"
+	"github.com/sirupsen/logrus"
+)
+
+// Writer is a zerolog.LevelWriter writing events to an invocation's log
+type Writer struct {
+	hw   *iopipe.HandlerWrapper
+	name string
+}
+
+// NewWriter returns a writer writing events to the log of the invocation of
+// ctx, or fallback if ctx isn't an invocation's context, which may be nil to
+// drop the events
+func NewWriter(ctx context.Context, fallback io.Writer) zerolog.LevelWriter {
+	context, ok := iopipe.FromContext(ctx)
+	if !ok || context.IOpipe == nil {
+		if fallback == nil {
+			fallback = io.Discard
+		}
+		return zerolog.MultiLevelWriter(fallback)
+	}
+
+	return &Writer{hw: context.IOpipe}
+}
+
+// WithName returns a writer recording name as the logger name
+func (w *Writer) WithName(name string) *Writer {
+	return &Writer{hw: w.hw, name: name}
+}
+
+// Write writes an event without a level to the invocation's log
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.WriteLevel(zerolog.NoLevel, p)
+}
+
+// WriteLevel writes an event to the invocation's log
+func (w *Writer) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	var event map[string]interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(p))
+	decoder.UseNumber()
+	if err := decoder.Decode(&event); err != nil {
+		return 0, err
+	}
+
+	entry := &logrus.Entry{
+		Time:  time.Now(),
+		Level: logrusLevel(level, event[zerolog.LevelFieldName]),
+	}
+
+	if message, ok := event[zerolog.MessageFieldName].(string); ok {
+		entry.Message = message
+	}
+	delete(event, zerolog.MessageFieldName)
+	delete(event, zerolog.LevelFieldName)
+
+	if timestamp, ok := event[zerolog.TimestampFieldName].(string); ok {
+		if t, err := time.Parse(zerolog.TimeFieldFormat, timestamp); err == nil {
+			entry.Time = t
+		}
+		delete(event, zerolog.TimestampFieldName)
+	}
+
+	if caller, ok := event[zerolog.CallerFieldName].(string); ok {
+		entry.Caller = parseCaller(caller)
+		delete(event, zerolog.CallerFieldName)
+	}
+
+	entry.Data = logrus.Fields(event)
+
+	if err := w.hw.WriteLogEntry(w.name, entry); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// parseCaller parses a file:line caller
+func parseCaller(caller string) *runtime.Frame {
+	index := strings.LastIndex(caller, ":")
+	if index < 0 {
+		return &runtime.Frame{File: caller}
+	}
+
+	line, _ := strconv.Atoi(caller[index+1:])
+	return &runtime.Frame{File: caller[:index], Line: line}
+}
+
+// logrusLevel returns the logrus level of a zerolog level, using the level
+// field of events written without one
+func logrusLevel(level zerolog.Level, field interface{}) logrus.Level {
+	if level == zerolog.NoLevel {
+		if name, ok := field.(string); ok {
+			if parsed, err := zerolog.ParseLevel(name); err == nil {
+				level = parsed
+			}
+		}
+	}
+
+	switch level {
+	case zerolog.TraceLevel:
+		return logrus.TraceLevel
+	case zerolog.DebugLevel:
+		return logrus.DebugLevel
+	case zerolog.WarnLevel:
+		return logrus.WarnLevel
+	case zerolog.ErrorLevel:
+		return logrus.ErrorLevel
+	case zerolog.FatalLevel:
+		return logrus.FatalLevel
+	case zerolog.PanicLevel:
+		return logrus.PanicLevel
+	default:
+		return logrus.InfoLevel
+	}
+}
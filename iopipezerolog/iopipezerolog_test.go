@@ -0,0 +1,84 @@
+package iopipezerolog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/iopipe/iopipe-go"
+	"github.com/iopipe/iopipe-go/iopipetest"
+	"github.com/rs/zerolog"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// capture invokes fn with the invocation's log written as JSON to a buffer,
+// returning the logged entries
+func capture(fn func(ctx context.Context)) []map[string]interface{} {
+	var buf bytes.Buffer
+
+	h := iopipetest.NewHarness(iopipe.Config{})
+	h.Invoke(func(ctx context.Context) error {
+		context, _ := iopipe.FromContext(ctx)
+		context.IOpipe.Log.SetOutput(&buf)
+		context.IOpipe.Log.Formatter = iopipe.JSONFormatter{}
+		context.IOpipe.Log.ReportCaller = true
+
+		fn(ctx)
+		return nil
+	}, nil, iopipetest.InvokeConfig{AwsRequestID: "request-1"})
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var entry map[string]interface{}
+		json.Unmarshal([]byte(line), &entry)
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func TestWriter(t *testing.T) {
+	Convey("Events are written to the invocation's log", t, func() {
+		entries := capture(func(ctx context.Context) {
+			writer := NewWriter(ctx, nil).(*Writer).WithName("checkout")
+			logger := zerolog.New(writer).With().Timestamp().Caller().Str("service", "cart").Logger()
+			logger.Error().Int("items", 3).Err(errors.New("out of stock")).Msg("checkout failed")
+			logger.Debug().Msg("not logged")
+		})
+
+		So(entries, ShouldHaveLength, 1)
+		So(entries[0]["name"], ShouldEqual, "checkout")
+		So(entries[0]["message"], ShouldEqual, "checkout failed")
+		So(entries[0]["severity"], ShouldEqual, "error")
+		So(entries[0]["requestId"], ShouldEqual, "request-1")
+		So(entries[0]["fields"], ShouldResemble, map[string]interface{}{
+			"service": "cart",
+			"items":   float64(3),
+			"error":   "out of stock",
+		})
+
+		caller := entries[0]["caller"].(map[string]interface{})
+		So(caller["file"], ShouldEndWith, "iopipezerolog_test.go")
+		So(caller["line"], ShouldBeGreaterThan, 0)
+	})
+
+	Convey("Events logged without an invocation's context go to the fallback", t, func() {
+		var buf bytes.Buffer
+
+		logger := zerolog.New(NewWriter(context.Background(), &buf))
+		logger.Info().Msg("outside")
+		So(buf.String(), ShouldContainSubstring, `"message":"outside"`)
+
+		So(func() {
+			logger := zerolog.New(NewWriter(context.Background(), nil))
+			logger.Info().Msg("dropped")
+		}, ShouldNotPanic)
+	})
+}
@@ -0,0 +1,285 @@
+package iopipe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxLeakStacks is the number of leaked goroutine creation sites attached to
+// the report
+const maxLeakStacks = 5
+
+// defaultLeakDetectorWait gives goroutines finishing work the handler didn't
+// wait for, such as a deferred flush or a response body drained in the
+// background, the time to exit. It only delays invocations leaving goroutines
+// running, the detector stops waiting once they exited.
+var defaultLeakDetectorWait = 100 * time.Millisecond
+
+// agentGoroutines are the functions the agent starts goroutines with during
+// an invocation, including the plugin hooks running alongside the detector
+var agentGoroutines = []string{
+	"github.com/iopipe/iopipe-go.(*HandlerWrapper).handleTimeout",
+	"github.com/iopipe/iopipe-go.runPluginHook",
+}
+
+// LeakDetectorPluginConfig is the leak detector plugin configuration
+type LeakDetectorPluginConfig struct {
+	// IgnoreFunctions are prefixes of the functions goroutines are started
+	// with that are never reported as leaked, such as
+	// "net/http.(*persistConn)" for HTTP keep-alive connections
+	IgnoreFunctions []string
+
+	// Wait is how long to wait for goroutines to exit before reporting them
+	// as leaked, defaults to 100ms. Goroutines exiting later are reported
+	// too, raise it for handlers leaving slower work behind.
+	Wait *time.Duration
+}
+
+type leakDetectorPlugin struct {
+	LeakDetectorPluginConfig
+}
+
+// goroutine is a goroutine parsed from a stack dump
+type goroutine struct {
+	id        string
+	entry     string
+	createdBy string
+	stack     string
+}
+
+func (p *leakDetectorPlugin) Meta() *PluginMeta {
+	return &PluginMeta{
+		Name:     "@iopipe/leak-detector",
+		Version:  "0.1.0",
+		Homepage: "https://github.com/iopipe/iopipe-go#leak-detector-plugin",
+		Enabled:  p.Enabled(),
+		Uploads:  []string{},
+	}
+}
+
+func (p *leakDetectorPlugin) Enabled() bool {
+	return true
+}
+
+func (p *leakDetectorPlugin) PreInvoke(ctx context.Context, payload interface{}) {
+	context, ok := FromContext(ctx)
+	if !ok {
+		return
+	}
+
+	ids := map[string]bool{}
+	for _, g := range goroutines() {
+		ids[g.id] = true
+	}
+
+	context.IOpipe.SetPluginState(p, ids)
+}
+
+func (p *leakDetectorPlugin) PostInvoke(ctx context.Context, payload interface{}) {
+	context, ok := FromContext(ctx)
+	if !ok {
+		return
+	}
+
+	before, ok := context.IOpipe.PluginState(p).(map[string]bool)
+	if !ok {
+		return
+	}
+
+	wait := defaultLeakDetectorWait
+	if p.Wait != nil {
+		wait = *p.Wait
+	}
+
+	// Give goroutines that are about to exit the chance to do so
+	var (
+		deadline = time.Now().Add(wait)
+		interval = time.Millisecond
+		leaked   = p.leaked(before)
+	)
+
+	for len(leaked) > 0 && time.Now().Before(deadline) {
+		time.Sleep(interval)
+		if interval < 5*time.Millisecond {
+			interval *= 2
+		}
+
+		leaked = p.leaked(before)
+	}
+
+	hw := context.IOpipe
+	hw.Metric("@iopipe/goroutine-leak.count", len(leaked))
+
+	if len(leaked) == 0 {
+		return
+	}
+
+	hw.Label("@iopipe/goroutine-leak")
+
+	for index, site := range leakSites(leaked) {
+		if index == maxLeakStacks {
+			break
+		}
+
+		hw.Metric(fmt.Sprintf("@iopipe/goroutine-leak.stack.%d", index), site)
+	}
+}
+
+// leaked returns the goroutines that weren't running before the invocation,
+// ignoring the agent's own goroutines
+func (p *leakDetectorPlugin) leaked(before map[string]bool) []*goroutine {
+	var leaked []*goroutine
+
+	for _, g := range goroutines() {
+		if before[g.id] || p.isIgnored(g) {
+			continue
+		}
+
+		leaked = append(leaked, g)
+	}
+
+	return leaked
+}
+
+// isIgnored returns true if g is started with an agent or ignored function
+func (p *leakDetectorPlugin) isIgnored(g *goroutine) bool {
+	for _, prefix := range agentGoroutines {
+		if strings.HasPrefix(g.entry, prefix) {
+			return true
+		}
+	}
+
+	for _, prefix := range p.IgnoreFunctions {
+		if strings.HasPrefix(g.entry, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LeakDetectorPlugin loads the leak detector plugin, which labels invocations
+// that leave goroutines running and attaches where they were created. It
+// dumps every goroutine's stack twice per invocation, and when invocations
+// run concurrently the goroutines of one can be reported by another.
+func LeakDetectorPlugin(config LeakDetectorPluginConfig) PluginInstantiator {
+	return func() Plugin {
+		return &leakDetectorPlugin{
+			LeakDetectorPluginConfig: config,
+		}
+	}
+}
+
+func init() {
+	RegisterPlugin("@iopipe/leak-detector", func(raw json.RawMessage) (Plugin, error) {
+		var config LeakDetectorPluginConfig
+		if err := DecodePluginConfig(raw, &config); err != nil {
+			return nil, err
+		}
+
+		return LeakDetectorPlugin(config)(), nil
+	})
+}
+
+// leakSites groups leaked goroutines by where they were created, returning
+// a description of each site with the stack of one of its goroutines, the
+// sites with the most goroutines first
+func leakSites(leaked []*goroutine) []string {
+	var (
+		counts  = map[string]int{}
+		example = map[string]*goroutine{}
+		sites   []string
+	)
+
+	for _, g := range leaked {
+		if counts[g.createdBy] == 0 {
+			sites = append(sites, g.createdBy)
+			example[g.createdBy] = g
+		}
+		counts[g.createdBy]++
+	}
+
+	sort.SliceStable(sites, func(i, j int) bool {
+		return counts[sites[i]] > counts[sites[j]]
+	})
+
+	descriptions := make([]string, len(sites))
+	for index, site := range sites {
+		descriptions[index] = fmt.Sprintf("%d goroutine(s) %s\n%s", counts[site], site, example[site].stack)
+	}
+
+	return descriptions
+}
+
+// goroutines returns the running goroutines
+func goroutines() []*goroutine {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	var parsed []*goroutine
+	for _, block := range bytes.Split(buf, []byte("\n\n")) {
+		if g := parseGoroutine(string(block)); g != nil {
+			parsed = append(parsed, g)
+		}
+	}
+
+	return parsed
+}
+
+// parseGoroutine parses a goroutine from its block of a stack dump
+func parseGoroutine(block string) *goroutine {
+	lines := strings.Split(strings.TrimSpace(block), "\n")
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "goroutine ") {
+		return nil
+	}
+
+	header := strings.Fields(lines[0])
+	if len(header) < 2 {
+		return nil
+	}
+
+	g := &goroutine{id: header[1]}
+
+	var frames []string
+	for index := 1; index < len(lines); index++ {
+		line := lines[index]
+
+		if strings.HasPrefix(line, "created by ") {
+			g.createdBy = line
+			if index+1 < len(lines) {
+				g.createdBy += " at " + strings.TrimSpace(lines[index+1])
+			}
+			break
+		}
+
+		frames = append(frames, line)
+
+		// Function lines alternate with their file and line
+		if !strings.HasPrefix(line, "\t") && !strings.HasPrefix(line, "...") {
+			g.entry = line
+			if i := strings.LastIndex(line, "("); i > 0 {
+				g.entry = line[:i]
+			}
+		}
+	}
+
+	if g.createdBy == "" {
+		g.createdBy = "created by unknown"
+	}
+	g.stack = strings.Join(frames, "\n")
+
+	return g
+}
@@ -0,0 +1,110 @@
+package iopipe
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func runWithLeakDetector(config LeakDetectorPluginConfig, fn func(context.Context) error) *Report {
+	var reported *Report
+
+	token := "token"
+	a := NewAgent(Config{
+		Token: &token,
+		Plugins: []PluginInstantiator{
+			LeakDetectorPlugin(config),
+		},
+		Reporter: func(report *Report) error {
+			reported = report
+			return nil
+		},
+	})
+
+	a.Run(context.Background(), "job", fn)
+
+	return reported
+}
+
+func leakyWorker(done chan struct{}) {
+	<-done
+}
+
+func TestLeakDetectorPlugin_LeakDetectorPlugin(t *testing.T) {
+	Convey("Leak detector plugin should report goroutines left running", t, func() {
+		done := make(chan struct{})
+		defer close(done)
+
+		report := runWithLeakDetector(LeakDetectorPluginConfig{}, func(ctx context.Context) error {
+			for i := 0; i < 3; i++ {
+				go leakyWorker(done)
+			}
+			return nil
+		})
+
+		So(report.Labels, ShouldContain, "@iopipe/goroutine-leak")
+		So(metricValue(report, "@iopipe/goroutine-leak.count"), ShouldEqual, 3)
+
+		stack := metricValue(report, "@iopipe/goroutine-leak.stack.0").(string)
+		So(stack, ShouldStartWith, "3 goroutine(s) created by github.com/iopipe/iopipe-go.TestLeakDetectorPlugin_LeakDetectorPlugin")
+		So(stack, ShouldContainSubstring, "leak_plugin_test.go")
+		So(stack, ShouldContainSubstring, "github.com/iopipe/iopipe-go.leakyWorker")
+	})
+
+	Convey("Leak detector plugin should wait for goroutines about to exit", t, func() {
+		wait := time.Second
+
+		report := runWithLeakDetector(LeakDetectorPluginConfig{Wait: &wait}, func(ctx context.Context) error {
+			go time.Sleep(5 * time.Millisecond)
+			return nil
+		})
+
+		So(report.Labels, ShouldNotContain, "@iopipe/goroutine-leak")
+		So(metricValue(report, "@iopipe/goroutine-leak.count"), ShouldEqual, 0)
+	})
+
+	Convey("Leak detector plugin should wait for goroutines exiting shortly after the handler by default", t, func() {
+		report := runWithLeakDetector(LeakDetectorPluginConfig{}, func(ctx context.Context) error {
+			go time.Sleep(30 * time.Millisecond)
+			return nil
+		})
+
+		So(report.Labels, ShouldNotContain, "@iopipe/goroutine-leak")
+		So(metricValue(report, "@iopipe/goroutine-leak.count"), ShouldEqual, 0)
+	})
+
+	Convey("Leak detector plugin should ignore configured functions", t, func() {
+		done := make(chan struct{})
+		defer close(done)
+
+		report := runWithLeakDetector(LeakDetectorPluginConfig{
+			IgnoreFunctions: []string{"github.com/iopipe/iopipe-go.leakyWorker"},
+		}, func(ctx context.Context) error {
+			go leakyWorker(done)
+			return nil
+		})
+
+		So(report.Labels, ShouldNotContain, "@iopipe/goroutine-leak")
+	})
+}
+
+func TestLeakDetectorPlugin_parseGoroutine(t *testing.T) {
+	Convey("Goroutines should be parsed from stack dumps", t, func() {
+		g := parseGoroutine(`goroutine 18 [chan receive]:
+main.worker(0xc000010000)
+	/src/main.go:10 +0x25
+main.(*Server).run.func1()
+	/src/main.go:20 +0x30
+created by main.(*Server).run in goroutine 1
+	/src/main.go:18 +0x45`)
+
+		So(g.id, ShouldEqual, "18")
+		So(g.entry, ShouldEqual, "main.(*Server).run.func1")
+		So(g.createdBy, ShouldEqual, "created by main.(*Server).run in goroutine 1 at /src/main.go:18 +0x45")
+		So(g.stack, ShouldStartWith, "main.worker(0xc000010000)")
+
+		So(parseGoroutine("not a goroutine"), ShouldBeNil)
+	})
+}
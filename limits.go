@@ -0,0 +1,271 @@
+package iopipe
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ReportLimits bounds the size of reports, zero values use the defaults
+type ReportLimits struct {
+	// MaxMetrics is the maximum number of custom metrics, defaults to 1000
+	MaxMetrics int
+
+	// MaxStringLength is the maximum length in characters of string metric
+	// values and error messages, defaults to 1024
+	MaxStringLength int
+
+	// MaxLabels is the maximum number of labels, defaults to 100
+	MaxLabels int
+
+	// MaxStackFrames is the maximum number of error stack frames, defaults to
+	// 32. At most 4096 frames are captured.
+	MaxStackFrames int
+
+	// MaxReportSize is the maximum size in bytes of the serialized report,
+	// defaults to 256 KiB
+	MaxReportSize int
+}
+
+var defaultReportLimits = ReportLimits{
+	MaxMetrics:      1000,
+	MaxStringLength: 1024,
+	MaxLabels:       100,
+	MaxStackFrames:  defaultErrorFrameCount,
+	MaxReportSize:   256 * 1024,
+}
+
+// truncatedReserve is the space kept free under MaxReportSize for the
+// truncation label and counters
+const truncatedReserve = 512
+
+// withDefaults returns the limits with zero values replaced by the defaults
+func (l *ReportLimits) withDefaults() ReportLimits {
+	limits := defaultReportLimits
+	if l == nil {
+		return limits
+	}
+
+	if l.MaxMetrics > 0 {
+		limits.MaxMetrics = l.MaxMetrics
+	}
+	if l.MaxStringLength > 0 {
+		limits.MaxStringLength = l.MaxStringLength
+	}
+	if l.MaxLabels > 0 {
+		limits.MaxLabels = l.MaxLabels
+	}
+	if l.MaxStackFrames > 0 {
+		limits.MaxStackFrames = l.MaxStackFrames
+	}
+	if l.MaxReportSize > 0 {
+		limits.MaxReportSize = l.MaxReportSize
+	}
+
+	return limits
+}
+
+// truncation counts what was dropped from a report to fit its limits
+type truncation struct {
+	metrics int
+	strings int
+	labels  int
+	frames  int
+	size    int
+}
+
+// truncate enforces the agent's report limits, recording what was dropped in
+// the @iopipe/truncated label and counters
+func (r *Report) truncate() {
+	var (
+		limits = r.agent.reportLimits()
+		t      truncation
+	)
+
+	if invocationError, ok := r.Errors.(*InvocationError); ok && invocationError != nil {
+		message, truncated := truncateString(invocationError.Message, limits.MaxStringLength)
+		if truncated {
+			invocationError.Message = message
+			t.strings++
+		}
+
+		t.frames += truncateStack(invocationError, limits.MaxStackFrames)
+	}
+
+	for index, metric := range r.CustomMetrics {
+		if s, ok := metric.S.(string); ok {
+			s, truncated := truncateString(s, limits.MaxStringLength)
+			if truncated {
+				r.CustomMetrics[index].S = s
+				t.strings++
+			}
+		}
+	}
+
+	if len(r.CustomMetrics) > limits.MaxMetrics {
+		t.metrics += len(r.CustomMetrics) - limits.MaxMetrics
+		r.CustomMetrics = r.CustomMetrics[:limits.MaxMetrics]
+	}
+
+	// Labels are collected from a map, so sort them to drop the same labels
+	// every time, keeping the agent's own labels first
+	sort.SliceStable(r.Labels, func(i, j int) bool {
+		iopipeI := strings.HasPrefix(r.Labels[i], "@iopipe/")
+		iopipeJ := strings.HasPrefix(r.Labels[j], "@iopipe/")
+		if iopipeI != iopipeJ {
+			return iopipeI
+		}
+		return r.Labels[i] < r.Labels[j]
+	})
+
+	if len(r.Labels) > limits.MaxLabels {
+		t.labels += len(r.Labels) - limits.MaxLabels
+		r.Labels = r.Labels[:limits.MaxLabels]
+	}
+
+	r.truncateSize(limits.MaxReportSize-truncatedReserve, &t)
+
+	if t == (truncation{}) {
+		return
+	}
+
+	if len(r.Labels) >= limits.MaxLabels && len(r.Labels) > 0 {
+		r.Labels = r.Labels[:len(r.Labels)-1]
+		t.labels++
+	}
+	r.Labels = append(r.Labels, "@iopipe/truncated")
+
+	// The counters count towards MaxMetrics too, dropping metrics to make
+	// room for them can add the metrics counter
+	for {
+		excess := len(r.CustomMetrics) + len(t.counters()) - limits.MaxMetrics
+		if excess <= 0 || len(r.CustomMetrics) == 0 {
+			break
+		}
+
+		if excess > len(r.CustomMetrics) {
+			excess = len(r.CustomMetrics)
+		}
+
+		t.metrics += excess
+		r.CustomMetrics = r.CustomMetrics[:len(r.CustomMetrics)-excess]
+	}
+
+	counters := t.counters()
+	if len(counters) > limits.MaxMetrics {
+		counters = counters[:limits.MaxMetrics]
+	}
+	r.CustomMetrics = append(r.CustomMetrics, counters...)
+}
+
+// counters returns the custom metrics counting what was dropped
+func (t *truncation) counters() []CustomMetric {
+	var metrics []CustomMetric
+
+	counters := []struct {
+		name  string
+		count int
+	}{
+		{"@iopipe/truncated.metrics", t.metrics},
+		{"@iopipe/truncated.strings", t.strings},
+		{"@iopipe/truncated.labels", t.labels},
+		{"@iopipe/truncated.frames", t.frames},
+		{"@iopipe/truncated.original-size-bytes", t.size},
+	}
+
+	for _, counter := range counters {
+		if counter.count > 0 {
+			metrics = append(metrics, CustomMetric{Name: counter.name, N: int64(counter.count)})
+		}
+	}
+
+	return metrics
+}
+
+// truncateSize drops custom metrics from the end, then the stack, then
+// labels from the end until the serialized report fits in maxSize bytes
+func (r *Report) truncateSize(maxSize int, t *truncation) {
+	size := r.size()
+	if size <= maxSize {
+		return
+	}
+
+	t.size = size
+
+	for size > maxSize && len(r.CustomMetrics) > 0 {
+		drop := len(r.CustomMetrics) / 4
+		if drop == 0 {
+			drop = 1
+		}
+
+		r.CustomMetrics = r.CustomMetrics[:len(r.CustomMetrics)-drop]
+		t.metrics += drop
+		size = r.size()
+	}
+
+	if invocationError, ok := r.Errors.(*InvocationError); ok && invocationError != nil && size > maxSize {
+		t.frames += truncateStack(invocationError, 0)
+		size = r.size()
+	}
+
+	for size > maxSize && len(r.Labels) > 0 {
+		drop := len(r.Labels) / 4
+		if drop == 0 {
+			drop = 1
+		}
+
+		r.Labels = r.Labels[:len(r.Labels)-drop]
+		t.labels += drop
+		size = r.size()
+	}
+}
+
+// size returns the size in bytes of the serialized report
+func (r *Report) size() int {
+	reportJSONBytes, err := json.Marshal(r)
+	if err != nil {
+		return 0
+	}
+
+	return len(reportJSONBytes)
+}
+
+// reportLimits returns the agent's report limits
+func (a *Agent) reportLimits() ReportLimits {
+	if a == nil || a.Config == nil {
+		return defaultReportLimits
+	}
+
+	return a.Limits.withDefaults()
+}
+
+// truncateString truncates s to max characters
+func truncateString(s string, max int) (string, bool) {
+	if utf8.RuneCountInString(s) <= max {
+		return s, false
+	}
+
+	runes := []rune(s)
+	return string(runes[:max]), true
+}
+
+// truncateStack drops all but the first max stack frames, returning the
+// number of frames dropped
+func truncateStack(invocationError *InvocationError, max int) int {
+	if invocationError.Stack == "" {
+		return 0
+	}
+
+	frames := strings.Split(invocationError.Stack, "\n")
+	if len(frames) <= max {
+		return 0
+	}
+
+	if len(invocationError.StackTrace) > max {
+		invocationError.StackTrace = invocationError.StackTrace[:max]
+	}
+	invocationError.Stack = strings.Join(frames[:max], "\n")
+
+	return len(frames) - max
+}
@@ -0,0 +1,174 @@
+package iopipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func invokeWithLimits(limits *ReportLimits, handler interface{}) *Report {
+	var reported *Report
+
+	a := NewAgent(Config{
+		Limits: limits,
+		Reporter: func(report *Report) error {
+			reported = report
+			return nil
+		},
+	})
+
+	NewHandlerWrapper(handler, a).Invoke(context.Background(), nil)
+
+	return reported
+}
+
+func metricValue(report *Report, name string) interface{} {
+	for _, metric := range report.CustomMetrics {
+		if metric.Name == name {
+			if metric.N != nil {
+				return metric.N
+			}
+			return metric.S
+		}
+	}
+
+	return nil
+}
+
+func TestLimits_truncate(t *testing.T) {
+	Convey("Reports within the limits are not truncated", t, func() {
+		r := invokeWithLimits(nil, func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+			context.IOpipe.Metric("foo", "bar")
+			context.IOpipe.Label("baz")
+			return nil
+		})
+
+		So(r.Labels, ShouldNotContain, "@iopipe/truncated")
+		So(len(r.CustomMetrics), ShouldEqual, 1)
+	})
+
+	Convey("String metric values are limited to 1024 characters by default", t, func() {
+		r := invokeWithLimits(nil, func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+			context.IOpipe.Metric("long", strings.Repeat("é", 2000))
+			return nil
+		})
+
+		So(len([]rune(metricValue(r, "long").(string))), ShouldEqual, 1024)
+		So(metricValue(r, "@iopipe/truncated.strings"), ShouldEqual, int64(1))
+		So(r.Labels, ShouldContain, "@iopipe/truncated")
+	})
+
+	Convey("Metrics beyond the limit are dropped in order", t, func() {
+		r := invokeWithLimits(&ReportLimits{MaxMetrics: 3}, func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+			for i := 0; i < 5; i++ {
+				context.IOpipe.Metric(fmt.Sprintf("metric-%d", i), i)
+			}
+			return nil
+		})
+
+		// Room is made for the counter
+		So(r.CustomMetrics, ShouldHaveLength, 3)
+		So(metricValue(r, "metric-1"), ShouldEqual, int64(1))
+		So(metricValue(r, "metric-2"), ShouldBeNil)
+		So(metricValue(r, "@iopipe/truncated.metrics"), ShouldEqual, int64(3))
+	})
+
+	Convey("Labels beyond the limit are dropped deterministically", t, func() {
+		handler := func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+			for _, label := range []string{"e", "d", "c", "b", "a"} {
+				context.IOpipe.Label(label)
+			}
+			return nil
+		}
+
+		for i := 0; i < 5; i++ {
+			r := invokeWithLimits(&ReportLimits{MaxLabels: 4}, handler)
+
+			So(r.Labels, ShouldResemble, []string{"a", "b", "c", "@iopipe/truncated"})
+			So(metricValue(r, "@iopipe/truncated.labels"), ShouldEqual, int64(2))
+		}
+	})
+
+	Convey("Truncated reports stay within the limits with their label and counters", t, func() {
+		r := invokeWithLimits(&ReportLimits{MaxMetrics: 2, MaxLabels: 1, MaxStringLength: 3}, func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+			context.IOpipe.Label("a")
+			context.IOpipe.Label("b")
+			for i := 0; i < 5; i++ {
+				context.IOpipe.Metric(fmt.Sprintf("metric-%d", i), "long value")
+			}
+			return nil
+		})
+
+		So(r.Labels, ShouldResemble, []string{"@iopipe/truncated"})
+		So(r.CustomMetrics, ShouldHaveLength, 2)
+		So(metricValue(r, "@iopipe/truncated.metrics"), ShouldEqual, int64(5))
+		So(metricValue(r, "@iopipe/truncated.strings"), ShouldEqual, int64(5))
+	})
+
+	Convey("Error messages and stacks are truncated", t, func() {
+		r := invokeWithLimits(&ReportLimits{MaxStringLength: 5, MaxStackFrames: 1}, func() error {
+			return errors.New("whoops, something went wrong")
+		})
+
+		invocationError := r.Errors.(*InvocationError)
+		So(invocationError.Message, ShouldEqual, "whoop")
+		So(strings.Count(invocationError.Stack, "\n"), ShouldEqual, 0)
+		So(metricValue(r, "@iopipe/truncated.frames"), ShouldBeGreaterThan, 0)
+	})
+
+	Convey("Stacks deeper than the default keep up to MaxStackFrames frames", t, func() {
+		var recurse func(depth int)
+		recurse = func(depth int) {
+			if depth == 0 {
+				panic("whoops")
+			}
+			recurse(depth - 1)
+		}
+
+		var r *Report
+		a := NewAgent(Config{
+			Limits: &ReportLimits{MaxStackFrames: 64},
+			Reporter: func(report *Report) error {
+				r = report
+				return nil
+			},
+		})
+
+		So(func() {
+			NewHandlerWrapper(func() {
+				recurse(100)
+			}, a).Invoke(context.Background(), nil)
+		}, ShouldPanicWith, "whoops")
+
+		invocationError := r.Errors.(*InvocationError)
+		So(invocationError.StackTrace, ShouldHaveLength, 64)
+		So(strings.Count(invocationError.Stack, "\n"), ShouldEqual, 63)
+		So(metricValue(r, "@iopipe/truncated.frames"), ShouldBeGreaterThan, 0)
+	})
+
+	Convey("Reports over the size limit drop metrics until they fit", t, func() {
+		r := invokeWithLimits(&ReportLimits{MaxReportSize: 8 * 1024}, func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+			for i := 0; i < 100; i++ {
+				context.IOpipe.Metric(fmt.Sprintf("metric-%d", i), strings.Repeat("x", 100))
+			}
+			return nil
+		})
+
+		So(r.size(), ShouldBeLessThanOrEqualTo, 8*1024)
+		So(metricValue(r, "metric-0"), ShouldNotBeNil)
+		So(metricValue(r, "metric-99"), ShouldBeNil)
+		So(metricValue(r, "@iopipe/truncated.original-size-bytes"), ShouldBeGreaterThan, 8*1024)
+		So(r.Labels, ShouldContain, "@iopipe/truncated")
+		So(r.Validate(), ShouldBeNil)
+	})
+}
@@ -10,3 +10,51 @@ func NewLogger() *logrus.Logger {
 	logger.SetLevel(logrus.InfoLevel)
 	return logger
 }
+
+// newInvocationLogger returns a logger for a single invocation configured like
+// parent, so plugins can redirect its output without affecting other invocations
+func newInvocationLogger(parent *logrus.Logger) *logrus.Logger {
+	if parent == nil {
+		return NewLogger()
+	}
+
+	return &logrus.Logger{
+		Out:          parent.Out,
+		Hooks:        parent.Hooks,
+		Formatter:    parent.Formatter,
+		ReportCaller: parent.ReportCaller,
+		Level:        parent.GetLevel(),
+		ExitFunc:     parent.ExitFunc,
+	}
+}
+
+// WriteLogEntry writes an entry logged with another logging library to the
+// invocation's log, so the logger plugin captures it like its own. name
+// overrides the logger name if set, and entries below the log level are
+// dropped.
+func (hw *HandlerWrapper) WriteLogEntry(name string, entry *logrus.Entry) error {
+	if hw == nil || hw.Log == nil || !hw.Log.IsLevelEnabled(entry.Level) {
+		return nil
+	}
+
+	entry.Logger = hw.Log
+
+	formatter := hw.Log.Formatter
+	if jsonFormatter, ok := formatter.(JSONFormatter); ok {
+		if name != "" {
+			jsonFormatter.Name = name
+		}
+		if jsonFormatter.RequestID == "" && hw.lambdaContext != nil {
+			jsonFormatter.RequestID = hw.lambdaContext.AwsRequestID
+		}
+		formatter = jsonFormatter
+	}
+
+	serialized, err := formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = hw.Log.Out.Write(serialized)
+	return err
+}
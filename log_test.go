@@ -0,0 +1,45 @@
+package iopipe
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	log "github.com/sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLog_WriteLogEntry(t *testing.T) {
+	Convey("Entries from other logging libraries are written to the invocation's log", t, func() {
+		var buf bytes.Buffer
+
+		token := "token"
+		a := NewAgent(Config{
+			Token: &token,
+			Reporter: func(report *Report) error {
+				return nil
+			},
+		})
+
+		ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "request-1"})
+		NewHandlerWrapper(func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+			context.IOpipe.Log.SetOutput(&buf)
+			context.IOpipe.Log.Formatter = JSONFormatter{Name: "api"}
+
+			context.IOpipe.WriteLogEntry("", &log.Entry{Level: log.InfoLevel, Message: "hello", Data: log.Fields{"a": 1}})
+			context.IOpipe.WriteLogEntry("worker", &log.Entry{Level: log.WarnLevel, Message: "named"})
+			context.IOpipe.WriteLogEntry("", &log.Entry{Level: log.DebugLevel, Message: "not logged"})
+			return nil
+		}, a).Invoke(ctx, nil)
+
+		So(buf.String(), ShouldContainSubstring, `"name":"api","severity":"info","message":"hello","requestId":"request-1","fields":{"a":1}`)
+		So(buf.String(), ShouldContainSubstring, `"name":"worker","severity":"warning","message":"named"`)
+		So(buf.String(), ShouldNotContainSubstring, "not logged")
+	})
+
+	Convey("Writing entries without a handler wrapper does nothing", t, func() {
+		So((*HandlerWrapper)(nil).WriteLogEntry("", &log.Entry{}), ShouldBeNil)
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 
@@ -16,12 +17,61 @@ import (
 )
 
 // LoggerPluginConfig is the logger plugin configuration
-type LoggerPluginConfig struct{}
+type LoggerPluginConfig struct {
+	// Name is the logger name recorded in log entries, defaults to root
+	Name string
+
+	// Level is the minimum level of the logs captured, defaults to the
+	// agent's log level. If not supplied, the environment variable
+	// IOPIPE_LOG_LEVEL will be used if present.
+	Level *log.Level
+
+	// Caller records the file, line and function that logged each entry,
+	// defaults to true
+	Caller *bool
+
+	// Debug captures debug logs for the invocations it returns true for,
+	// regardless of Level
+	Debug func(ctx context.Context, payload interface{}) bool
+
+	// CaptureOutput also captures what the process writes to stdout and
+	// stderr, and the standard library log output, defaults to false. If not
+	// supplied, the environment variable IOPIPE_LOG_CAPTURE will be used if
+	// present.
+	CaptureOutput *bool
+
+	// MaxBytes caps the size of the logs captured per invocation, defaults
+	// to no limit. If not supplied, the environment variable
+	// IOPIPE_LOG_MAX_BYTES will be used if present.
+	MaxBytes *int
+
+	// Mode is which logs are kept once MaxBytes is exceeded, defaults to
+	// LogBufferHead. If not supplied, the environment variable IOPIPE_LOG_MODE
+	// will be used if present.
+	Mode LogBufferMode
+}
+
+// LogBufferMode is which logs of an invocation the logger plugin keeps
+type LogBufferMode string
+
+const (
+	// LogBufferHead keeps the first logs, dropping those written once the
+	// buffer is full
+	LogBufferHead LogBufferMode = "head"
+
+	// LogBufferTail keeps the latest logs, dropping the oldest ones to make
+	// room for them
+	LogBufferTail LogBufferMode = "tail"
+
+	// LogBufferErrorsOnly keeps the latest logs like LogBufferTail, but only
+	// uploads them if the invocation errored or timed out
+	LogBufferErrorsOnly LogBufferMode = "errors-only"
+)
 
 type loggerPlugin struct {
 	LoggerPluginConfig
-	proxyWriter *ProxyWriter
-	uploads     []string
+
+	capture *outputCapture
 }
 
 func (p *loggerPlugin) Meta() *PluginMeta {
@@ -30,7 +80,7 @@ func (p *loggerPlugin) Meta() *PluginMeta {
 		Version:  "0.1.0",
 		Homepage: "https://github.com/iopipe/iopipe-go#logger-plugin",
 		Enabled:  p.Enabled(),
-		Uploads:  p.uploads,
+		Uploads:  []string{},
 	}
 }
 
@@ -45,99 +95,303 @@ func (p *loggerPlugin) PostSetup(agent *Agent) {
 		agent.log = NewLogger()
 	}
 
-	agent.log.Formatter = JSONFormatter{}
-	agent.log.Out = p.proxyWriter
+	agent.log.Formatter = JSONFormatter{Name: p.Name}
+
+	if p.Level == nil {
+		if level, err := log.ParseLevel(os.Getenv("IOPIPE_LOG_LEVEL")); err == nil {
+			p.Level = &level
+		}
+	}
+
+	if p.MaxBytes == nil {
+		if maxBytes, err := strconv.Atoi(os.Getenv("IOPIPE_LOG_MAX_BYTES")); err == nil {
+			p.MaxBytes = &maxBytes
+		}
+	}
+
+	if p.Mode == "" {
+		p.Mode = LogBufferMode(os.Getenv("IOPIPE_LOG_MODE"))
+	}
+
+	switch p.Mode {
+	case LogBufferHead, LogBufferTail, LogBufferErrorsOnly:
+	case "":
+		p.Mode = LogBufferHead
+	default:
+		agent.log.Warn(fmt.Sprintf("Unknown log buffer mode %s, keeping the head of the logs", p.Mode))
+		p.Mode = LogBufferHead
+	}
+
+	captureOutput := p.CaptureOutput
+	if captureOutput == nil && os.Getenv("IOPIPE_LOG_CAPTURE") != "" {
+		captureOutput = strToBool(os.Getenv("IOPIPE_LOG_CAPTURE"))
+	}
+
+	if captureOutput != nil && *captureOutput {
+		capture, err := startOutputCapture()
+		if err != nil {
+			agent.log.Warn(fmt.Sprintf("Unable to capture output, only logger output will be uploaded: %v", err))
+			return
+		}
+		p.capture = capture
+	}
 }
 
 func (p *loggerPlugin) PreInvoke(ctx context.Context, payload interface{}) {
-	p.proxyWriter.Reset()
+	context, ok := FromContext(ctx)
+	if !ok {
+		return
+	}
+
+	logger := context.IOpipe.Log
+
+	formatter := JSONFormatter{Name: p.Name}
+	if context.LambdaContext != nil {
+		formatter.RequestID = context.AwsRequestID
+	}
+	logger.Formatter = formatter
+
+	if p.Level != nil {
+		logger.SetLevel(*p.Level)
+	}
+
+	if p.Debug != nil && p.Debug(ctx, payload) {
+		logger.SetLevel(log.DebugLevel)
+		context.IOpipe.Label("@iopipe/plugin-logger.debug")
+	}
+
+	logger.ReportCaller = p.Caller == nil || *p.Caller
+
+	// Each invocation captures its logs in its own buffer
+	proxyWriter := NewProxyWriter()
+	if p.MaxBytes != nil {
+		proxyWriter.maxBytes = *p.MaxBytes
+	}
+	proxyWriter.keepTail = p.Mode != LogBufferHead
+
+	if p.capture != nil {
+		// Write to the original stderr so logs aren't captured twice
+		proxyWriter.proxyOut = p.capture.stderr.original
+		if !p.capture.add(proxyWriter, formatter.RequestID) {
+			context.IOpipe.agent.log.Warn("Invocations are running concurrently, process output isn't captured until only one is running")
+		}
+	}
+
+	context.IOpipe.SetPluginState(p, proxyWriter)
+	logger.SetOutput(proxyWriter)
 }
 
 func (p *loggerPlugin) PostInvoke(ctx context.Context, payload interface{}) {
-	context, _ := FromContext(ctx)
+	context, ok := FromContext(ctx)
+	if !ok {
+		return
+	}
+
+	if p.capture != nil {
+		p.capture.flush()
+	}
 
-	if p.proxyWriter.Len() > 0 {
+	if proxyWriter := p.proxyWriter(context.IOpipe); proxyWriter != nil && proxyWriter.Len() > 0 {
 		context.IOpipe.Label("@iopipe/plugin-logger")
 	}
 }
 
 func (p *loggerPlugin) PreReport(report *Report) {
-	var (
-		err            error
-		networkTimeout = 60 * time.Second
-	)
+	proxyWriter := p.proxyWriter(report.HandlerWrapper())
+	if proxyWriter != nil && p.capture != nil {
+		p.capture.flush()
+		p.capture.remove(proxyWriter)
+	}
+
+	if proxyWriter == nil {
+		return
+	}
+
+	written, dropped := proxyWriter.stats()
 
-	if p.proxyWriter.Len() == 0 {
+	// Logs of invocations that succeeded are discarded in errors only mode
+	_, errored := report.Errors.(*InvocationError)
+	discard := p.Mode == LogBufferErrorsOnly && !errored
+	if discard {
+		dropped = written
+	}
+
+	if p.bounded() {
+		report.addPreReportMetric(CustomMetric{Name: "@iopipe/plugin-logger.captured-bytes", N: written - dropped})
+		report.addPreReportMetric(CustomMetric{Name: "@iopipe/plugin-logger.dropped-bytes", N: dropped})
+	}
+
+	if written == 0 || discard {
 		report.agent.log.Debug("No log messages to upload, skipping")
 		return
 	}
 
-	signedRequest, err := GetSignedRequest(report, ".log")
+	logBytes, err := ioutil.ReadAll(proxyWriter)
 	if err != nil {
 		report.agent.log.Debug(err)
 		return
 	}
 
-	tr := &http.Transport{
-		DisableKeepAlives: false,
-		MaxIdleConns:      1, // is this equivalent to the maxCachedSessions in the js implementation
+	if dropped > 0 {
+		logBytes = p.addDroppedMarker(logBytes, dropped, report)
 	}
-	httpsClient := http.Client{Transport: tr, Timeout: networkTimeout}
 
-	req, err := http.NewRequest("PUT", signedRequest.SignedRequest, p.proxyWriter)
+	logBytes, redactions := report.agent.redactor().redactBytes(logBytes)
+	report.addRedactions(redactions)
+
+	compression, threshold := report.agent.compressionSettings()
+	payload, contentEncoding, err := compressPayload(logBytes, compression, threshold)
 	if err != nil {
 		report.agent.log.Debug(err)
 		return
 	}
 
-	res, err := httpsClient.Do(req)
-	if err != nil {
+	header := http.Header{}
+	if contentEncoding != "" {
+		header.Set("Content-Encoding", contentEncoding)
+	}
+
+	if err := report.upload(p.Meta().Name, "log", "", bytes.NewReader(payload), header); err != nil {
 		report.agent.log.Debug(err)
-		return
 	}
+}
+
+func (p *loggerPlugin) PostReport(report *Report) {}
+
+// OnShutdown stops capturing the process' output
+func (p *loggerPlugin) OnShutdown() {
+	if p.capture != nil {
+		p.capture.stop()
+	}
+}
 
-	report.agent.log.Debug("Log Data Upload Status: ", res.StatusCode)
+// proxyWriter returns the log buffer of the invocation
+func (p *loggerPlugin) proxyWriter(hw *HandlerWrapper) *ProxyWriter {
+	if hw == nil {
+		return nil
+	}
 
-	defer res.Body.Close()
-	bodyBytes, err := ioutil.ReadAll(res.Body)
-	report.agent.log.Debug("Log Data Upload Response: ", string(bodyBytes))
+	proxyWriter, _ := hw.PluginState(p).(*ProxyWriter)
+	return proxyWriter
+}
 
-	p.uploads = append(p.uploads, signedRequest.JWTAccess)
+// bounded returns true if the plugin drops logs
+func (p *loggerPlugin) bounded() bool {
+	return (p.MaxBytes != nil && *p.MaxBytes > 0) || p.Mode == LogBufferErrorsOnly
 }
 
-func (p *loggerPlugin) PostReport(report *Report) {}
+// addDroppedMarker adds an entry telling how many bytes of logs were dropped
+// where they were dropped from
+func (p *loggerPlugin) addDroppedMarker(logBytes []byte, dropped int, report *Report) []byte {
+	formatter := JSONFormatter{Name: p.Name}
+	if report.AWS != nil {
+		formatter.RequestID = report.AWS.AWSRequestID
+	}
+
+	marker, err := formatter.Format(&log.Entry{
+		Time:    time.Now(),
+		Level:   log.WarnLevel,
+		Message: fmt.Sprintf("%d bytes of logs dropped by the log buffer", dropped),
+	})
+	if err != nil {
+		return logBytes
+	}
+
+	if p.Mode == LogBufferHead {
+		return append(logBytes, marker...)
+	}
+
+	return append(marker, logBytes...)
+}
 
 // LoggerPlugin loads the logger plugin
 func LoggerPlugin(config LoggerPluginConfig) PluginInstantiator {
 	return func() Plugin {
 		return &loggerPlugin{
 			LoggerPluginConfig: config,
-			proxyWriter:        NewProxyWriter(),
 		}
 	}
 }
 
+func init() {
+	RegisterPlugin("@iopipe/logger", func(raw json.RawMessage) (Plugin, error) {
+		var config LoggerPluginConfig
+		if err := DecodePluginConfig(raw, &config); err != nil {
+			return nil, err
+		}
+
+		return LoggerPlugin(config)(), nil
+	})
+}
+
 // JSONEntry is a JSON log message
 type JSONEntry struct {
-	Timestamp string `json:"timestamp"`
-	Name      string `json:"name"`
-	Severity  string `json"severity"`
-	Message   string `json:"message"`
+	Timestamp string                 `json:"timestamp"`
+	Name      string                 `json:"name"`
+	Severity  string                 `json:"severity"`
+	Message   string                 `json:"message"`
+	RequestID string                 `json:"requestId,omitempty"`
+	Caller    *JSONCaller            `json:"caller,omitempty"`
+	Fields    map[string]interface{} `json:"fields,omitempty"`
+}
+
+// JSONCaller is where a JSON log message was logged
+type JSONCaller struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Function string `json:"function"`
 }
 
 // JSONFormatter formats logs into JSON
-type JSONFormatter struct{}
+type JSONFormatter struct {
+	// Name is the logger name, defaults to root
+	Name string
+
+	// RequestID is the AWS request ID of the invocation logging
+	RequestID string
+}
 
 // Format formats a log entry into JSON
 func (f JSONFormatter) Format(entry *log.Entry) ([]byte, error) {
 	jsonEntry := JSONEntry{
 		Timestamp: entry.Time.UTC().Format("2006-01-02 15:04:05.000"),
-		Name:      "root",
+		Name:      f.Name,
 		Severity:  entry.Level.String(),
 		Message:   entry.Message,
+		RequestID: f.RequestID,
+	}
+
+	if jsonEntry.Name == "" {
+		jsonEntry.Name = "root"
+	}
+
+	if entry.HasCaller() {
+		jsonEntry.Caller = &JSONCaller{
+			File:     entry.Caller.File,
+			Line:     entry.Caller.Line,
+			Function: entry.Caller.Function,
+		}
+	}
+
+	if len(entry.Data) > 0 {
+		jsonEntry.Fields = make(map[string]interface{}, len(entry.Data))
+		for key, value := range entry.Data {
+			// Errors have no exported fields and would be serialized as {}
+			if err, ok := value.(error); ok {
+				value = err.Error()
+			}
+			jsonEntry.Fields[key] = value
+		}
 	}
 
 	serialized, err := json.Marshal(jsonEntry)
+	if err != nil && jsonEntry.Fields != nil {
+		// Fall back to the string form of fields that can't be serialized
+		for key, value := range jsonEntry.Fields {
+			jsonEntry.Fields[key] = fmt.Sprint(value)
+		}
+		serialized, err = json.Marshal(jsonEntry)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("Failed to marshal fields to JSON, %v", err)
 	}
@@ -150,6 +404,14 @@ type ProxyWriter struct {
 	buffer   *bytes.Buffer
 	mutex    sync.RWMutex
 	proxyOut io.Writer
+
+	// maxBytes caps the buffer size when positive, keeping whole entries
+	// from the start of the logs, or from the end if keepTail is true
+	maxBytes int
+	keepTail bool
+
+	written int
+	dropped int
 }
 
 // NewProxyWriter returns a new proxy log writer
@@ -170,8 +432,8 @@ func (w *ProxyWriter) Len() int {
 
 // Read reads from the memory buffer
 func (w *ProxyWriter) Read(p []byte) (int, error) {
-	w.mutex.RLock()
-	defer w.mutex.RUnlock()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
 
 	return w.buffer.Read(p)
 }
@@ -182,6 +444,16 @@ func (w *ProxyWriter) Reset() {
 	defer w.mutex.Unlock()
 
 	w.buffer.Reset()
+	w.written = 0
+	w.dropped = 0
+}
+
+// capture writes bytes to the buffer only
+func (w *ProxyWriter) capture(p []byte) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.append(p)
 }
 
 // Write writes bytes to the buffer
@@ -189,7 +461,46 @@ func (w *ProxyWriter) Write(p []byte) (int, error) {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
-	w.buffer.Write(p)
+	w.append(p)
 
 	return w.proxyOut.Write(p)
 }
+
+// stats returns the number of bytes written to the buffer and dropped from it
+func (w *ProxyWriter) stats() (written int, dropped int) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	return w.written, w.dropped
+}
+
+// append adds an entry to the buffer, dropping entries that don't fit
+func (w *ProxyWriter) append(p []byte) {
+	w.written += len(p)
+
+	if w.maxBytes <= 0 {
+		w.buffer.Write(p)
+		return
+	}
+
+	if len(p) > w.maxBytes || (!w.keepTail && w.buffer.Len()+len(p) > w.maxBytes) {
+		w.dropped += len(p)
+		return
+	}
+
+	w.buffer.Write(p)
+
+	excess := w.buffer.Len() - w.maxBytes
+	if excess <= 0 {
+		return
+	}
+
+	// Drop the oldest entries up to the end of the line the excess ends in
+	cut := excess
+	if index := bytes.IndexByte(w.buffer.Bytes()[excess-1:], '\n'); index >= 0 {
+		cut += index
+	}
+
+	w.buffer.Next(cut)
+	w.dropped += cut
+}
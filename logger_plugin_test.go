@@ -1,14 +1,21 @@
 package iopipe
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	log "github.com/sirupsen/logrus"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -69,3 +76,391 @@ func TestLoggerPlugin_LoggerPlugin(t *testing.T) {
 		})
 	})
 }
+
+func TestLoggerPlugin_Redaction(t *testing.T) {
+	var uploaded []byte
+
+	ts := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		uploaded, _ = ioutil.ReadAll(req.Body)
+		res.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	ts2 := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		signerResponseJSONBytes, _ := json.Marshal(&SignerResponse{
+			JWTAccess:     "foobar",
+			SignedRequest: ts.URL,
+			URL:           "https://some-url",
+		})
+		fmt.Fprintln(res, string(signerResponseJSONBytes))
+	}))
+	defer ts2.Close()
+
+	oldRegion := os.Getenv("AWS_REGION")
+	defer os.Setenv("AWS_REGION", oldRegion)
+	os.Setenv("AWS_REGION", "mock")
+	os.Setenv("MOCK_SERVER", ts2.URL)
+
+	Convey("Logs are redacted before they are uploaded", t, func() {
+		var reported *Report
+
+		a := NewAgent(Config{
+			Redaction: &RedactionConfig{},
+			Plugins: []PluginInstantiator{
+				LoggerPlugin(LoggerPluginConfig{}),
+			},
+			Reporter: func(report *Report) error {
+				reported = report
+				return nil
+			},
+		})
+
+		hw := NewHandlerWrapper(func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+			context.IOpipe.Log.Info("signed in as jane@example.com")
+			context.IOpipe.Log.Info("password=hunter2")
+			return nil
+		}, a)
+
+		hw.Invoke(context.Background(), nil)
+
+		So(string(uploaded), ShouldContainSubstring, "signed in as [REDACTED]")
+		So(string(uploaded), ShouldContainSubstring, "password=[REDACTED]")
+		So(string(uploaded), ShouldNotContainSubstring, "hunter2")
+		So(reported.CustomMetrics, ShouldContain, CustomMetric{Name: "@iopipe/redactions", N: int64(2)})
+	})
+}
+
+func TestLoggerPlugin_StructuredLogs(t *testing.T) {
+	var uploaded []byte
+
+	ts := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPut {
+			uploaded, _ = ioutil.ReadAll(req.Body)
+			return
+		}
+
+		signerResponseJSONBytes, _ := json.Marshal(&SignerResponse{
+			JWTAccess:     "foobar",
+			SignedRequest: "http://" + req.Host + "/upload",
+		})
+		fmt.Fprintln(res, string(signerResponseJSONBytes))
+	}))
+	defer ts.Close()
+
+	oldRegion := os.Getenv("AWS_REGION")
+	defer os.Setenv("AWS_REGION", oldRegion)
+	os.Setenv("AWS_REGION", "mock")
+	os.Setenv("MOCK_SERVER", ts.URL)
+
+	warnLevel := log.WarnLevel
+
+	var reported *Report
+	a := NewAgent(Config{
+		Plugins: []PluginInstantiator{
+			LoggerPlugin(LoggerPluginConfig{
+				Name:  "api",
+				Level: &warnLevel,
+				Debug: func(ctx context.Context, payload interface{}) bool {
+					return payload == "debug"
+				},
+			}),
+		},
+		Reporter: func(report *Report) error {
+			reported = report
+			return nil
+		},
+	})
+
+	invoke := func(payload interface{}) []JSONEntry {
+		uploaded = nil
+
+		ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "request-1"})
+		NewHandlerWrapper(func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+			context.IOpipe.Log.Debug("checking cart")
+			context.IOpipe.Log.WithFields(log.Fields{"items": 3, "err": errors.New("out of stock")}).Warn("checkout failed")
+			return nil
+		}, a).Invoke(ctx, payload)
+
+		var entries []JSONEntry
+		for _, line := range strings.Split(strings.TrimSpace(string(uploaded)), "\n") {
+			var entry JSONEntry
+			So(ValidateLogEntryJSON([]byte(line)), ShouldBeNil)
+			So(json.Unmarshal([]byte(line), &entry), ShouldBeNil)
+			entries = append(entries, entry)
+		}
+
+		return entries
+	}
+
+	Convey("Log entries keep their fields, name, caller and request ID", t, func() {
+		entries := invoke(nil)
+
+		So(entries, ShouldHaveLength, 1)
+		So(entries[0].Name, ShouldEqual, "api")
+		So(entries[0].Message, ShouldEqual, "checkout failed")
+		So(entries[0].RequestID, ShouldEqual, "request-1")
+		So(entries[0].Fields, ShouldResemble, map[string]interface{}{"items": float64(3), "err": "out of stock"})
+		So(entries[0].Caller.File, ShouldEndWith, "logger_plugin_test.go")
+		So(entries[0].Caller.Function, ShouldContainSubstring, "TestLoggerPlugin_StructuredLogs")
+		So(reported.Labels, ShouldNotContain, "@iopipe/plugin-logger.debug")
+	})
+
+	Convey("Debug logs are captured for invocations the debug override is true for", t, func() {
+		entries := invoke("debug")
+
+		So(len(entries), ShouldBeGreaterThanOrEqualTo, 2)
+		So(entries[0].Severity, ShouldEqual, "debug")
+		So(entries[0].Message, ShouldEqual, "checking cart")
+		So(entries[1].Message, ShouldEqual, "checkout failed")
+		So(reported.Labels, ShouldContain, "@iopipe/plugin-logger.debug")
+	})
+}
+
+func TestLoggerPlugin_CaptureOutput(t *testing.T) {
+	var uploaded []byte
+
+	ts := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPut {
+			uploaded, _ = ioutil.ReadAll(req.Body)
+			return
+		}
+
+		signerResponseJSONBytes, _ := json.Marshal(&SignerResponse{
+			JWTAccess:     "foobar",
+			SignedRequest: "http://" + req.Host + "/upload",
+		})
+		fmt.Fprintln(res, string(signerResponseJSONBytes))
+	}))
+	defer ts.Close()
+
+	oldRegion := os.Getenv("AWS_REGION")
+	defer os.Setenv("AWS_REGION", oldRegion)
+	os.Setenv("AWS_REGION", "mock")
+	os.Setenv("MOCK_SERVER", ts.URL)
+
+	Convey("Process output is uploaded with the logger output when captured", t, func() {
+		a := NewAgent(Config{
+			Plugins: []PluginInstantiator{
+				LoggerPlugin(LoggerPluginConfig{CaptureOutput: True()}),
+			},
+			Reporter: func(report *Report) error {
+				return nil
+			},
+		})
+		defer activeCapture.stop()
+
+		NewHandlerWrapper(func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+			context.IOpipe.Log.Info("from logrus")
+			fmt.Println("from stdout")
+			return nil
+		}, a).Invoke(context.Background(), nil)
+
+		So(string(uploaded), ShouldContainSubstring, `"name":"stdout","severity":"info","message":"`)
+		So(string(uploaded), ShouldContainSubstring, `from stdout"`)
+		So(strings.Count(string(uploaded), "from logrus"), ShouldEqual, 1)
+	})
+}
+
+func TestLoggerPlugin_ConcurrentInvocations(t *testing.T) {
+	var (
+		mutex   sync.Mutex
+		uploads = make(map[string]string)
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPut {
+			body, _ := ioutil.ReadAll(req.Body)
+			mutex.Lock()
+			uploads[strings.TrimPrefix(req.URL.Path, "/upload/")] = string(body)
+			mutex.Unlock()
+			return
+		}
+
+		var signerRequest SignerRequest
+		json.NewDecoder(req.Body).Decode(&signerRequest)
+
+		signerResponseJSONBytes, _ := json.Marshal(&SignerResponse{
+			JWTAccess:     "jwt-" + signerRequest.RequestID,
+			SignedRequest: fmt.Sprintf("http://%s/upload/%s", req.Host, signerRequest.RequestID),
+		})
+		fmt.Fprintln(res, string(signerResponseJSONBytes))
+	}))
+	defer ts.Close()
+
+	oldRegion := os.Getenv("AWS_REGION")
+	defer os.Setenv("AWS_REGION", oldRegion)
+	os.Setenv("AWS_REGION", "mock")
+	os.Setenv("MOCK_SERVER", ts.URL)
+
+	Convey("Concurrent invocations capture and upload their own logs", t, func() {
+		var reports []*Report
+
+		a := NewAgent(Config{
+			Plugins: []PluginInstantiator{
+				LoggerPlugin(LoggerPluginConfig{}),
+			},
+			Reporter: func(report *Report) error {
+				mutex.Lock()
+				defer mutex.Unlock()
+				reports = append(reports, report)
+				return nil
+			},
+		})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				requestID := fmt.Sprintf("request-%d", i)
+				ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: requestID})
+
+				NewHandlerWrapper(func(ctx context.Context) error {
+					context, _ := FromContext(ctx)
+					context.IOpipe.Log.Info("hello from " + requestID)
+					return nil
+				}, a).Invoke(ctx, nil)
+			}(i)
+		}
+		wg.Wait()
+
+		So(len(reports), ShouldEqual, 10)
+		So(len(uploads), ShouldEqual, 10)
+
+		for _, report := range reports {
+			requestID := report.AWS.AWSRequestID
+
+			So(uploads[requestID], ShouldContainSubstring, "hello from "+requestID+`"`)
+			So(strings.Count(uploads[requestID], "hello from"), ShouldEqual, 1)
+			So(report.Plugins[0].Uploads, ShouldResemble, []string{"jwt-" + requestID})
+			So(report.Labels, ShouldContain, "@iopipe/plugin-logger")
+		}
+	})
+}
+
+func TestLoggerPlugin_LogBuffer(t *testing.T) {
+	var uploaded []byte
+
+	ts := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPut {
+			uploaded, _ = ioutil.ReadAll(req.Body)
+			return
+		}
+
+		signerResponseJSONBytes, _ := json.Marshal(&SignerResponse{
+			JWTAccess:     "foobar",
+			SignedRequest: "http://" + req.Host + "/upload",
+		})
+		fmt.Fprintln(res, string(signerResponseJSONBytes))
+	}))
+	defer ts.Close()
+
+	oldRegion := os.Getenv("AWS_REGION")
+	defer os.Setenv("AWS_REGION", oldRegion)
+	os.Setenv("AWS_REGION", "mock")
+	os.Setenv("MOCK_SERVER", ts.URL)
+
+	invoke := func(mode LogBufferMode, err error) *Report {
+		var reported *Report
+
+		uploaded = nil
+		maxBytes := 250
+
+		a := NewAgent(Config{
+			Plugins: []PluginInstantiator{
+				LoggerPlugin(LoggerPluginConfig{Caller: False(), MaxBytes: &maxBytes, Mode: mode}),
+			},
+			Reporter: func(report *Report) error {
+				reported = report
+				return nil
+			},
+		})
+
+		NewHandlerWrapper(func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+			for i := 1; i <= 5; i++ {
+				context.IOpipe.Log.Info(fmt.Sprintf("entry-%d", i))
+			}
+			return err
+		}, a).Invoke(context.Background(), nil)
+
+		return reported
+	}
+
+	metric := func(report *Report, name string) interface{} {
+		for _, metric := range report.CustomMetrics {
+			if metric.Name == name {
+				return metric.N
+			}
+		}
+		return nil
+	}
+
+	Convey("The head of the logs is kept with a marker for the dropped bytes after it", t, func() {
+		report := invoke(LogBufferHead, nil)
+
+		lines := strings.Split(strings.TrimSpace(string(uploaded)), "\n")
+		So(lines[0], ShouldContainSubstring, `"message":"entry-1"`)
+		So(string(uploaded), ShouldNotContainSubstring, "entry-5")
+		So(lines[len(lines)-1], ShouldContainSubstring, "bytes of logs dropped by the log buffer")
+
+		captured, dropped := metric(report, "@iopipe/plugin-logger.captured-bytes"), metric(report, "@iopipe/plugin-logger.dropped-bytes")
+		So(captured, ShouldBeBetweenOrEqual, 1, 250)
+		So(dropped, ShouldBeGreaterThan, 0)
+	})
+
+	Convey("The tail of the logs is kept with a marker for the dropped bytes before it", t, func() {
+		invoke(LogBufferTail, nil)
+
+		lines := strings.Split(strings.TrimSpace(string(uploaded)), "\n")
+		So(lines[0], ShouldContainSubstring, "bytes of logs dropped by the log buffer")
+		So(string(uploaded), ShouldNotContainSubstring, "entry-1")
+		So(lines[len(lines)-1], ShouldContainSubstring, `"message":"entry-5"`)
+	})
+
+	Convey("Logs are only uploaded for invocations that errored in errors only mode", t, func() {
+		report := invoke(LogBufferErrorsOnly, nil)
+
+		So(uploaded, ShouldBeNil)
+		So(metric(report, "@iopipe/plugin-logger.captured-bytes"), ShouldEqual, 0)
+		So(metric(report, "@iopipe/plugin-logger.dropped-bytes"), ShouldBeGreaterThan, 250)
+
+		invoke(LogBufferErrorsOnly, errors.New("checkout failed"))
+
+		So(string(uploaded), ShouldContainSubstring, `"message":"entry-5"`)
+	})
+}
+
+func TestLoggerPlugin_ProxyWriter(t *testing.T) {
+	Convey("Bounded proxy writers keep whole entries within the limit", t, func() {
+		write := func(w *ProxyWriter, entries ...string) string {
+			w.proxyOut = ioutil.Discard
+			for _, entry := range entries {
+				w.Write([]byte(entry + "\n"))
+			}
+			buffered, _ := ioutil.ReadAll(w)
+			return string(buffered)
+		}
+
+		w := &ProxyWriter{buffer: &bytes.Buffer{}, maxBytes: 10}
+		So(write(w, "aaa", "bbb", "ccc", "ddd"), ShouldEqual, "aaa\nbbb\n")
+		So(w.written, ShouldEqual, 16)
+		So(w.dropped, ShouldEqual, 8)
+
+		w = &ProxyWriter{buffer: &bytes.Buffer{}, maxBytes: 10, keepTail: true}
+		So(write(w, "aaa", "bbb", "ccc", "ddd"), ShouldEqual, "ccc\nddd\n")
+		So(w.dropped, ShouldEqual, 8)
+
+		w = &ProxyWriter{buffer: &bytes.Buffer{}, maxBytes: 10, keepTail: true}
+		So(write(w, "aaa", "this entry is too long", "bbb"), ShouldEqual, "aaa\nbbb\n")
+		So(w.dropped, ShouldEqual, 23)
+
+		w = &ProxyWriter{buffer: &bytes.Buffer{}}
+		So(write(w, "aaa", "bbb", "ccc", "ddd"), ShouldEqual, "aaa\nbbb\nccc\nddd\n")
+		So(w.dropped, ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,346 @@
+// Package mockcollector provides a mock IOpipe collector and signer for local
+// development. Point an agent at it by setting AWS_REGION=mock and
+// MOCK_SERVER to the collector URL.
+package mockcollector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iopipe/iopipe-go"
+)
+
+// Config is the mock collector configuration
+type Config struct {
+	// Dir is the directory reports and uploads are persisted to. Nothing is
+	// persisted if empty.
+	Dir string
+
+	// Token is the project token expected in reports and signer requests.
+	// Any token is accepted if empty.
+	Token string
+}
+
+// ReceivedReport is a report received by the collector
+type ReceivedReport struct {
+	ID              string          `json:"id"`
+	ReceivedAt      time.Time       `json:"receivedAt"`
+	ContentEncoding string          `json:"contentEncoding,omitempty"`
+	Valid           bool            `json:"valid"`
+	Errors          []string        `json:"errors,omitempty"`
+	Report          *iopipe.Report  `json:"-"`
+	Raw             json.RawMessage `json:"report"`
+}
+
+// ReceivedUpload is a file uploaded to a signed request URL
+type ReceivedUpload struct {
+	Key             string    `json:"key"`
+	ReceivedAt      time.Time `json:"receivedAt"`
+	ContentType     string    `json:"contentType"`
+	ContentEncoding string    `json:"contentEncoding,omitempty"`
+	Size            int       `json:"size"`
+	Body            []byte    `json:"-"`
+}
+
+// Collector is a mock IOpipe collector, signer and upload target
+type Collector struct {
+	config Config
+
+	mutex   sync.RWMutex
+	reports []*ReceivedReport
+	uploads map[string]*ReceivedUpload
+	signed  map[string]string
+
+	listener net.Listener
+	server   *http.Server
+	url      string
+}
+
+// New returns a new collector, loading any reports persisted in config.Dir
+func New(config Config) (*Collector, error) {
+	c := &Collector{
+		config:  config,
+		uploads: make(map[string]*ReceivedUpload),
+		signed:  make(map[string]string),
+	}
+
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Start starts serving the collector on addr, use "127.0.0.1:0" for a random port
+func (c *Collector) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	c.listener = listener
+	c.url = fmt.Sprintf("http://%s", listener.Addr().String())
+	c.server = &http.Server{Handler: c}
+
+	go c.server.Serve(listener)
+
+	return nil
+}
+
+// URL returns the URL to use as MOCK_SERVER, empty if the collector is not started
+func (c *Collector) URL() string {
+	return c.url
+}
+
+// Close stops serving the collector
+func (c *Collector) Close() error {
+	if c.server == nil {
+		return nil
+	}
+
+	return c.server.Close()
+}
+
+// Reports returns the reports received, oldest first
+func (c *Collector) Reports() []*ReceivedReport {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	reports := make([]*ReceivedReport, len(c.reports))
+	copy(reports, c.reports)
+
+	return reports
+}
+
+// ReportsFor returns the reports received for the AWS request ID
+func (c *Collector) ReportsFor(requestID string) []*ReceivedReport {
+	return c.Query(Query{RequestID: requestID})
+}
+
+// Uploads returns the files uploaded, sorted by key
+func (c *Collector) Uploads() []*ReceivedUpload {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	uploads := make([]*ReceivedUpload, 0, len(c.uploads))
+	for _, upload := range c.uploads {
+		uploads = append(uploads, upload)
+	}
+
+	sort.Slice(uploads, func(i, j int) bool {
+		return uploads[i].Key < uploads[j].Key
+	})
+
+	return uploads
+}
+
+// UploadsFor returns the files uploaded for the AWS request ID, sorted by key
+func (c *Collector) UploadsFor(requestID string) []*ReceivedUpload {
+	var uploads []*ReceivedUpload
+
+	prefix := sanitize(requestID) + "-"
+	for _, upload := range c.Uploads() {
+		if strings.HasPrefix(upload.Key, prefix) {
+			uploads = append(uploads, upload)
+		}
+	}
+
+	return uploads
+}
+
+// Upload returns the upload with the key
+func (c *Collector) Upload(key string) (*ReceivedUpload, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	upload, ok := c.uploads[key]
+	return upload, ok
+}
+
+// Reset discards all reports and uploads, including persisted ones
+func (c *Collector) Reset() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.reports = nil
+	c.uploads = make(map[string]*ReceivedUpload)
+	c.signed = make(map[string]string)
+
+	if c.config.Dir == "" {
+		return nil
+	}
+
+	for _, dir := range []string{c.reportsDir(), c.uploadsDir()} {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// receiveReport validates, stores and persists a decompressed report body
+func (c *Collector) receiveReport(body []byte, contentEncoding string) *ReceivedReport {
+	received := &ReceivedReport{
+		ReceivedAt:      time.Now().UTC(),
+		ContentEncoding: contentEncoding,
+		Raw:             json.RawMessage(body),
+	}
+
+	report, errs := validateReport(body)
+	if c.config.Token != "" && report != nil && report.ClientID != c.config.Token {
+		errs = append(errs, "client_id does not match the configured token")
+	}
+
+	received.Report = report
+	received.Valid = len(errs) == 0
+	received.Errors = errs
+
+	c.mutex.Lock()
+	received.ID = fmt.Sprintf("%06d", len(c.reports)+1)
+	if report != nil && report.AWS != nil && report.AWS.AWSRequestID != "" {
+		received.ID = fmt.Sprintf("%s-%s", received.ID, sanitize(report.AWS.AWSRequestID))
+	}
+	c.reports = append(c.reports, received)
+	c.mutex.Unlock()
+
+	c.persistReport(received)
+
+	return received
+}
+
+// receiveUpload stores and persists a decompressed uploaded file
+func (c *Collector) receiveUpload(key, contentType, contentEncoding string, body []byte) *ReceivedUpload {
+	upload := &ReceivedUpload{
+		Key:             key,
+		ReceivedAt:      time.Now().UTC(),
+		ContentType:     contentType,
+		ContentEncoding: contentEncoding,
+		Size:            len(body),
+		Body:            body,
+	}
+
+	c.mutex.Lock()
+	c.uploads[key] = upload
+	c.mutex.Unlock()
+
+	if c.config.Dir != "" {
+		writeFile(filepath.Join(c.uploadsDir(), key), body)
+	}
+
+	return upload
+}
+
+func (c *Collector) reportsDir() string {
+	return filepath.Join(c.config.Dir, "reports")
+}
+
+func (c *Collector) uploadsDir() string {
+	return filepath.Join(c.config.Dir, "uploads")
+}
+
+func (c *Collector) persistReport(received *ReceivedReport) {
+	if c.config.Dir == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(received, "", "  ")
+	if err != nil {
+		return
+	}
+
+	writeFile(filepath.Join(c.reportsDir(), received.ID+".json"), data)
+}
+
+// load reads the reports and uploads persisted in config.Dir
+func (c *Collector) load() error {
+	if c.config.Dir == "" {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(c.reportsDir())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(c.reportsDir(), file.Name()))
+		if err != nil {
+			return err
+		}
+
+		var received ReceivedReport
+		if err := json.Unmarshal(data, &received); err != nil {
+			return fmt.Errorf("%s: %v", file.Name(), err)
+		}
+
+		var report iopipe.Report
+		if json.Unmarshal(received.Raw, &report) == nil {
+			received.Report = &report
+		}
+
+		c.reports = append(c.reports, &received)
+	}
+
+	sort.Slice(c.reports, func(i, j int) bool {
+		return c.reports[i].ID < c.reports[j].ID
+	})
+
+	files, err = ioutil.ReadDir(c.uploadsDir())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		body, err := ioutil.ReadFile(filepath.Join(c.uploadsDir(), file.Name()))
+		if err != nil {
+			return err
+		}
+
+		c.uploads[file.Name()] = &ReceivedUpload{
+			Key:        file.Name(),
+			ReceivedAt: file.ModTime().UTC(),
+			Size:       len(body),
+			Body:       body,
+		}
+	}
+
+	return nil
+}
+
+func writeFile(path string, data []byte) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+
+	ioutil.WriteFile(path, data, 0644)
+}
+
+// sanitize makes s safe to use as a file name
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
@@ -0,0 +1,212 @@
+package mockcollector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/iopipe/iopipe-go"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func withMockServer(url string) func() {
+	oldRegion := os.Getenv("AWS_REGION")
+	oldMockServer := os.Getenv("MOCK_SERVER")
+
+	os.Setenv("AWS_REGION", "mock")
+	os.Setenv("MOCK_SERVER", url)
+
+	return func() {
+		os.Setenv("AWS_REGION", oldRegion)
+		os.Setenv("MOCK_SERVER", oldMockServer)
+	}
+}
+
+func invoke(agent *iopipe.Agent, requestID string, handler interface{}) {
+	ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{
+		AwsRequestID:       requestID,
+		InvokedFunctionArn: "arn:aws:lambda:local:0:function:mockcollector",
+	})
+
+	iopipe.NewHandlerWrapper(handler, agent).Invoke(ctx, nil)
+}
+
+func TestCollector_Agent(t *testing.T) {
+	Convey("Given a collector receiving reports from an agent", t, func() {
+		dir, _ := ioutil.TempDir("", "mockcollector")
+		defer os.RemoveAll(dir)
+
+		c, err := New(Config{Dir: dir, Token: "mock-token"})
+		So(err, ShouldBeNil)
+
+		ts := httptest.NewServer(c)
+		defer ts.Close()
+		defer withMockServer(ts.URL)()
+
+		token := "mock-token"
+		agent := iopipe.NewAgent(iopipe.Config{
+			Token: &token,
+			Plugins: []iopipe.PluginInstantiator{
+				iopipe.LoggerPlugin(iopipe.LoggerPluginConfig{}),
+			},
+		})
+
+		invoke(agent, "request-1", func(ctx context.Context) error {
+			context, _ := iopipe.FromContext(ctx)
+			context.IOpipe.Label("hello")
+			context.IOpipe.Log.Info("hello there")
+			return nil
+		})
+
+		Convey("Reports are received and validated", func() {
+			reports := c.ReportsFor("request-1")
+
+			So(len(reports), ShouldEqual, 1)
+			So(reports[0].Errors, ShouldBeEmpty)
+			So(reports[0].Valid, ShouldBeTrue)
+			So(reports[0].Report.Labels, ShouldContain, "hello")
+		})
+
+		Convey("Logs are uploaded to the signed request URL", func() {
+			uploads := c.UploadsFor("request-1")
+
+			So(len(uploads), ShouldEqual, 1)
+			So(string(uploads[0].Body), ShouldContainSubstring, "hello there")
+
+			reports := c.ReportsFor("request-1")
+			So(reports[0].Report.Plugins[0].Uploads, ShouldResemble, []string{"mock-jwt-" + uploads[0].Key})
+		})
+
+		Convey("Reports and uploads are persisted to disk", func() {
+			files, _ := filepath.Glob(filepath.Join(dir, "reports", "*.json"))
+			So(len(files), ShouldEqual, 1)
+
+			reloaded, err := New(Config{Dir: dir})
+			So(err, ShouldBeNil)
+			So(len(reloaded.ReportsFor("request-1")), ShouldEqual, 1)
+			So(len(reloaded.UploadsFor("request-1")), ShouldEqual, 1)
+		})
+
+		Convey("Reports can be queried over HTTP", func() {
+			invoke(agent, "request-2", func() error { return nil })
+
+			var reports []*ReceivedReport
+
+			res, err := http.Get(ts.URL + "/reports?label=hello")
+			So(err, ShouldBeNil)
+			json.NewDecoder(res.Body).Decode(&reports)
+			res.Body.Close()
+
+			So(len(reports), ShouldEqual, 1)
+
+			res, err = http.Get(ts.URL + "/reports/" + reports[0].ID)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			res.Body.Close()
+
+			res, err = http.Get(ts.URL + "/reports")
+			So(err, ShouldBeNil)
+			json.NewDecoder(res.Body).Decode(&reports)
+			res.Body.Close()
+
+			So(len(reports), ShouldEqual, 2)
+		})
+
+		Convey("Reports can be discarded", func() {
+			req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/reports", nil)
+			res, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNoContent)
+
+			So(c.Reports(), ShouldBeEmpty)
+			So(c.Uploads(), ShouldBeEmpty)
+		})
+	})
+}
+
+func TestCollector_Compression(t *testing.T) {
+	Convey("Given a collector receiving compressed reports from an agent", t, func() {
+		c, _ := New(Config{Token: "mock-token"})
+		ts := httptest.NewServer(c)
+		defer ts.Close()
+		defer withMockServer(ts.URL)()
+
+		token := "mock-token"
+		compression := "gzip"
+		threshold := 0
+		agent := iopipe.NewAgent(iopipe.Config{
+			Compression:          &compression,
+			CompressionThreshold: &threshold,
+			Token:                &token,
+			Plugins: []iopipe.PluginInstantiator{
+				iopipe.LoggerPlugin(iopipe.LoggerPluginConfig{}),
+			},
+		})
+
+		invoke(agent, "request-1", func(ctx context.Context) error {
+			context, _ := iopipe.FromContext(ctx)
+			context.IOpipe.Log.Info("hello there")
+			return nil
+		})
+
+		Convey("Reports are decompressed before validation", func() {
+			reports := c.ReportsFor("request-1")
+
+			So(len(reports), ShouldEqual, 1)
+			So(reports[0].ContentEncoding, ShouldEqual, "gzip")
+			So(reports[0].Valid, ShouldBeTrue)
+		})
+
+		Convey("Uploads are decompressed", func() {
+			uploads := c.UploadsFor("request-1")
+
+			So(len(uploads), ShouldEqual, 1)
+			So(uploads[0].ContentEncoding, ShouldEqual, "gzip")
+			So(string(uploads[0].Body), ShouldContainSubstring, "hello there")
+		})
+	})
+}
+
+func TestCollector_Validation(t *testing.T) {
+	Convey("Given a collector", t, func() {
+		c, _ := New(Config{Token: "mock-token"})
+		ts := httptest.NewServer(c)
+		defer ts.Close()
+
+		Convey("Malformed reports are rejected", func() {
+			res, err := http.Post(ts.URL+"/v0/event", "application/json", bytes.NewBufferString(`{"client_id":"mock-token"}`))
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusBadRequest)
+
+			reports := c.Reports()
+			So(len(reports), ShouldEqual, 1)
+			So(reports[0].Valid, ShouldBeFalse)
+			So(reports[0].Errors, ShouldContain, `$: missing required property "aws"`)
+		})
+
+		Convey("Signer requests require the token", func() {
+			body := `{"arn":"arn","requestId":"abc","timestamp":1,"extension":".log"}`
+			req, _ := http.NewRequest(http.MethodPost, ts.URL+"/signer", bytes.NewBufferString(body))
+			req.Header.Set("Authorization", "wrong-token")
+
+			res, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusUnauthorized)
+		})
+
+		Convey("Uploads require a signed key", func() {
+			req, _ := http.NewRequest(http.MethodPut, ts.URL+"/upload/unsigned.log", bytes.NewBufferString("hi"))
+
+			res, err := http.DefaultClient.Do(req)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusForbidden)
+		})
+	})
+}
@@ -0,0 +1,290 @@
+package mockcollector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/iopipe/iopipe-go"
+)
+
+// Query filters the reports returned by the query API
+type Query struct {
+	RequestID    string
+	FunctionName string
+	Label        string
+	Valid        *bool
+}
+
+// Query returns the reports matching q, oldest first
+func (c *Collector) Query(q Query) []*ReceivedReport {
+	var matches []*ReceivedReport
+
+	for _, received := range c.Reports() {
+		if q.matches(received) {
+			matches = append(matches, received)
+		}
+	}
+
+	return matches
+}
+
+func (q Query) matches(received *ReceivedReport) bool {
+	if q.Valid != nil && received.Valid != *q.Valid {
+		return false
+	}
+
+	report := received.Report
+	if q.RequestID != "" && (report == nil || report.AWS == nil || report.AWS.AWSRequestID != q.RequestID) {
+		return false
+	}
+
+	if q.FunctionName != "" && (report == nil || report.AWS == nil || report.AWS.FunctionName != q.FunctionName) {
+		return false
+	}
+
+	if q.Label != "" {
+		if report == nil {
+			return false
+		}
+
+		for _, label := range report.Labels {
+			if label == q.Label {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// ServeHTTP serves the collector endpoints:
+//
+//	POST   /v0/event          receive a report
+//	POST   /signer            sign an upload request
+//	POST   /                  receive a report or sign an upload request (MOCK_SERVER)
+//	PUT    /upload/{key}      receive a signed upload
+//	GET    /reports           query reports (requestId, functionName, label, valid)
+//	GET    /reports/{id}      get a report
+//	DELETE /reports           discard all reports and uploads
+//	GET    /uploads           list uploads
+//	GET    /uploads/{key}     get an upload
+func (c *Collector) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	path := strings.TrimSuffix(req.URL.Path, "/")
+
+	switch {
+	case req.Method == http.MethodPost && path == "/v0/event":
+		c.handleReport(res, req)
+	case req.Method == http.MethodPost && path == "/signer":
+		c.handleSigner(res, req)
+	case req.Method == http.MethodPost && path == "":
+		c.handleMockServer(res, req)
+	case req.Method == http.MethodPut && strings.HasPrefix(path, "/upload/"):
+		c.handleUpload(res, req, strings.TrimPrefix(path, "/upload/"))
+	case req.Method == http.MethodGet && path == "/reports":
+		c.handleQuery(res, req)
+	case req.Method == http.MethodGet && strings.HasPrefix(path, "/reports/"):
+		c.handleGetReport(res, strings.TrimPrefix(path, "/reports/"))
+	case req.Method == http.MethodDelete && path == "/reports":
+		if err := c.Reset(); err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.WriteHeader(http.StatusNoContent)
+	case req.Method == http.MethodGet && path == "/uploads":
+		writeJSON(res, http.StatusOK, c.Uploads())
+	case req.Method == http.MethodGet && strings.HasPrefix(path, "/uploads/"):
+		c.handleGetUpload(res, strings.TrimPrefix(path, "/uploads/"))
+	default:
+		http.NotFound(res, req)
+	}
+}
+
+// handleMockServer handles the MOCK_SERVER URL, which the agent uses for both
+// reports and signer requests. Signer requests have an "extension" field.
+func (c *Collector) handleMockServer(res http.ResponseWriter, req *http.Request) {
+	body, err := readBody(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err == nil {
+		if _, ok := fields["extension"]; ok {
+			c.sign(res, req, body)
+			return
+		}
+	}
+
+	c.report(res, req, body)
+}
+
+func (c *Collector) handleReport(res http.ResponseWriter, req *http.Request) {
+	body, err := readBody(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.report(res, req, body)
+}
+
+func (c *Collector) report(res http.ResponseWriter, req *http.Request, body []byte) {
+	received := c.receiveReport(body, req.Header.Get("Content-Encoding"))
+
+	if !received.Valid {
+		writeJSON(res, http.StatusBadRequest, map[string]interface{}{
+			"id":     received.ID,
+			"errors": received.Errors,
+		})
+		return
+	}
+
+	writeJSON(res, http.StatusCreated, map[string]interface{}{"id": received.ID})
+}
+
+func (c *Collector) handleSigner(res http.ResponseWriter, req *http.Request) {
+	body, err := readBody(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.sign(res, req, body)
+}
+
+func (c *Collector) sign(res http.ResponseWriter, req *http.Request, body []byte) {
+	token := req.Header.Get("Authorization")
+	if token == "" || (c.config.Token != "" && token != c.config.Token) {
+		http.Error(res, "invalid authorization", http.StatusUnauthorized)
+		return
+	}
+
+	var signerRequest iopipe.SignerRequest
+	if err := json.Unmarshal(body, &signerRequest); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if signerRequest.RequestID == "" {
+		http.Error(res, "missing requestId", http.StatusBadRequest)
+		return
+	}
+
+	c.mutex.Lock()
+	key := sanitize(fmt.Sprintf("%s-%d%s", signerRequest.RequestID, len(c.signed)+1, signerRequest.Extension))
+	jwt := fmt.Sprintf("mock-jwt-%s", key)
+	c.signed[key] = jwt
+	c.mutex.Unlock()
+
+	uploadURL := fmt.Sprintf("%s/upload/%s", c.baseURL(req), key)
+
+	writeJSON(res, http.StatusOK, &iopipe.SignerResponse{
+		JWTAccess:     jwt,
+		SignedRequest: uploadURL,
+		URL:           uploadURL,
+	})
+}
+
+func (c *Collector) handleUpload(res http.ResponseWriter, req *http.Request, key string) {
+	c.mutex.RLock()
+	_, signed := c.signed[key]
+	c.mutex.RUnlock()
+
+	if !signed {
+		http.Error(res, "upload key was not signed", http.StatusForbidden)
+		return
+	}
+
+	body, err := readBody(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.receiveUpload(key, req.Header.Get("Content-Type"), req.Header.Get("Content-Encoding"), body)
+
+	res.WriteHeader(http.StatusOK)
+}
+
+func (c *Collector) handleQuery(res http.ResponseWriter, req *http.Request) {
+	values := req.URL.Query()
+
+	q := Query{
+		RequestID:    values.Get("requestId"),
+		FunctionName: values.Get("functionName"),
+		Label:        values.Get("label"),
+	}
+
+	if valid := values.Get("valid"); valid != "" {
+		b, err := strconv.ParseBool(valid)
+		if err != nil {
+			http.Error(res, "valid must be true or false", http.StatusBadRequest)
+			return
+		}
+		q.Valid = &b
+	}
+
+	reports := c.Query(q)
+	if reports == nil {
+		reports = []*ReceivedReport{}
+	}
+
+	writeJSON(res, http.StatusOK, reports)
+}
+
+func (c *Collector) handleGetReport(res http.ResponseWriter, id string) {
+	for _, received := range c.Reports() {
+		if received.ID == id {
+			writeJSON(res, http.StatusOK, received)
+			return
+		}
+	}
+
+	http.Error(res, "report not found", http.StatusNotFound)
+}
+
+func (c *Collector) handleGetUpload(res http.ResponseWriter, key string) {
+	upload, ok := c.Upload(key)
+	if !ok {
+		http.Error(res, "upload not found", http.StatusNotFound)
+		return
+	}
+
+	if upload.ContentType != "" {
+		res.Header().Set("Content-Type", upload.ContentType)
+	}
+
+	res.Write(upload.Body)
+}
+
+// baseURL returns the URL uploads should be sent to
+func (c *Collector) baseURL(req *http.Request) string {
+	if c.url != "" {
+		return c.url
+	}
+
+	return fmt.Sprintf("http://%s", req.Host)
+}
+
+func writeJSON(res http.ResponseWriter, status int, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(v)
+}
+
+// readBody reads a request body, decoding its Content-Encoding
+func readBody(req *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return iopipe.DecompressPayload(body, req.Header.Get("Content-Encoding"))
+}
@@ -0,0 +1,35 @@
+package mockcollector
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/iopipe/iopipe-go"
+)
+
+// validateReport decodes a report body and returns the report along with any
+// violations of the report schema
+func validateReport(body []byte) (*iopipe.Report, []string) {
+	var errs []string
+
+	if err := iopipe.ValidateReportJSON(body); err != nil {
+		if validationErr, ok := err.(*iopipe.ValidationError); ok {
+			errs = validationErr.Violations
+		} else {
+			errs = []string{err.Error()}
+		}
+	}
+
+	var report iopipe.Report
+	if err := json.Unmarshal(body, &report); err != nil {
+		return nil, append(errs, fmt.Sprintf("report does not match the report format: %v", err))
+	}
+
+	for index, metric := range report.CustomMetrics {
+		if (metric.S == nil) == (metric.N == nil) {
+			errs = append(errs, fmt.Sprintf("$.custom_metrics[%d]: must have exactly one of \"s\" or \"n\"", index))
+		}
+	}
+
+	return &report, errs
+}
@@ -0,0 +1,253 @@
+package iopipe
+
+import (
+	"bytes"
+	stdlog "log"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// outputFlushMarker is written to the capture pipes to find out when the
+// output written before it has been captured
+const outputFlushMarker = "\x00iopipe:flush\x00"
+
+// outputFlushTimeout is how long to wait for output to be captured
+const outputFlushTimeout = 100 * time.Millisecond
+
+var (
+	activeCapture      *outputCapture
+	activeCaptureMutex sync.Mutex
+)
+
+// outputCapture captures the process' stdout, stderr and standard library log
+// output into the buffer of the running invocation, passing it through to the
+// original stdout and stderr
+type outputCapture struct {
+	stdout *streamCapture
+	stderr *streamCapture
+	stdlog *logCapture
+
+	sinksMutex sync.RWMutex
+	sinks      map[*ProxyWriter]string
+}
+
+// streamCapture captures a file by replacing it with a pipe
+type streamCapture struct {
+	name     string
+	capture  *outputCapture
+	original *os.File
+	reader   *os.File
+	writer   *os.File
+
+	flushMutex sync.Mutex
+	flushed    chan struct{}
+}
+
+// logCapture captures the standard library log output
+type logCapture struct {
+	capture *outputCapture
+}
+
+// startOutputCapture starts capturing the process' output, once per process
+func startOutputCapture() (*outputCapture, error) {
+	activeCaptureMutex.Lock()
+	defer activeCaptureMutex.Unlock()
+
+	if activeCapture != nil {
+		return activeCapture, nil
+	}
+
+	c := &outputCapture{sinks: map[*ProxyWriter]string{}}
+
+	var err error
+	if c.stdout, err = c.newStreamCapture("stdout", os.Stdout); err != nil {
+		return nil, err
+	}
+	if c.stderr, err = c.newStreamCapture("stderr", os.Stderr); err != nil {
+		c.stdout.close()
+		return nil, err
+	}
+	c.stdlog = &logCapture{capture: c}
+
+	os.Stdout = c.stdout.writer
+	os.Stderr = c.stderr.writer
+	stdlog.SetOutput(c.stdlog)
+
+	go c.stdout.run()
+	go c.stderr.run()
+
+	activeCapture = c
+	return c, nil
+}
+
+// stop restores the process' output and stops capturing it
+func (c *outputCapture) stop() {
+	activeCaptureMutex.Lock()
+	defer activeCaptureMutex.Unlock()
+
+	c.flush()
+
+	os.Stdout = c.stdout.original
+	os.Stderr = c.stderr.original
+	stdlog.SetOutput(os.Stderr)
+
+	c.stdout.close()
+	c.stderr.close()
+
+	if activeCapture == c {
+		activeCapture = nil
+	}
+}
+
+func (c *outputCapture) newStreamCapture(name string, original *os.File) (*streamCapture, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	return &streamCapture{
+		name:     name,
+		capture:  c,
+		original: original,
+		reader:   reader,
+		writer:   writer,
+		flushed:  make(chan struct{}, 1),
+	}, nil
+}
+
+// add starts capturing output into the buffer of the invocation requestID,
+// returning false if other invocations are running, in which case nothing is
+// captured until only one is left
+func (c *outputCapture) add(proxyWriter *ProxyWriter, requestID string) bool {
+	c.sinksMutex.Lock()
+	defer c.sinksMutex.Unlock()
+
+	c.sinks[proxyWriter] = requestID
+
+	return len(c.sinks) == 1
+}
+
+// remove stops capturing output into the buffer
+func (c *outputCapture) remove(proxyWriter *ProxyWriter) {
+	c.sinksMutex.Lock()
+	defer c.sinksMutex.Unlock()
+
+	delete(c.sinks, proxyWriter)
+}
+
+// flush waits for the output written so far to be captured
+func (c *outputCapture) flush() {
+	c.stdout.flush()
+	c.stderr.flush()
+}
+
+// write adds a line of output to the buffer of the running invocation. Output
+// can't be attributed to an invocation while several run concurrently, so it
+// isn't captured then.
+func (c *outputCapture) write(name string, line []byte) {
+	c.sinksMutex.RLock()
+	defer c.sinksMutex.RUnlock()
+
+	if len(c.sinks) != 1 {
+		return
+	}
+
+	now := time.Now()
+	message := string(bytes.TrimRight(line, "\r\n"))
+
+	for proxyWriter, requestID := range c.sinks {
+		formatted, err := JSONFormatter{Name: name, RequestID: requestID}.Format(&log.Entry{
+			Time:    now,
+			Level:   log.InfoLevel,
+			Message: message,
+		})
+		if err != nil {
+			continue
+		}
+
+		proxyWriter.capture(formatted)
+	}
+}
+
+// run copies the pipe to the original file, capturing each line
+func (s *streamCapture) run() {
+	var (
+		buf     = make([]byte, 32*1024)
+		pending []byte
+	)
+
+	for {
+		n, err := s.reader.Read(buf)
+		pending = append(pending, buf[:n]...)
+
+		for {
+			index := bytes.IndexByte(pending, '\n')
+			if index < 0 {
+				break
+			}
+
+			line := pending[:index+1]
+			pending = pending[index+1:]
+
+			if marker := bytes.Index(line, []byte(outputFlushMarker)); marker >= 0 {
+				// Output before the marker without a newline ends with it
+				if marker > 0 {
+					s.original.Write(append(line[:marker:marker], '\n'))
+					s.capture.write(s.name, line[:marker])
+				}
+
+				select {
+				case s.flushed <- struct{}{}:
+				default:
+				}
+				continue
+			}
+
+			s.original.Write(line)
+			s.capture.write(s.name, line)
+		}
+
+		if err != nil {
+			if len(pending) > 0 {
+				s.original.Write(pending)
+				s.capture.write(s.name, pending)
+			}
+			s.reader.Close()
+			return
+		}
+	}
+}
+
+// flush waits for the output written to the pipe so far to be captured
+func (s *streamCapture) flush() {
+	s.flushMutex.Lock()
+	defer s.flushMutex.Unlock()
+
+	// Drop a confirmation left over from a flush that timed out
+	select {
+	case <-s.flushed:
+	default:
+	}
+
+	if _, err := s.writer.Write([]byte(outputFlushMarker + "\n")); err != nil {
+		return
+	}
+
+	select {
+	case <-s.flushed:
+	case <-time.After(outputFlushTimeout):
+	}
+}
+
+func (s *streamCapture) close() {
+	s.writer.Close()
+}
+
+// Write passes standard library log output through to stderr, capturing it
+func (l *logCapture) Write(p []byte) (int, error) {
+	l.capture.write("log", p)
+	return l.capture.stderr.original.Write(p)
+}
@@ -0,0 +1,109 @@
+package iopipe
+
+import (
+	"fmt"
+	"io/ioutil"
+	stdlog "log"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestOutputCapture_startOutputCapture(t *testing.T) {
+	Convey("Output is captured into the running invocations and passed through", t, func() {
+		stdout, err := ioutil.TempFile("", "stdout")
+		So(err, ShouldBeNil)
+		defer os.Remove(stdout.Name())
+
+		stderr, err := ioutil.TempFile("", "stderr")
+		So(err, ShouldBeNil)
+		defer os.Remove(stderr.Name())
+
+		originalStdout, originalStderr := os.Stdout, os.Stderr
+		os.Stdout, os.Stderr = stdout, stderr
+
+		c, err := startOutputCapture()
+		os.Stdout, os.Stderr = c.stdout.writer, c.stderr.writer
+		So(err, ShouldBeNil)
+
+		proxyWriter := NewProxyWriter()
+		c.add(proxyWriter, "request-1")
+
+		fmt.Println("hello stdout")
+		fmt.Fprint(os.Stderr, "no newline")
+		stdlog.Print("hello log")
+		c.flush()
+
+		c.remove(proxyWriter)
+		fmt.Println("after the invocation")
+
+		c.stop()
+		os.Stdout, os.Stderr = originalStdout, originalStderr
+
+		captured, _ := ioutil.ReadAll(proxyWriter)
+		lines := strings.Split(strings.TrimSpace(string(captured)), "\n")
+		So(len(lines), ShouldBeGreaterThanOrEqualTo, 3)
+
+		for _, line := range lines {
+			So(ValidateLogEntryJSON([]byte(line)), ShouldBeNil)
+			So(line, ShouldContainSubstring, `"requestId":"request-1"`)
+		}
+
+		// The test runner's own output is captured too
+		So(string(captured), ShouldContainSubstring, `"name":"stdout","severity":"info","message":"`)
+		So(string(captured), ShouldContainSubstring, `hello stdout"`)
+		So(string(captured), ShouldContainSubstring, `"name":"stderr","severity":"info","message":"no newline"`)
+		So(string(captured), ShouldContainSubstring, `"name":"log","severity":"info","message":"`)
+		So(string(captured), ShouldContainSubstring, `hello log"`)
+		So(string(captured), ShouldNotContainSubstring, "after the invocation")
+
+		passedStdout, _ := ioutil.ReadFile(stdout.Name())
+		So(string(passedStdout), ShouldContainSubstring, "hello stdout\n")
+		So(string(passedStdout), ShouldEndWith, "after the invocation\n")
+
+		passedStderr, _ := ioutil.ReadFile(stderr.Name())
+		So(string(passedStderr), ShouldContainSubstring, "no newline\n")
+		So(string(passedStderr), ShouldContainSubstring, "hello log\n")
+		So(string(passedStderr), ShouldNotContainSubstring, outputFlushMarker)
+	})
+	Convey("Output isn't captured while invocations overlap", t, func() {
+		stdout, err := ioutil.TempFile("", "stdout")
+		So(err, ShouldBeNil)
+		defer os.Remove(stdout.Name())
+
+		originalStdout := os.Stdout
+		os.Stdout = stdout
+
+		c, err := startOutputCapture()
+		So(err, ShouldBeNil)
+		os.Stdout = c.stdout.writer
+
+		first, second := NewProxyWriter(), NewProxyWriter()
+		So(c.add(first, "request-1"), ShouldBeTrue)
+		So(c.add(second, "request-2"), ShouldBeFalse)
+
+		fmt.Println("while overlapping")
+		c.flush()
+
+		c.remove(second)
+		fmt.Println("after the overlap")
+		c.flush()
+		c.remove(first)
+
+		c.stop()
+		os.Stdout = originalStdout
+
+		firstCaptured, _ := ioutil.ReadAll(first)
+		secondCaptured, _ := ioutil.ReadAll(second)
+
+		So(string(firstCaptured), ShouldNotContainSubstring, "while overlapping")
+		So(string(firstCaptured), ShouldContainSubstring, `after the overlap"`)
+		So(string(firstCaptured), ShouldNotContainSubstring, "request-2")
+		So(string(secondCaptured), ShouldBeEmpty)
+
+		passedStdout, _ := ioutil.ReadFile(stdout.Name())
+		So(string(passedStdout), ShouldContainSubstring, "while overlapping\n")
+	})
+}
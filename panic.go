@@ -11,6 +11,10 @@ import (
 const defaultErrorFrameCount = 32
 const framesToPanicInfo = 3 // (top-of-stack) Callers, getPanicStack -> getPanicInfo -> beyond
 
+// maxErrorFrameCount is the number of frames captured at most, reports keep
+// up to ReportLimits.MaxStackFrames of them
+const maxErrorFrameCount = 4096
+
 func getErrorType(err interface{}) string {
 	errorType := reflect.TypeOf(err)
 
@@ -94,6 +98,13 @@ func getPanicStack(framesToHide int) []*panicErrorStackFrame {
 	s := make([]uintptr, defaultErrorFrameCount)
 	n := runtime.Callers(framesToHide, s)
 
+	// Capture the whole stack so reports can keep more frames than the
+	// default, up to maxErrorFrameCount
+	for n == len(s) && len(s) < maxErrorFrameCount {
+		s = make([]uintptr, 2*len(s))
+		n = runtime.Callers(framesToHide, s)
+	}
+
 	if n == 0 {
 		return make([]*panicErrorStackFrame, 0)
 	}
@@ -1,6 +1,9 @@
 package iopipe
 
-import "context"
+import (
+	"context"
+	"fmt"
+)
 
 // PluginInstantiator is the function that initializes the plugin
 type PluginInstantiator func() Plugin
@@ -12,18 +15,156 @@ type PluginMeta struct {
 	Homepage string   `json:"homepage"`
 	Enabled  bool     `json:"enabled"`
 	Uploads  []string `json:"uploads"`
+
+	// Hooks is the outcome of the plugin's hooks, keyed by hook name
+	Hooks map[string]PluginHookMeta `json:"hooks,omitempty"`
+}
+
+// PluginHookMeta is the outcome of a plugin hook
+type PluginHookMeta struct {
+	// Duration is how long the hook ran, in nanoseconds
+	Duration int `json:"duration"`
+
+	// Error is the panic or timeout the hook failed with
+	Error string `json:"error,omitempty"`
+
+	// TimedOut is true if the hook was still running at its deadline
+	TimedOut bool `json:"timedOut,omitempty"`
 }
 
-// Plugin is the interface a plugin should implement
+// Plugin is the interface a plugin should implement. Plugins handle events by
+// also implementing the hook interfaces they need, such as PreInvokeHook or
+// TimeoutHook.
 type Plugin interface {
 	Meta() *PluginMeta
+}
+
+// PluginEnabler is implemented by plugins that can be disabled, the hooks of
+// plugins that don't implement it always run
+type PluginEnabler interface {
 	Enabled() bool
+}
+
+// PluginPriority is implemented by plugins whose hooks run before or after
+// those of other plugins, hooks of plugins with a lower priority run first.
+// Plugins that don't implement it have a priority of 0.
+type PluginPriority interface {
+	Priority() int
+}
+
+// PluginRunsAfter is implemented by plugins whose hooks run after those of
+// the named plugins, such as "@iopipe/logger". Plugins that aren't loaded
+// are ignored.
+type PluginRunsAfter interface {
+	After() []string
+}
 
-	// Hook methods
+// PluginRunsBefore is implemented by plugins whose hooks run before those of
+// the named plugins. Plugins that aren't loaded are ignored.
+type PluginRunsBefore interface {
+	Before() []string
+}
+
+// PreSetupHook is implemented by plugins running before the agent is set up
+type PreSetupHook interface {
 	PreSetup(*Agent)
+}
+
+// PostSetupHook is implemented by plugins running after the agent is set up
+type PostSetupHook interface {
 	PostSetup(*Agent)
+}
+
+// PreInvokeHook is implemented by plugins running before each invocation
+type PreInvokeHook interface {
 	PreInvoke(context.Context, interface{})
+}
+
+// PostInvokeHook is implemented by plugins running after each invocation
+type PostInvokeHook interface {
 	PostInvoke(context.Context, interface{})
+}
+
+// PreReportHook is implemented by plugins running before each report is sent.
+// Hooks should only change the report through its methods, such as Upload,
+// which drop the writes of hooks still running past their deadline.
+type PreReportHook interface {
 	PreReport(*Report)
+}
+
+// PostReportHook is implemented by plugins running after each report is sent
+type PostReportHook interface {
 	PostReport(*Report)
 }
+
+// ColdStartHook is implemented by plugins running before the PreInvoke hooks
+// of cold start invocations
+type ColdStartHook interface {
+	OnColdStart(context.Context)
+}
+
+// TimeoutHook is implemented by plugins running when an invocation is about to
+// time out, before its report is sent
+type TimeoutHook interface {
+	OnTimeout(context.Context)
+}
+
+// PanicHook is implemented by plugins running when a handler panics, before
+// the report is sent and the panic is re-raised
+type PanicHook interface {
+	OnPanic(ctx context.Context, recovered interface{})
+}
+
+// ErrorHook is implemented by plugins running when a handler returns an error
+// or reports one with HandlerWrapper.Error
+type ErrorHook interface {
+	OnError(context.Context, error)
+}
+
+// ShutdownHook is implemented by plugins running when the agent is shut down
+// with Agent.Shutdown
+type ShutdownHook interface {
+	OnShutdown()
+}
+
+// LabelHook is implemented by plugins running when a label is added to a
+// report. It is called inline and must not add labels itself.
+type LabelHook interface {
+	OnLabel(hw *HandlerWrapper, name string)
+}
+
+// MetricHook is implemented by plugins running when a custom metric is added
+// to a report. It is called inline and must not add metrics itself.
+type MetricHook interface {
+	OnMetric(hw *HandlerWrapper, metric CustomMetric)
+}
+
+// pluginName returns the name of plugin, or its type if it has no meta data
+func pluginName(plugin Plugin) string {
+	if meta := plugin.Meta(); meta != nil {
+		return meta.Name
+	}
+
+	return fmt.Sprintf("%T", plugin)
+}
+
+// pluginEnabled returns true if the hooks of plugin run, its environment
+// variable toggle taking precedence over its Enabled method
+func pluginEnabled(plugin Plugin) bool {
+	if enabled := pluginToggle(plugin); enabled != nil {
+		return *enabled
+	}
+
+	if enabler, ok := plugin.(PluginEnabler); ok {
+		return enabler.Enabled()
+	}
+
+	return true
+}
+
+// pluginToggledOff returns true if plugin is disabled by its environment
+// variable toggle, in which case none of its hooks run
+func pluginToggledOff(plugin Plugin) bool {
+	enabled := pluginToggle(plugin)
+	return enabled != nil && !*enabled
+}
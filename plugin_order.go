@@ -0,0 +1,160 @@
+package iopipe
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// orderPlugins sorts plugins so each runs after the plugins it depends on and
+// the plugins with a lower priority, keeping the order they were configured
+// in otherwise. It returns the sorted plugins and groups their indexes into
+// levels, the hooks of a level running concurrently once the hooks of the
+// previous level finished. If sequential, each plugin is in a level of its
+// own. Dependency cycles are logged and broken in the configured order.
+func orderPlugins(plugins []Plugin, sequential bool, logger *log.Logger) ([]Plugin, [][]int) {
+	count := len(plugins)
+
+	indexes := make(map[string][]int)
+	names := make([]string, count)
+	priorities := make([]int, count)
+	for index, plugin := range plugins {
+		if plugin == nil {
+			continue
+		}
+
+		if meta := plugin.Meta(); meta != nil {
+			indexes[meta.Name] = append(indexes[meta.Name], index)
+			names[index] = meta.Name
+		} else {
+			names[index] = pluginName(plugin)
+		}
+
+		if prioritized, ok := plugin.(PluginPriority); ok {
+			priorities[index] = prioritized.Priority()
+		}
+	}
+
+	// predecessors[i][j] is true if plugin j runs before plugin i
+	predecessors := make([]map[int]bool, count)
+	for index := range plugins {
+		predecessors[index] = make(map[int]bool)
+	}
+
+	addEdge := func(before, after int) {
+		if before != after {
+			predecessors[after][before] = true
+		}
+	}
+
+	for index, plugin := range plugins {
+		if plugin == nil {
+			continue
+		}
+
+		if runsAfter, ok := plugin.(PluginRunsAfter); ok {
+			for _, name := range runsAfter.After() {
+				for _, other := range indexes[name] {
+					addEdge(other, index)
+				}
+			}
+		}
+
+		if runsBefore, ok := plugin.(PluginRunsBefore); ok {
+			for _, name := range runsBefore.Before() {
+				for _, other := range indexes[name] {
+					addEdge(index, other)
+				}
+			}
+		}
+
+		for other := range plugins {
+			if priorities[other] < priorities[index] {
+				addEdge(other, index)
+			}
+		}
+	}
+
+	var (
+		levels  = make([]int, count)
+		order   = make([]int, 0, count)
+		ordered = make([]bool, count)
+	)
+
+	for len(order) < count {
+		next := -1
+		for index := range plugins {
+			if !ordered[index] && isReady(predecessors[index], ordered) {
+				next = index
+				break
+			}
+		}
+
+		// Every remaining plugin waits on another, break the cycle with the
+		// first of them
+		if next == -1 {
+			var cycle []string
+			for index, plugin := range plugins {
+				if !ordered[index] && plugin != nil {
+					cycle = append(cycle, names[index])
+				}
+			}
+			logger.Warn(fmt.Sprintf("Plugin dependency cycle between %s, running them in the configured order", strings.Join(cycle, ", ")))
+
+			for index := range plugins {
+				if !ordered[index] {
+					next = index
+					break
+				}
+			}
+		}
+
+		for predecessor := range predecessors[next] {
+			if ordered[predecessor] && levels[predecessor]+1 > levels[next] {
+				levels[next] = levels[predecessor] + 1
+			}
+		}
+
+		if sequential {
+			levels[next] = len(order)
+		}
+
+		ordered[next] = true
+		order = append(order, next)
+	}
+
+	sorted := make([]Plugin, count)
+	var groups [][]int
+
+	// A plugin's level is higher than those of the plugins ordered before it
+	// that it depends on, sort by level keeping the order within levels
+	position := 0
+	for level := 0; position < count; level++ {
+		var group []int
+		for _, index := range order {
+			if levels[index] == level {
+				sorted[position] = plugins[index]
+				group = append(group, position)
+				position++
+			}
+		}
+
+		if len(group) > 0 {
+			groups = append(groups, group)
+		}
+	}
+
+	return sorted, groups
+}
+
+// isReady returns true if all predecessors have been ordered
+func isReady(predecessors map[int]bool, ordered []bool) bool {
+	for predecessor := range predecessors {
+		if !ordered[predecessor] {
+			return false
+		}
+	}
+
+	return true
+}
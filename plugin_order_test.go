@@ -0,0 +1,97 @@
+package iopipe
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type orderTestPlugin struct {
+	name     string
+	priority int
+	after    []string
+	before   []string
+}
+
+func (p *orderTestPlugin) Meta() *PluginMeta {
+	return &PluginMeta{Name: p.name, Enabled: true, Uploads: []string{}}
+}
+
+func (p *orderTestPlugin) Priority() int    { return p.priority }
+func (p *orderTestPlugin) After() []string  { return p.after }
+func (p *orderTestPlugin) Before() []string { return p.before }
+
+type nilMetaOrderPlugin struct {
+	after []string
+}
+
+func (p *nilMetaOrderPlugin) Meta() *PluginMeta { return nil }
+
+func (p *nilMetaOrderPlugin) After() []string { return p.after }
+
+func pluginNames(plugins []Plugin) []string {
+	names := make([]string, len(plugins))
+	for index, plugin := range plugins {
+		names[index] = plugin.Meta().Name
+	}
+	return names
+}
+
+func TestPluginOrder_OrderPlugins(t *testing.T) {
+	Convey("Plugins without dependencies keep their order and run concurrently", t, func() {
+		plugins, levels := orderPlugins([]Plugin{
+			&orderTestPlugin{name: "a"},
+			&orderTestPlugin{name: "b"},
+			&hookTestPlugin{},
+		}, false, NewLogger())
+
+		So(pluginNames(plugins), ShouldResemble, []string{"a", "b", "@iopipe/test-hooks"})
+		So(levels, ShouldResemble, [][]int{{0, 1, 2}})
+	})
+
+	Convey("Plugins run after their dependencies and plugins with a lower priority", t, func() {
+		plugins, levels := orderPlugins([]Plugin{
+			&orderTestPlugin{name: "upload", after: []string{"redact"}},
+			&orderTestPlugin{name: "metrics"},
+			&orderTestPlugin{name: "redact", before: []string{"metrics"}},
+			&orderTestPlugin{name: "late", priority: 10, after: []string{"missing"}},
+		}, false, NewLogger())
+
+		So(pluginNames(plugins), ShouldResemble, []string{"redact", "upload", "metrics", "late"})
+		So(levels, ShouldResemble, [][]int{{0}, {1, 2}, {3}})
+	})
+
+	Convey("Dependency cycles are broken in the configured order", t, func() {
+		plugins, levels := orderPlugins([]Plugin{
+			&orderTestPlugin{name: "a", after: []string{"b"}},
+			&orderTestPlugin{name: "b", after: []string{"a"}},
+			&orderTestPlugin{name: "c", after: []string{"b"}},
+		}, false, NewLogger())
+
+		So(pluginNames(plugins), ShouldResemble, []string{"a", "b", "c"})
+		So(levels, ShouldResemble, [][]int{{0}, {1}, {2}})
+	})
+
+	Convey("Dependency cycles involving plugins without meta data are broken too", t, func() {
+		unnamed := &nilMetaOrderPlugin{after: []string{"a"}}
+
+		plugins, levels := orderPlugins([]Plugin{
+			&orderTestPlugin{name: "a", after: []string{"b"}},
+			&orderTestPlugin{name: "b", after: []string{"a"}},
+			unnamed,
+		}, false, NewLogger())
+
+		So(plugins[2], ShouldEqual, unnamed)
+		So(levels, ShouldResemble, [][]int{{0}, {1, 2}})
+	})
+
+	Convey("Sequential hooks put each plugin in a level of its own", t, func() {
+		plugins, levels := orderPlugins([]Plugin{
+			&orderTestPlugin{name: "a"},
+			&orderTestPlugin{name: "b", before: []string{"a"}},
+		}, true, NewLogger())
+
+		So(pluginNames(plugins), ShouldResemble, []string{"b", "a"})
+		So(levels, ShouldResemble, [][]int{{0}, {1}})
+	})
+}
@@ -0,0 +1,289 @@
+package iopipe
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+const defaultRedactionReplacement = "[REDACTED]"
+
+// RedactionConfig configures the scrubbing of reports and logs before they
+// are sent to IOpipe
+type RedactionConfig struct {
+	// DisableDetectors disables the built-in detectors for emails, card
+	// numbers, AWS access keys, JWTs, bearer tokens and private keys
+	DisableDetectors bool
+
+	// Keys are metric names and keys of key=value or "key": "value" pairs
+	// whose values are redacted, matched case-insensitively
+	Keys []string
+
+	// Replacement replaces redacted values, defaults to [REDACTED]
+	Replacement string
+
+	// Rules are additional patterns to redact
+	Rules []RedactionRule
+}
+
+// RedactionRule redacts the matches of a pattern
+type RedactionRule struct {
+	Name    string
+	Pattern *regexp.Regexp
+
+	// Validate optionally filters matches, only matches it returns true for
+	// are redacted
+	Validate func(match string) bool
+}
+
+var (
+	defaultRedactionKeys = []string{
+		"access_token",
+		"api_key",
+		"apikey",
+		"authorization",
+		"client_secret",
+		"passwd",
+		"password",
+		"secret",
+		"token",
+	}
+
+	defaultRedactionRules = []RedactionRule{
+		{
+			Name:    "private-key",
+			Pattern: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`),
+		},
+		{
+			Name:    "jwt",
+			Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+`),
+		},
+		{
+			Name:    "bearer",
+			Pattern: regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9\-._~+/]+=*`),
+		},
+		{
+			Name:    "aws-access-key",
+			Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),
+		},
+		{
+			Name:    "email",
+			Pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+		},
+		{
+			Name:     "card-number",
+			Pattern:  regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`),
+			Validate: luhnValid,
+		},
+	}
+)
+
+// redactor scrubs strings according to a RedactionConfig
+type redactor struct {
+	keys        map[string]struct{}
+	keyPattern  *regexp.Regexp
+	replacement string
+	rules       []RedactionRule
+}
+
+func newRedactor(config *RedactionConfig) *redactor {
+	if config == nil {
+		return nil
+	}
+
+	rd := &redactor{
+		keys:        make(map[string]struct{}),
+		replacement: config.Replacement,
+	}
+
+	if rd.replacement == "" {
+		rd.replacement = defaultRedactionReplacement
+	}
+
+	keys := config.Keys
+	if !config.DisableDetectors {
+		rd.rules = append(rd.rules, defaultRedactionRules...)
+		keys = append(keys, defaultRedactionKeys...)
+	}
+	rd.rules = append(rd.rules, config.Rules...)
+
+	var quoted []string
+	for _, key := range keys {
+		key = strings.ToLower(strings.TrimSpace(key))
+		if _, ok := rd.keys[key]; key == "" || ok {
+			continue
+		}
+		rd.keys[key] = struct{}{}
+		quoted = append(quoted, regexp.QuoteMeta(key))
+	}
+
+	if len(quoted) > 0 {
+		sort.Strings(quoted)
+		rd.keyPattern = regexp.MustCompile(`(?i)(\b(?:` + strings.Join(quoted, "|") + `)\b\\?"?\s*[:=]\s*\\?["']?)([^\s"'\\,;&\[\]]+)`)
+	}
+
+	return rd
+}
+
+// redactString returns s with sensitive values replaced and the number of
+// values replaced
+func (rd *redactor) redactString(s string) (string, int) {
+	if rd == nil || s == "" {
+		return s, 0
+	}
+
+	count := 0
+
+	for _, rule := range rd.rules {
+		if rule.Pattern == nil {
+			continue
+		}
+
+		s = rule.Pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if rule.Validate != nil && !rule.Validate(match) {
+				return match
+			}
+
+			count++
+			return rd.replacement
+		})
+	}
+
+	if rd.keyPattern != nil {
+		s = rd.keyPattern.ReplaceAllStringFunc(s, func(match string) string {
+			count++
+			return rd.keyPattern.ReplaceAllString(match, "${1}"+strings.Replace(rd.replacement, "$", "$$", -1))
+		})
+	}
+
+	return s, count
+}
+
+// redactBytes returns b with sensitive values replaced and the number of
+// values replaced
+func (rd *redactor) redactBytes(b []byte) ([]byte, int) {
+	if rd == nil || len(b) == 0 {
+		return b, 0
+	}
+
+	s, count := rd.redactString(string(b))
+	if count == 0 {
+		return b, 0
+	}
+
+	return []byte(s), count
+}
+
+// redactKey returns true if values named key should be redacted entirely
+func (rd *redactor) redactKey(key string) bool {
+	if rd == nil {
+		return false
+	}
+
+	_, ok := rd.keys[strings.ToLower(key)]
+	return ok
+}
+
+// redact scrubs the error message, string metrics and labels of the report
+func (r *Report) redact() {
+	rd := r.agent.redactor()
+	if rd == nil {
+		return
+	}
+
+	count := 0
+
+	if invocationError, ok := r.Errors.(*InvocationError); ok && invocationError != nil {
+		message, n := rd.redactString(invocationError.Message)
+		invocationError.Message = message
+		count += n
+	}
+
+	for index, metric := range r.CustomMetrics {
+		s, ok := metric.S.(string)
+		if !ok {
+			continue
+		}
+
+		if rd.redactKey(metric.Name) {
+			r.CustomMetrics[index].S = rd.replacement
+			count++
+			continue
+		}
+
+		s, n := rd.redactString(s)
+		r.CustomMetrics[index].S = s
+		count += n
+	}
+
+	labels := make([]string, 0, len(r.Labels))
+	seen := make(map[string]struct{}, len(r.Labels))
+	for _, label := range r.Labels {
+		label, n := rd.redactString(label)
+		count += n
+
+		if _, ok := seen[label]; !ok {
+			seen[label] = struct{}{}
+			labels = append(labels, label)
+		}
+	}
+	r.Labels = labels
+
+	r.addRedactions(count)
+}
+
+// addRedactions adds to the number of values redacted from the report and its
+// uploads, it is safe to call from PreReport hooks
+func (r *Report) addRedactions(count int) {
+	atomic.AddInt64(&r.redactions, int64(count))
+}
+
+// appendRedactionsMetric records the number of values redacted in the report
+func (r *Report) appendRedactionsMetric() {
+	if r.agent.redactor() == nil {
+		return
+	}
+
+	r.CustomMetrics = append(r.CustomMetrics, CustomMetric{
+		Name: "@iopipe/redactions",
+		N:    atomic.LoadInt64(&r.redactions),
+	})
+}
+
+// redactor returns the agent's redactor, nil if redaction is disabled
+func (a *Agent) redactor() *redactor {
+	if a == nil {
+		return nil
+	}
+
+	return a.redaction
+}
+
+// luhnValid returns true if the digits in s pass the Luhn checksum
+func luhnValid(s string) bool {
+	sum := 0
+	digits := 0
+	double := false
+
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+
+		sum += d
+		digits++
+		double = !double
+	}
+
+	return digits >= 13 && sum%10 == 0
+}
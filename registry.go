@@ -0,0 +1,181 @@
+package iopipe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PluginFactory creates a plugin from its config block, which is empty if the
+// plugin wasn't given one
+type PluginFactory func(config json.RawMessage) (Plugin, error)
+
+var (
+	registryMutex sync.RWMutex
+	registry      = map[string]PluginFactory{}
+)
+
+// RegisterPlugin registers the factory of the plugin with name, its
+// PluginMeta name, so it can be loaded with the IOPIPE_PLUGINS environment
+// variable or the plugins file. Registering a name again replaces its
+// factory. Plugins usually register themselves in an init function.
+func RegisterPlugin(name string, factory PluginFactory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+
+	registry[name] = factory
+}
+
+// RegisteredPlugins returns the names of the registered plugins, sorted
+func RegisteredPlugins() []string {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// DecodePluginConfig decodes the config block of a plugin into config,
+// leaving it unchanged if the block is empty. Unknown fields are errors, to
+// catch misspelled options.
+func DecodePluginConfig(raw json.RawMessage, config interface{}) error {
+	if len(bytes.TrimSpace(raw)) == 0 || bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(config)
+}
+
+// pluginsFile is the plugins file, listing the plugins to load with their
+// config blocks
+type pluginsFile struct {
+	Plugins []pluginsFileEntry `json:"plugins"`
+}
+
+type pluginsFileEntry struct {
+	Name   string          `json:"name"`
+	Config json.RawMessage `json:"config"`
+}
+
+// registeredPlugins instantiates the registered plugins listed in the plugins
+// file, then those listed in the IOPIPE_PLUGINS environment variable, skipping
+// the plugins named in loaded. Plugins that can't be loaded are logged.
+func registeredPlugins(path string, loaded []Plugin, logger *log.Logger) []Plugin {
+	var entries []pluginsFileEntry
+
+	if path != "" {
+		file, err := readPluginsFile(path)
+		if err != nil {
+			logger.Warn(fmt.Sprintf("Unable to read plugins file %s: %v", path, err))
+		} else {
+			entries = file.Plugins
+		}
+	}
+
+	for _, name := range strings.Split(os.Getenv("IOPIPE_PLUGINS"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			entries = append(entries, pluginsFileEntry{Name: name})
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, plugin := range loaded {
+		if plugin == nil {
+			continue
+		}
+
+		if meta := plugin.Meta(); meta != nil {
+			names[meta.Name] = true
+		}
+	}
+
+	var plugins []Plugin
+	for _, entry := range entries {
+		if names[entry.Name] {
+			logger.Debug(fmt.Sprintf("Plugin %s already loaded", entry.Name))
+			continue
+		}
+
+		registryMutex.RLock()
+		factory, ok := registry[entry.Name]
+		registryMutex.RUnlock()
+
+		if !ok {
+			logger.Warn(fmt.Sprintf("Plugin %s is not registered, skipping it", entry.Name))
+			continue
+		}
+
+		plugin, err := factory(entry.Config)
+		if err != nil || plugin == nil {
+			logger.Warn(fmt.Sprintf("Unable to load plugin %s: %v", entry.Name, err))
+			continue
+		}
+
+		names[entry.Name] = true
+		plugins = append(plugins, plugin)
+	}
+
+	return plugins
+}
+
+// readPluginsFile reads and parses the plugins file at path
+func readPluginsFile(path string) (*pluginsFile, error) {
+	fileBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file pluginsFile
+	if err := json.Unmarshal(fileBytes, &file); err != nil {
+		return nil, err
+	}
+
+	return &file, nil
+}
+
+// pluginEnvToggle returns the environment variable enabling or disabling the
+// plugin with name, such as IOPIPE_PLUGIN_LOGGER_ENABLED for @iopipe/logger
+func pluginEnvToggle(name string) string {
+	name = strings.TrimPrefix(name, "@iopipe/")
+
+	toggle := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+
+	return "IOPIPE_PLUGIN_" + strings.Trim(toggle, "_") + "_ENABLED"
+}
+
+// pluginToggle returns whether the plugin is enabled by its environment
+// variable, nil if it isn't set
+func pluginToggle(plugin Plugin) *bool {
+	meta := plugin.Meta()
+	if meta == nil {
+		return nil
+	}
+
+	toggle := os.Getenv(pluginEnvToggle(meta.Name))
+	if toggle == "" {
+		return nil
+	}
+
+	return strToBool(toggle)
+}
@@ -0,0 +1,147 @@
+package iopipe
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type registryTestPluginConfig struct {
+	Greeting string
+}
+
+type registryTestPlugin struct {
+	registryTestPluginConfig
+
+	invoked bool
+}
+
+func (p *registryTestPlugin) Meta() *PluginMeta {
+	return &PluginMeta{Name: "@iopipe/test-registry", Enabled: true, Uploads: []string{}}
+}
+
+func (p *registryTestPlugin) PreInvoke(ctx context.Context, payload interface{}) {
+	p.invoked = true
+}
+
+type nilMetaPlugin struct{}
+
+func (p *nilMetaPlugin) Meta() *PluginMeta {
+	return nil
+}
+
+func init() {
+	RegisterPlugin("@iopipe/test-registry", func(raw json.RawMessage) (Plugin, error) {
+		var config registryTestPluginConfig
+		if err := DecodePluginConfig(raw, &config); err != nil {
+			return nil, err
+		}
+
+		return &registryTestPlugin{registryTestPluginConfig: config}, nil
+	})
+}
+
+func TestRegistry_RegisteredPlugins(t *testing.T) {
+	oldPlugins := os.Getenv("IOPIPE_PLUGINS")
+	defer os.Setenv("IOPIPE_PLUGINS", oldPlugins)
+
+	Convey("The bundled plugins are registered", t, func() {
+		So(RegisteredPlugins(), ShouldContain, "@iopipe/logger")
+		So(RegisteredPlugins(), ShouldContain, "@iopipe/runtime")
+		So(RegisteredPlugins(), ShouldContain, "@iopipe/leak-detector")
+	})
+
+	Convey("Plugins listed in IOPIPE_PLUGINS are loaded, skipping unknown ones", t, func() {
+		os.Setenv("IOPIPE_PLUGINS", " @iopipe/test-registry, @iopipe/missing ,@iopipe/runtime")
+
+		a := NewAgent(Config{})
+
+		So(pluginNames(a.plugins), ShouldResemble, []string{"@iopipe/test-registry", "@iopipe/runtime"})
+	})
+
+	Convey("Plugins already in the config are not loaded twice", t, func() {
+		os.Setenv("IOPIPE_PLUGINS", "@iopipe/runtime,@iopipe/runtime")
+
+		a := NewAgent(Config{Plugins: []PluginInstantiator{RuntimePlugin(RuntimePluginConfig{})}})
+
+		So(pluginNames(a.plugins), ShouldResemble, []string{"@iopipe/runtime"})
+	})
+
+	Convey("Plugins without meta in the config don't stop others loading", t, func() {
+		os.Setenv("IOPIPE_PLUGINS", "@iopipe/runtime")
+
+		a := NewAgent(Config{Plugins: []PluginInstantiator{func() Plugin { return &nilMetaPlugin{} }}})
+
+		So(a.plugins, ShouldHaveLength, 2)
+		So(a.plugins[1].Meta().Name, ShouldEqual, "@iopipe/runtime")
+	})
+
+	Convey("Plugins listed in the plugins file are given their config block", t, func() {
+		os.Setenv("IOPIPE_PLUGINS", "@iopipe/test-registry")
+
+		dir, err := ioutil.TempDir("", "iopipe")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "plugins.json")
+		ioutil.WriteFile(path, []byte(`{
+			"plugins": [
+				{"name": "@iopipe/test-registry", "config": {"greeting": "hello"}},
+				{"name": "@iopipe/logger", "config": {"mode": "tail", "misspelled": true}}
+			]
+		}`), 0644)
+
+		a := NewAgent(Config{PluginsFile: &path})
+
+		So(a.plugins, ShouldHaveLength, 1)
+		So(a.plugins[0].(*registryTestPlugin).Greeting, ShouldEqual, "hello")
+	})
+
+	Convey("Missing plugins files are logged and ignored", t, func() {
+		os.Setenv("IOPIPE_PLUGINS", "")
+		path := "/does/not/exist.json"
+
+		So(NewAgent(Config{PluginsFile: &path}).plugins, ShouldBeEmpty)
+	})
+}
+
+func TestRegistry_PluginToggle(t *testing.T) {
+	Convey("Plugin toggles are named after the plugin", t, func() {
+		So(pluginEnvToggle("@iopipe/logger"), ShouldEqual, "IOPIPE_PLUGIN_LOGGER_ENABLED")
+		So(pluginEnvToggle("@iopipe/leak-detector"), ShouldEqual, "IOPIPE_PLUGIN_LEAK_DETECTOR_ENABLED")
+		So(pluginEnvToggle("@acme/tracer"), ShouldEqual, "IOPIPE_PLUGIN_ACME_TRACER_ENABLED")
+	})
+
+	Convey("Plugins disabled by their toggle don't run and are reported as disabled", t, func() {
+		oldToggle := os.Getenv("IOPIPE_PLUGIN_TEST_REGISTRY_ENABLED")
+		defer os.Setenv("IOPIPE_PLUGIN_TEST_REGISTRY_ENABLED", oldToggle)
+		os.Setenv("IOPIPE_PLUGIN_TEST_REGISTRY_ENABLED", "false")
+
+		plugin := &registryTestPlugin{}
+
+		var reported *Report
+		a := NewAgent(Config{
+			Plugins: []PluginInstantiator{func() Plugin { return plugin }},
+			Reporter: func(report *Report) error {
+				reported = report
+				return nil
+			},
+		})
+
+		NewHandlerWrapper(func(ctx context.Context) error {
+			return nil
+		}, a).Invoke(context.Background(), nil)
+
+		So(plugin.invoked, ShouldBeFalse)
+		So(pluginEnabled(plugin), ShouldBeFalse)
+		So(reported.Plugins[0].Enabled, ShouldBeFalse)
+
+		os.Setenv("IOPIPE_PLUGIN_TEST_REGISTRY_ENABLED", "true")
+		So(pluginEnabled(plugin), ShouldBeTrue)
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-lambda-go/lambdacontext"
@@ -11,11 +12,24 @@ import (
 
 // Report contains an IOpipe report
 type Report struct {
-	agent     *Agent
-	mutex     sync.Mutex
-	sent      bool
-	startTime time.Time
-
+	// accessed atomically, keep 64-bit aligned
+	lateWrites int64
+	redactions int64
+	finalized  int32
+
+	agent      *Agent
+	handler    *HandlerWrapper
+	mutex      sync.Mutex
+	sent       bool
+	startTime  time.Time
+	hooksMutex sync.Mutex
+	uploads    map[string][]string
+
+	// hooksClosed is true once the PreReport hooks finished or timed out,
+	// writes of hooks still running are then dropped
+	hooksClosed bool
+
+	SchemaVersion string             `json:"schemaVersion"`
 	ClientID      string             `json:"client_id"`
 	InstallMethod string             `json:"installMethod"`
 	Duration      int                `json:"duration"`
@@ -162,6 +176,27 @@ func NewReport(handler *HandlerWrapper) *Report {
 
 	pluginsMeta := make([]PluginMeta, 0)
 
+	aws := &ReportAWS{
+		FunctionName:             lambdacontext.FunctionName,
+		FunctionVersion:          lambdacontext.FunctionVersion,
+		AWSRequestID:             lc.AwsRequestID,
+		InvokedFunctionArn:       lc.InvokedFunctionArn,
+		LogGroupName:             lambdacontext.LogGroupName,
+		LogStreamName:            lambdacontext.LogStreamName,
+		MemoryLimitInMB:          lambdacontext.MemoryLimitInMB,
+		GetRemainingTimeInMillis: int(time.Until(handler.deadline).Nanoseconds() / 1e6),
+		TraceID:                  os.Getenv("_X_AMZN_TRACE_ID"),
+	}
+
+	// Units of work outside of Lambda are identified by their metadata
+	if metadata := handler.metadata; metadata != nil {
+		aws.FunctionName = metadata.FunctionName
+		aws.FunctionVersion = metadata.FunctionVersion
+		aws.LogGroupName = metadata.LogGroupName
+		aws.LogStreamName = metadata.LogStreamName
+		aws.MemoryLimitInMB = metadata.MemoryLimitInMB
+	}
+
 	token := ""
 	if agent != nil && agent.Token != nil {
 		token = *agent.Token
@@ -169,24 +204,16 @@ func NewReport(handler *HandlerWrapper) *Report {
 
 	return &Report{
 		agent:     agent,
+		handler:   handler,
 		sent:      false,
 		startTime: startTime,
 
+		SchemaVersion: SchemaVersion,
 		ClientID:      token,
 		InstallMethod: "manual",
 		ProcessID:     processID,
 		Timestamp:     int(startTime.UnixNano() / 1e6),
-		AWS: &ReportAWS{
-			FunctionName:             lambdacontext.FunctionName,
-			FunctionVersion:          lambdacontext.FunctionVersion,
-			AWSRequestID:             lc.AwsRequestID,
-			InvokedFunctionArn:       lc.InvokedFunctionArn,
-			LogGroupName:             lambdacontext.LogGroupName,
-			LogStreamName:            lambdacontext.LogStreamName,
-			MemoryLimitInMB:          lambdacontext.MemoryLimitInMB,
-			GetRemainingTimeInMillis: int(time.Until(handler.deadline).Nanoseconds() / 1e6),
-			TraceID:                  os.Getenv("_X_AMZN_TRACE_ID"),
-		},
+		AWS:           aws,
 		Environment: &ReportEnvironment{
 			Agent: &ReportEnvironmentAgent{
 				Runtime:  RUNTIME,
@@ -217,7 +244,7 @@ func NewReport(handler *HandlerWrapper) *Report {
 				Version: runtimeVersion,
 			},
 		},
-		ColdStart:     coldStart,
+		ColdStart:     handler.coldStart,
 		CustomMetrics: []CustomMetric{},
 		labels:        make(map[string]struct{}, 0),
 		Labels:        make([]string, 0),
@@ -226,11 +253,128 @@ func NewReport(handler *HandlerWrapper) *Report {
 	}
 }
 
-// prepare prepares an IOpipe report to be sent
+// HandlerWrapper returns the handler wrapper of the invocation being reported,
+// for plugins to look up their per-invocation state in report hooks
+func (r *Report) HandlerWrapper() *HandlerWrapper {
+	return r.handler
+}
+
+// addUpload records a file uploaded by the named plugin, it is safe to call
+// from PreReport hooks
+func (r *Report) addUpload(pluginName, jwtAccess string) {
+	r.hooksMutex.Lock()
+	defer r.hooksMutex.Unlock()
+
+	if r.hooksClosed {
+		r.agent.log.Debug(fmt.Sprintf("Upload of %s finished after the report was sent. This upload will not be recorded.", pluginName))
+		return
+	}
+
+	if r.uploads == nil {
+		r.uploads = make(map[string][]string)
+	}
+	r.uploads[pluginName] = append(r.uploads[pluginName], jwtAccess)
+
+	r.setPluginUploads(pluginName)
+}
+
+// setPluginUploads sets the uploads of the named plugin in the report's plugin
+// meta data, the uploads of handlers are reported as a plugin of their own
+func (r *Report) setPluginUploads(pluginName string) {
+	uploads := append([]string{}, r.uploads[pluginName]...)
+
+	for index := range r.Plugins {
+		if r.Plugins[index].Name == pluginName {
+			r.Plugins[index].Uploads = uploads
+			return
+		}
+	}
+
+	if pluginName == handlerUploadsName {
+		meta := handlerUploadsMeta
+		meta.Uploads = uploads
+		r.Plugins = append(r.Plugins, meta)
+	}
+}
+
+// addPreReportMetric adds a custom metric to a report that is being sent, it
+// is safe to call from PreReport hooks. Metrics added once the hooks timed out
+// are dropped.
+func (r *Report) addPreReportMetric(metric CustomMetric) {
+	r.hooksMutex.Lock()
+	defer r.hooksMutex.Unlock()
+
+	if r.hooksClosed {
+		atomic.AddInt64(&r.lateWrites, 1)
+		return
+	}
+
+	r.CustomMetrics = append(r.CustomMetrics, metric)
+}
+
+// addLabel adds a label to the report, returning false if the report has
+// already been finalized
+func (r *Report) addLabel(name string) bool {
+	if r.isFinalized() {
+		atomic.AddInt64(&r.lateWrites, 1)
+		return false
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.isFinalized() {
+		atomic.AddInt64(&r.lateWrites, 1)
+		return false
+	}
+
+	// Using map to ensure uniqueness of labels
+	r.labels[name] = struct{}{}
+
+	return true
+}
+
+// addMetric adds a custom metric to the report, labeling it as having metrics
+// if label is true. Returns false if the report has already been finalized.
+func (r *Report) addMetric(metric CustomMetric, label bool) bool {
+	if r.isFinalized() {
+		atomic.AddInt64(&r.lateWrites, 1)
+		return false
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.isFinalized() {
+		atomic.AddInt64(&r.lateWrites, 1)
+		return false
+	}
+
+	if label {
+		r.labels["@iopipe/metrics"] = struct{}{}
+	}
+
+	r.CustomMetrics = append(r.CustomMetrics, metric)
+
+	return true
+}
+
+// isFinalized returns true once the report has been prepared, after which
+// labels and metrics are no longer recorded
+func (r *Report) isFinalized() bool {
+	return atomic.LoadInt32(&r.finalized) == 1
+}
+
+// prepare prepares an IOpipe report to be sent, only the first call has any
+// effect
 func (r *Report) prepare(err error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	if !atomic.CompareAndSwapInt32(&r.finalized, 0, 1) {
+		return
+	}
+
 	endTime := time.Now()
 	r.TimestampEnd = int(endTime.UnixNano() / 1e6)
 	r.Duration = int(endTime.Sub(r.startTime).Nanoseconds())
@@ -243,10 +387,27 @@ func (r *Report) prepare(err error) {
 		r.Labels = append(r.Labels, label)
 	}
 
+	r.hooksMutex.Lock()
 	r.Plugins = make([]PluginMeta, len(r.agent.plugins))
 	for index, plugin := range r.agent.plugins {
-		r.Plugins[index] = *plugin.Meta()
+		if meta := plugin.Meta(); meta != nil {
+			r.Plugins[index] = *meta
+		} else {
+			r.Plugins[index] = PluginMeta{Name: pluginName(plugin), Enabled: pluginEnabled(plugin), Uploads: []string{}}
+		}
+		if enabled := pluginToggle(plugin); enabled != nil {
+			r.Plugins[index].Enabled = *enabled
+		}
 	}
+	if r.handler != nil {
+		for index := range r.agent.plugins {
+			r.Plugins[index].Hooks = r.handler.pluginHooks(index)
+		}
+	}
+	for pluginName := range r.uploads {
+		r.setPluginUploads(pluginName)
+	}
+	r.hooksMutex.Unlock()
 
 	statEnd := readPIDStat()
 	r.Environment.OS.Linux.PID.Self.Stat.Cstime = statEnd.cstime
@@ -300,6 +461,17 @@ func (r *Report) send() {
 
 	r.preReport()
 
+	// Writes made while the report was being prepared are counted, those made
+	// after it is sent are only logged
+	if lateWrites := atomic.LoadInt64(&r.lateWrites); lateWrites > 0 {
+		r.CustomMetrics = append(r.CustomMetrics, CustomMetric{Name: "@iopipe/late-writes", N: lateWrites})
+	}
+
+	// Redact last, so what the PreReport hooks added is scrubbed too
+	r.redact()
+	r.appendRedactionsMetric()
+	r.truncate()
+
 	if r.agent != nil && r.agent.Reporter != nil {
 		err := r.agent.Reporter(r)
 
@@ -311,38 +483,56 @@ func (r *Report) send() {
 	r.postReport()
 }
 
-// preReport runs the PreReport hooks
+// preReport runs the PreReport hooks, giving them half the time left before
+// the invocation's deadline so the report can still be sent
 func (r *Report) preReport() {
-	var wg sync.WaitGroup
-	wg.Add(len(r.agent.plugins))
+	var deadline time.Time
+	if r.handler != nil && !r.handler.deadline.IsZero() {
+		deadline = time.Now().Add(time.Until(r.handler.deadline) / 2)
+	}
 
-	for _, plugin := range r.agent.plugins {
-		go func(plugin Plugin) {
-			defer wg.Done()
+	outcomes := r.agent.runPluginHooks(r.agent.hookTimeout(deadline), func(plugin Plugin) func() {
+		if hook, ok := plugin.(PreReportHook); ok && pluginEnabled(plugin) {
+			return func() { hook.PreReport(r) }
+		}
+		return nil
+	})
 
-			if plugin != nil && plugin.Enabled() {
-				plugin.PreReport(r)
-			}
-		}(plugin)
-	}
+	r.agent.logHookFailures(hookPreReport, outcomes)
 
-	wg.Wait()
-}
+	// Hooks left running past their deadline can no longer change the report
+	// while it is sent
+	r.hooksMutex.Lock()
+	defer r.hooksMutex.Unlock()
 
-// postReport runs the PostReport hooks
-func (r *Report) postReport() {
-	var wg sync.WaitGroup
-	wg.Add(len(r.agent.plugins))
+	r.hooksClosed = true
 
-	for _, plugin := range r.agent.plugins {
-		go func(plugin Plugin) {
-			defer wg.Done()
+	for index, outcome := range outcomes {
+		if outcome == nil || index >= len(r.Plugins) {
+			continue
+		}
 
-			if plugin != nil && plugin.Enabled() {
-				plugin.PostReport(r)
-			}
-		}(plugin)
+		if r.Plugins[index].Hooks == nil {
+			r.Plugins[index].Hooks = make(map[string]PluginHookMeta)
+		}
+		r.Plugins[index].Hooks[hookPreReport] = *outcome
 	}
+}
+
+// postReport runs the PostReport hooks, whose outcomes are only logged since
+// the report has already been sent
+func (r *Report) postReport() {
+	var deadline time.Time
+	if r.handler != nil {
+		deadline = r.handler.deadline
+	}
+
+	outcomes := r.agent.runPluginHooks(r.agent.hookTimeout(deadline), func(plugin Plugin) func() {
+		if hook, ok := plugin.(PostReportHook); ok && pluginEnabled(plugin) {
+			return func() { hook.PostReport(r) }
+		}
+		return nil
+	})
 
-	wg.Wait()
+	r.agent.logHookFailures(hookPostReport, outcomes)
 }
@@ -40,6 +40,7 @@ func TestReport_NewReport(t *testing.T) {
 
 const emptyReport = `
 {
+  "schemaVersion": "1.1.0",
   "client_id": "",
   "installMethod": "manual",
   "duration": {{.Duration}},
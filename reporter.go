@@ -47,14 +47,23 @@ func sendReport(report *Report) error {
 	reportJSONBytes, _ := json.Marshal(report) //.MarshalIndent(report, "", "  ")
 	report.agent.log.Debug("Sending report:\n", string(reportJSONBytes))
 
+	compression, threshold := report.agent.compressionSettings()
+	payload, contentEncoding, err := compressPayload(reportJSONBytes, compression, threshold)
+	if err != nil {
+		return err
+	}
+
 	uRL := getCollectorURL(os.Getenv("AWS_REGION"))
-	req, err := http.NewRequest("POST", uRL, bytes.NewReader(reportJSONBytes))
+	req, err := http.NewRequest("POST", uRL, bytes.NewReader(payload))
 
 	if err != nil {
 		return err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
 	res, err := httpsClient.Do(req)
 
 	if err != nil {
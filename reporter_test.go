@@ -2,6 +2,7 @@ package iopipe
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -57,3 +58,50 @@ func TestReporter_sendReport(t *testing.T) {
 		So(err, ShouldBeNil)
 	})
 }
+
+func TestReporter_sendReportCompressed(t *testing.T) {
+	var (
+		contentEncoding string
+		body            []byte
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		contentEncoding = req.Header.Get("Content-Encoding")
+		data, _ := ioutil.ReadAll(req.Body)
+		body, _ = DecompressPayload(data, contentEncoding)
+		fmt.Fprintln(res, "")
+	}))
+	defer ts.Close()
+
+	oldRegion := os.Getenv("AWS_REGION")
+	defer os.Setenv("AWS_REGION", oldRegion)
+
+	os.Setenv("AWS_REGION", "mock")
+	os.Setenv("MOCK_SERVER", ts.URL)
+
+	Convey("sendReport should compress reports over the threshold", t, func() {
+		compression := "gzip"
+		threshold := 0
+		a := NewAgent(Config{Compression: &compression, CompressionThreshold: &threshold})
+		hw := &HandlerWrapper{agent: a}
+		r := NewReport(hw)
+		r.prepare(nil)
+
+		So(sendReport(r), ShouldBeNil)
+		So(contentEncoding, ShouldEqual, "gzip")
+		So(ValidateReportJSON(body), ShouldBeNil)
+	})
+
+	Convey("sendReport should not compress reports under the threshold", t, func() {
+		compression := "gzip"
+		threshold := 1 << 20
+		a := NewAgent(Config{Compression: &compression, CompressionThreshold: &threshold})
+		hw := &HandlerWrapper{agent: a}
+		r := NewReport(hw)
+		r.prepare(nil)
+
+		So(sendReport(r), ShouldBeNil)
+		So(contentEncoding, ShouldEqual, "")
+		So(ValidateReportJSON(body), ShouldBeNil)
+	})
+}
@@ -0,0 +1,142 @@
+package iopipe
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"runtime/metrics"
+)
+
+// RuntimePluginConfig is the runtime plugin configuration
+type RuntimePluginConfig struct{}
+
+type runtimePlugin struct {
+	RuntimePluginConfig
+}
+
+// runtimeSnapshot is the state of the Go runtime at a point in time
+type runtimeSnapshot struct {
+	allocations    uint64
+	allocatedBytes uint64
+	gcCycles       uint64
+	gcPauseNs      uint64
+	goroutines     uint64
+	heapInUse      uint64
+}
+
+// runtimeMetrics are the runtime/metrics samples read by snapshots
+var runtimeMetrics = []string{
+	"/gc/heap/allocs:objects",
+	"/gc/heap/allocs:bytes",
+	"/gc/cycles/total:gc-cycles",
+	"/sched/goroutines:goroutines",
+}
+
+func (p *runtimePlugin) Meta() *PluginMeta {
+	return &PluginMeta{
+		Name:     "@iopipe/runtime",
+		Version:  "0.1.0",
+		Homepage: "https://github.com/iopipe/iopipe-go#runtime-plugin",
+		Enabled:  p.Enabled(),
+		Uploads:  []string{},
+	}
+}
+
+func (p *runtimePlugin) Enabled() bool {
+	return true
+}
+
+func (p *runtimePlugin) PreInvoke(ctx context.Context, payload interface{}) {
+	context, ok := FromContext(ctx)
+	if !ok {
+		return
+	}
+
+	context.IOpipe.SetPluginState(p, takeRuntimeSnapshot())
+}
+
+func (p *runtimePlugin) PostInvoke(ctx context.Context, payload interface{}) {
+	context, ok := FromContext(ctx)
+	if !ok {
+		return
+	}
+
+	before, ok := context.IOpipe.PluginState(p).(*runtimeSnapshot)
+	if !ok {
+		return
+	}
+
+	after := takeRuntimeSnapshot()
+	hw := context.IOpipe
+
+	hw.Label("@iopipe/plugin-runtime")
+	hw.Metric("@iopipe/runtime.allocations", counterDelta(before.allocations, after.allocations))
+	hw.Metric("@iopipe/runtime.allocated-bytes", counterDelta(before.allocatedBytes, after.allocatedBytes))
+	hw.Metric("@iopipe/runtime.gc.cycles", counterDelta(before.gcCycles, after.gcCycles))
+	hw.Metric("@iopipe/runtime.gc.pause-ns", counterDelta(before.gcPauseNs, after.gcPauseNs))
+	hw.Metric("@iopipe/runtime.goroutines.before", int64(before.goroutines))
+	hw.Metric("@iopipe/runtime.goroutines.after", int64(after.goroutines))
+	hw.Metric("@iopipe/runtime.heap.in-use", int64(after.heapInUse))
+}
+
+// RuntimePlugin loads the runtime plugin, which reports what the Go runtime
+// did during each invocation. The deltas are process wide, so they include
+// the work of invocations running concurrently.
+func RuntimePlugin(config RuntimePluginConfig) PluginInstantiator {
+	return func() Plugin {
+		return &runtimePlugin{
+			RuntimePluginConfig: config,
+		}
+	}
+}
+
+func init() {
+	RegisterPlugin("@iopipe/runtime", func(raw json.RawMessage) (Plugin, error) {
+		var config RuntimePluginConfig
+		if err := DecodePluginConfig(raw, &config); err != nil {
+			return nil, err
+		}
+
+		return RuntimePlugin(config)(), nil
+	})
+}
+
+// takeRuntimeSnapshot reads the runtime's memory statistics and metrics
+func takeRuntimeSnapshot() *runtimeSnapshot {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	samples := make([]metrics.Sample, len(runtimeMetrics))
+	for index, name := range runtimeMetrics {
+		samples[index].Name = name
+	}
+	metrics.Read(samples)
+
+	return &runtimeSnapshot{
+		allocations:    sampleUint64(samples[0], memStats.Mallocs),
+		allocatedBytes: sampleUint64(samples[1], memStats.TotalAlloc),
+		gcCycles:       sampleUint64(samples[2], uint64(memStats.NumGC)),
+		gcPauseNs:      memStats.PauseTotalNs,
+		goroutines:     sampleUint64(samples[3], uint64(runtime.NumGoroutine())),
+		heapInUse:      memStats.HeapInuse,
+	}
+}
+
+// sampleUint64 returns the value of sample, or fallback if the runtime
+// doesn't support it
+func sampleUint64(sample metrics.Sample, fallback uint64) uint64 {
+	if sample.Value.Kind() != metrics.KindUint64 {
+		return fallback
+	}
+
+	return sample.Value.Uint64()
+}
+
+// counterDelta returns the increase of a counter between two snapshots
+func counterDelta(before, after uint64) int64 {
+	if after < before {
+		return 0
+	}
+
+	return int64(after - before)
+}
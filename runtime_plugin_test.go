@@ -0,0 +1,66 @@
+package iopipe
+
+import (
+	"context"
+	"runtime"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var runtimeSink [][]byte
+
+func TestRuntimePlugin_RuntimePlugin(t *testing.T) {
+	Convey("Runtime plugin should report what the runtime did during an invocation", t, func() {
+		var reported *Report
+
+		token := "token"
+		a := NewAgent(Config{
+			Token: &token,
+			Plugins: []PluginInstantiator{
+				RuntimePlugin(RuntimePluginConfig{}),
+			},
+			Reporter: func(report *Report) error {
+				reported = report
+				return nil
+			},
+		})
+
+		So(len(a.plugins), ShouldEqual, 1)
+
+		done := make(chan struct{})
+		defer close(done)
+
+		a.Run(context.Background(), "job", func(ctx context.Context) error {
+			for i := 0; i < 100; i++ {
+				runtimeSink = append(runtimeSink, make([]byte, 1024))
+			}
+			runtimeSink = nil
+
+			for i := 0; i < 10; i++ {
+				go func() { <-done }()
+			}
+			runtime.GC()
+
+			return nil
+		})
+
+		So(reported.Labels, ShouldContain, "@iopipe/plugin-runtime")
+		So(metricValue(reported, "@iopipe/runtime.allocations"), ShouldBeGreaterThanOrEqualTo, 100)
+		So(metricValue(reported, "@iopipe/runtime.allocated-bytes"), ShouldBeGreaterThanOrEqualTo, 100*1024)
+		So(metricValue(reported, "@iopipe/runtime.gc.cycles"), ShouldBeGreaterThanOrEqualTo, 1)
+		So(metricValue(reported, "@iopipe/runtime.gc.pause-ns"), ShouldNotBeNil)
+		So(metricValue(reported, "@iopipe/runtime.heap.in-use"), ShouldBeGreaterThan, 0)
+
+		before := metricValue(reported, "@iopipe/runtime.goroutines.before").(int64)
+		after := metricValue(reported, "@iopipe/runtime.goroutines.after").(int64)
+		So(after, ShouldBeGreaterThan, before)
+	})
+}
+
+func TestRuntimePlugin_counterDelta(t *testing.T) {
+	Convey("Counter deltas are never negative", t, func() {
+		So(counterDelta(10, 15), ShouldEqual, 5)
+		So(counterDelta(15, 10), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,303 @@
+package iopipe
+
+import (
+	_ "embed" // for the report and log entry schemas
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// SchemaVersion is the version of the report schema the agent sends
+const SchemaVersion = "1.1.0"
+
+// ReportSchema is the JSON Schema of the report sent to IOpipe
+//
+//go:embed schema/report.schema.json
+var ReportSchema []byte
+
+// LogEntrySchema is the JSON Schema of the log entries uploaded by the logger plugin
+//
+//go:embed schema/log-entry.schema.json
+var LogEntrySchema []byte
+
+var (
+	reportSchema   = mustParseSchema(ReportSchema)
+	logEntrySchema = mustParseSchema(LogEntrySchema)
+)
+
+// ValidationError lists the ways a document violates a schema
+type ValidationError struct {
+	Violations []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("schema validation failed: %s", strings.Join(e.Violations, "; "))
+}
+
+// Validate validates the report against the report schema
+func (r *Report) Validate() error {
+	reportJSONBytes, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return ValidateReportJSON(reportJSONBytes)
+}
+
+// ValidateReportJSON validates a serialized report against the report schema
+func ValidateReportJSON(data []byte) error {
+	return validateJSON(reportSchema, data)
+}
+
+// ValidateLogEntryJSON validates a serialized log entry against the log entry schema
+func ValidateLogEntryJSON(data []byte) error {
+	return validateJSON(logEntrySchema, data)
+}
+
+// jsonSchema is the subset of JSON Schema (draft-07) used by the IOpipe schemas
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 schemaTypes            `json:"type"`
+	Const                interface{}            `json:"const"`
+	Enum                 []interface{}          `json:"enum"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	MaxProperties        *int                   `json:"maxProperties"`
+	Items                *jsonSchema            `json:"items"`
+	AnyOf                []*jsonSchema          `json:"anyOf"`
+	Minimum              *float64               `json:"minimum"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              string                 `json:"pattern"`
+	Definitions          map[string]*jsonSchema `json:"definitions"`
+
+	pattern *regexp.Regexp
+}
+
+// schemaTypes is a JSON Schema type, which may be a single type or a list of types
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+
+	*t = multiple
+	return nil
+}
+
+func mustParseSchema(data []byte) *jsonSchema {
+	var schema jsonSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		panic(fmt.Sprintf("iopipe: invalid schema: %v", err))
+	}
+
+	if err := schema.compile(); err != nil {
+		panic(fmt.Sprintf("iopipe: invalid schema: %v", err))
+	}
+
+	return &schema
+}
+
+func (s *jsonSchema) compile() error {
+	if s == nil {
+		return nil
+	}
+
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return err
+		}
+		s.pattern = pattern
+	}
+
+	children := []*jsonSchema{s.Items}
+	children = append(children, s.AnyOf...)
+	for _, child := range s.Properties {
+		children = append(children, child)
+	}
+	for _, child := range s.Definitions {
+		children = append(children, child)
+	}
+
+	for _, child := range children {
+		if err := child.compile(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateJSON(schema *jsonSchema, data []byte) error {
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return &ValidationError{Violations: []string{fmt.Sprintf("invalid JSON: %v", err)}}
+	}
+
+	violations := schema.validate(schema, "$", document)
+	if len(violations) > 0 {
+		return &ValidationError{Violations: violations}
+	}
+
+	return nil
+}
+
+// validate returns the violations of value at path, resolving refs against root
+func (s *jsonSchema) validate(root *jsonSchema, path string, value interface{}) []string {
+	if s.Ref != "" {
+		ref, ok := root.Definitions[strings.TrimPrefix(s.Ref, "#/definitions/")]
+		if !ok {
+			return []string{fmt.Sprintf("%s: unresolved schema reference %s", path, s.Ref)}
+		}
+
+		return ref.validate(root, path, value)
+	}
+
+	if len(s.Type) > 0 && !s.Type.matches(value) {
+		return []string{fmt.Sprintf("%s: expected %s, got %s", path, strings.Join(s.Type, " or "), jsonType(value))}
+	}
+
+	var violations []string
+
+	if s.Const != nil && !reflect.DeepEqual(s.Const, value) {
+		violations = append(violations, fmt.Sprintf("%s: expected %v, got %v", path, s.Const, value))
+	}
+
+	if len(s.Enum) > 0 && !containsValue(s.Enum, value) {
+		violations = append(violations, fmt.Sprintf("%s: %v is not one of %v", path, value, s.Enum))
+	}
+
+	if len(s.AnyOf) > 0 {
+		matched := false
+		for _, option := range s.AnyOf {
+			if len(option.validate(root, path, value)) == 0 {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			violations = append(violations, fmt.Sprintf("%s: does not match any allowed schema", path))
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		violations = append(violations, s.validateObject(root, path, value)...)
+	case []interface{}:
+		if s.Items != nil {
+			for index, item := range value {
+				violations = append(violations, s.Items.validate(root, fmt.Sprintf("%s[%d]", path, index), item)...)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(value)
+		if s.MinLength != nil && length < *s.MinLength {
+			violations = append(violations, fmt.Sprintf("%s: shorter than %d characters", path, *s.MinLength))
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			violations = append(violations, fmt.Sprintf("%s: longer than %d characters", path, *s.MaxLength))
+		}
+		if s.pattern != nil && !s.pattern.MatchString(value) {
+			violations = append(violations, fmt.Sprintf("%s: does not match pattern %s", path, s.Pattern))
+		}
+	case float64:
+		if s.Minimum != nil && value < *s.Minimum {
+			violations = append(violations, fmt.Sprintf("%s: less than minimum %v", path, *s.Minimum))
+		}
+	}
+
+	return violations
+}
+
+func (s *jsonSchema) validateObject(root *jsonSchema, path string, value map[string]interface{}) []string {
+	var violations []string
+
+	for _, name := range s.Required {
+		if _, ok := value[name]; !ok {
+			violations = append(violations, fmt.Sprintf("%s: missing required property %q", path, name))
+		}
+	}
+
+	if s.MaxProperties != nil && len(value) > *s.MaxProperties {
+		violations = append(violations, fmt.Sprintf("%s: more than %d properties", path, *s.MaxProperties))
+	}
+
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		property, ok := s.Properties[name]
+		if !ok {
+			if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				violations = append(violations, fmt.Sprintf("%s: unexpected property %q", path, name))
+			}
+			continue
+		}
+
+		violations = append(violations, property.validate(root, path+"."+name, value[name])...)
+	}
+
+	return violations
+}
+
+func (t schemaTypes) matches(value interface{}) bool {
+	for _, expected := range t {
+		actual := jsonType(value)
+		if expected == actual || (expected == "number" && actual == "integer") {
+			return true
+		}
+	}
+
+	return false
+}
+
+func jsonType(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
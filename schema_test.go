@@ -0,0 +1,268 @@
+package iopipe
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// goldenReport returns a fully populated report with deterministic values
+func goldenReport() *Report {
+	return &Report{
+		SchemaVersion: SchemaVersion,
+		ClientID:      "token",
+		InstallMethod: "manual",
+		Duration:      1500000,
+		ProcessID:     "2c4e26f2-5b4c-4f43-9b8a-5b6b8c2d4a11",
+		Timestamp:     1528000000000,
+		TimestampEnd:  1528000000002,
+		AWS: &ReportAWS{
+			FunctionName:             "golden",
+			FunctionVersion:          "$LATEST",
+			AWSRequestID:             "6b2e1f2c-0d4d-4b7e-8a5f-1c2d3e4f5a6b",
+			InvokedFunctionArn:       "arn:aws:lambda:us-east-1:123456789012:function:golden",
+			LogGroupName:             "/aws/lambda/golden",
+			LogStreamName:            "2018/06/03/[$LATEST]abcdef",
+			MemoryLimitInMB:          128,
+			GetRemainingTimeInMillis: 2998,
+			TraceID:                  "Root=1-5b13bd2a-1c2d3e4f5a6b7c8d9e0f1a2b",
+		},
+		Disk: &ReportDisk{
+			TotalMiB:       512,
+			UsedMiB:        12.5,
+			UsedPercentage: 2.44,
+		},
+		Environment: &ReportEnvironment{
+			Agent: &ReportEnvironmentAgent{
+				Runtime:  RUNTIME,
+				Version:  "0.0.0",
+				LoadTime: 1527999999000,
+			},
+			Host: &ReportEnvironmentHost{
+				BootID: "2b3c4d5e-6f70-4812-9a3b-4c5d6e7f8091",
+			},
+			OS: &ReportEnvironmentOS{
+				FreeMem:  1024,
+				Hostname: "golden",
+				TotalMem: 4096,
+				UsedMem:  3072,
+				CPUs: []ReportEnvironmentOSCPU{
+					{Times: ReportEnvironmentOSCPUTimes{Idle: 1, Irq: 2, Nice: 3, Sys: 4, User: 5}},
+				},
+				Linux: &ReportEnvironmentOSLinux{
+					PID: &ReportEnvironmentOSLinuxPID{
+						Self: &ReportEnvironmentOSLinuxPIDSelf{
+							Stat:      &ReportEnvironmentOSLinuxPIDSelfStat{Cstime: 1, Cutime: 2, Stime: 3, Utime: 4},
+							StatStart: &ReportEnvironmentOSLinuxPIDSelfStat{Cstime: 0, Cutime: 1, Stime: 2, Utime: 3},
+							Status:    &ReportEnvironmentOSLinuxPIDSelfStatus{FDSize: 8, Threads: 6, VMRSS: 10240},
+						},
+					},
+				},
+			},
+			Runtime: &ReportEnvironmentRuntime{
+				Name:    RUNTIME,
+				Version: "1.10",
+			},
+		},
+		ColdStart: true,
+		Errors: &InvocationError{
+			Message: "whoops",
+			Name:    "errorString",
+			Stack:   "github.com/iopipe/iopipe-go/golden.go:1 golden",
+		},
+		CustomMetrics: []CustomMetric{
+			{Name: "string", S: "value"},
+			{Name: "number", N: int64(42)},
+		},
+		Labels: []string{"@iopipe/coldstart", "@iopipe/error"},
+		Plugins: []PluginMeta{
+			{
+				Name:     "@iopipe/logger",
+				Version:  "0.1.0",
+				Homepage: "https://github.com/iopipe/iopipe-go#logger-plugin",
+				Enabled:  true,
+				Uploads:  []string{"jwt"},
+				Hooks: map[string]PluginHookMeta{
+					"preInvoke":  {Duration: 12000},
+					"postInvoke": {Duration: 34000},
+					"preReport":  {Duration: 5000000000, Error: "timed out after 5s", TimedOut: true},
+				},
+			},
+		},
+	}
+}
+
+// jsonPaths returns the paths of all values in a JSON document along with their types
+func jsonPaths(prefix string, value interface{}, paths map[string]string) {
+	paths[prefix] = jsonType(value)
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			jsonPaths(prefix+"."+key, child, paths)
+		}
+	case []interface{}:
+		for _, child := range value {
+			jsonPaths(prefix+"[]", child, paths)
+		}
+	}
+}
+
+func TestSchema_ReportGolden(t *testing.T) {
+	Convey("The serialized report matches the golden file", t, func() {
+		actual, err := json.MarshalIndent(goldenReport(), "", "  ")
+		So(err, ShouldBeNil)
+
+		golden := filepath.Join("testdata", "report.golden.json")
+		if *updateGolden {
+			So(ioutil.WriteFile(golden, append(actual, '\n'), 0644), ShouldBeNil)
+		}
+
+		expected, err := ioutil.ReadFile(golden)
+		So(err, ShouldBeNil)
+
+		// Changing the report format? Run `go test -run TestSchema -update` and
+		// bump SchemaVersion if the change is not backward compatible
+		So(string(actual)+"\n", ShouldEqual, string(expected))
+	})
+}
+
+func TestSchema_BackwardCompatible(t *testing.T) {
+	Convey("Every published report version is a subset of the current report", t, func() {
+		So(filepath.Join("testdata", fmt.Sprintf("report-v%s.json", SchemaVersion)), shouldExist)
+
+		current, _ := json.Marshal(goldenReport())
+
+		var currentDocument interface{}
+		json.Unmarshal(current, &currentDocument)
+
+		currentPaths := make(map[string]string)
+		jsonPaths("$", currentDocument, currentPaths)
+
+		files, _ := filepath.Glob(filepath.Join("testdata", "report-v*.json"))
+		So(files, ShouldNotBeEmpty)
+
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			So(err, ShouldBeNil)
+
+			var document interface{}
+			So(json.Unmarshal(data, &document), ShouldBeNil)
+
+			paths := make(map[string]string)
+			jsonPaths("$", document, paths)
+
+			var keys []string
+			for path := range paths {
+				keys = append(keys, path)
+			}
+			sort.Strings(keys)
+
+			for _, path := range keys {
+				Convey(fmt.Sprintf("%s: %s is still present", filepath.Base(file), path), func() {
+					currentType, ok := currentPaths[path]
+					So(ok, ShouldBeTrue)
+
+					if paths[path] != "integer" && currentType != "integer" {
+						So(currentType, ShouldEqual, paths[path])
+					}
+				})
+			}
+		}
+	})
+}
+
+func TestSchema_Validate(t *testing.T) {
+	Convey("A valid report passes validation", t, func() {
+		So(goldenReport().Validate(), ShouldBeNil)
+	})
+
+	Convey("A report generated by the agent passes validation", t, func() {
+		a := NewAgent(Config{})
+		hw := &HandlerWrapper{agent: a}
+		r := NewReport(hw)
+		hw.report = r
+
+		hw.Metric("foo", "bar")
+		hw.Label("baz")
+		r.prepare(fmt.Errorf("whoops"))
+
+		So(r.Validate(), ShouldBeNil)
+	})
+
+	Convey("An invalid report fails validation", t, func() {
+		r := goldenReport()
+		r.Labels = []string{""}
+		r.CustomMetrics = append(r.CustomMetrics, CustomMetric{Name: "bool", S: true})
+		r.SchemaVersion = "0.0.1"
+
+		err := r.Validate()
+		So(err, ShouldNotBeNil)
+
+		violations := err.(*ValidationError).Violations
+		So(violations, ShouldContain, "$.schemaVersion: expected 1.1.0, got 0.0.1")
+		So(violations, ShouldContain, "$.labels[0]: shorter than 1 characters")
+		So(violations, ShouldContain, "$.custom_metrics[2].s: expected string or null, got boolean")
+	})
+
+	Convey("A report missing fields fails validation", t, func() {
+		err := ValidateReportJSON([]byte(`{"client_id": 1}`))
+		So(err, ShouldNotBeNil)
+
+		violations := err.(*ValidationError).Violations
+		So(violations, ShouldContain, `$: missing required property "aws"`)
+		So(violations, ShouldContain, "$.client_id: expected string, got integer")
+	})
+}
+
+func TestSchema_LogEntry(t *testing.T) {
+	Convey("Log entries formatted by the JSON formatter pass validation", t, func() {
+		entry := log.NewEntry(log.New())
+		entry.Time = time.Date(2018, 6, 3, 12, 0, 0, 0, time.UTC)
+		entry.Level = log.WarnLevel
+		entry.Message = "hello"
+
+		formatted, err := JSONFormatter{}.Format(entry)
+		So(err, ShouldBeNil)
+		So(string(formatted), ShouldContainSubstring, `"severity":"warning"`)
+		So(ValidateLogEntryJSON(formatted), ShouldBeNil)
+	})
+
+	Convey("Log entries with fields, caller and request ID pass validation", t, func() {
+		entry := log.New().WithField("user", "jane")
+		entry.Time = time.Date(2018, 6, 3, 12, 0, 0, 0, time.UTC)
+		entry.Level = log.InfoLevel
+		entry.Message = "hello"
+		entry.Caller = &runtime.Frame{File: "main.go", Line: 12, Function: "main.handler"}
+		entry.Logger.ReportCaller = true
+
+		formatted, err := JSONFormatter{Name: "api", RequestID: "request-1"}.Format(entry)
+		So(err, ShouldBeNil)
+		So(string(formatted), ShouldContainSubstring, `"fields":{"user":"jane"}`)
+		So(string(formatted), ShouldContainSubstring, `"caller":{"file":"main.go","line":12,"function":"main.handler"}`)
+		So(ValidateLogEntryJSON(formatted), ShouldBeNil)
+	})
+
+	Convey("Malformed log entries fail validation", t, func() {
+		So(ValidateLogEntryJSON([]byte(`{"timestamp":"yesterday","name":"root","Severity":"info","message":"hi"}`)), ShouldNotBeNil)
+	})
+}
+
+func shouldExist(actual interface{}, expected ...interface{}) string {
+	if _, err := ioutil.ReadFile(actual.(string)); err != nil {
+		return fmt.Sprintf("expected %s to exist, add it when bumping SchemaVersion", actual)
+	}
+
+	return ""
+}
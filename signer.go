@@ -62,6 +62,9 @@ func GetSignedRequest(report *Report, extension string) (*SignerResponse, error)
 
 	httpsClient := http.Client{Transport: tr, Timeout: networkTimeout}
 
+	// The transport isn't reused, don't leave its connection open
+	defer tr.CloseIdleConnections()
+
 	signerRequest := &SignerRequest{
 		ARN:       report.AWS.InvokedFunctionArn,
 		RequestID: report.AWS.AWSRequestID,
@@ -100,7 +103,7 @@ func GetSignedRequest(report *Report, extension string) (*SignerResponse, error)
 	}
 
 	if res.StatusCode > 299 {
-		report.agent.log.Debug("Response failed: %d %s", res.StatusCode, bodyBytes)
+		report.agent.log.Debugf("Response failed: %d %s", res.StatusCode, bodyBytes)
 		return nil, fmt.Errorf("Response failed: %d %s", res.StatusCode, bodyBytes)
 	}
 
@@ -1,7 +1,11 @@
 package iopipe
 
 import (
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/shirou/gopsutil/cpu"
 	"github.com/shirou/gopsutil/disk"
@@ -10,6 +14,12 @@ import (
 	"github.com/shirou/gopsutil/process"
 )
 
+// clockTicks is the kernel's USER_HZ, the unit of process times in
+// /proc/self/stat. The kernel fixes it at 100 on x86_64 and arm64, the
+// architectures Lambda runs on, whatever HZ it is built with, so it isn't read
+// from sysconf(_SC_CLK_TCK), which would need cgo.
+const clockTicks = 100
+
 type cpuTimes struct {
 	idle uint64
 	nice uint64
@@ -89,7 +99,10 @@ func readPIDStat() *pidStat {
 	pid := os.Getpid()
 
 	proc, _ := process.NewProcess(int32(pid))
-	times, _ := proc.Times()
+	times, err := proc.Times()
+	if err != nil {
+		return &pidStat{}
+	}
 
 	var (
 		childSystem uint64
@@ -100,14 +113,15 @@ func readPIDStat() *pidStat {
 	children, _ := proc.Children()
 
 	// TODO: Investigate a more efficient way to do this
-	if children != nil && len(children) > 0 {
-		for child := range children {
-			childProc, _ := process.NewProcess(int32(child))
-			childTimes, _ := childProc.Times()
-
-			childSystem = childSystem + uint64(childTimes.System)
-			childUser = childUser + uint64(childTimes.User)
+	for _, child := range children {
+		// Children may exit while their times are read
+		childTimes, err := child.Times()
+		if err != nil {
+			continue
 		}
+
+		childSystem = childSystem + uint64(childTimes.System)
+		childUser = childUser + uint64(childTimes.User)
 	}
 
 	return &pidStat{
@@ -153,3 +167,49 @@ func readSystemStat() []cpuTimes {
 
 	return times
 }
+
+// readProcessStart returns when the process started, from its starttime in
+// /proc/self/stat and the system uptime, or zero if they can't be read
+func readProcessStart() time.Time {
+	now := time.Now()
+
+	statBytes, err := ioutil.ReadFile("/proc/self/stat")
+	if err != nil {
+		return time.Time{}
+	}
+
+	uptimeBytes, err := ioutil.ReadFile("/proc/uptime")
+	if err != nil {
+		return time.Time{}
+	}
+
+	// The command name may contain spaces, the fields after it start with
+	// the state, the third field of the stat
+	stat := string(statBytes)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 20 {
+		return time.Time{}
+	}
+
+	startTicks, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	uptimeFields := strings.Fields(string(uptimeBytes))
+	if len(uptimeFields) == 0 {
+		return time.Time{}
+	}
+
+	uptime, err := strconv.ParseFloat(uptimeFields[0], 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	age := time.Duration(uptime*float64(time.Second)) - time.Duration(startTicks)*time.Second/clockTicks
+	if age < 0 {
+		age = 0
+	}
+
+	return now.Add(-age)
+}
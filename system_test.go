@@ -2,6 +2,7 @@ package iopipe
 
 import (
 	"testing"
+	"time"
 
 	"github.com/shirou/gopsutil/cpu"
 	. "github.com/smartystreets/goconvey/convey"
@@ -134,3 +135,13 @@ func TestSystem_readSystemStat(t *testing.T) {
 		}
 	})
 }
+
+func TestSystem_readProcessStart(t *testing.T) {
+	Convey("readProcessStart should return when the process started", t, func() {
+		start := readProcessStart()
+
+		So(start.IsZero(), ShouldBeFalse)
+		So(start, ShouldHappenBefore, time.Now())
+		So(start, ShouldHappenWithin, time.Hour, loadedAt)
+	})
+}
@@ -0,0 +1,215 @@
+package iopipe
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// handlerUploadsName is the name of the plugin handler uploads are reported as
+const handlerUploadsName = "@iopipe/upload"
+
+// handlerUploadsMeta is the meta data of the plugin handler uploads are
+// reported as
+var handlerUploadsMeta = PluginMeta{
+	Name:     handlerUploadsName,
+	Version:  "0.1.0",
+	Homepage: "https://github.com/iopipe/iopipe-go#uploads",
+	Enabled:  true,
+}
+
+// uploadRetries is how many times a failed upload is retried
+const uploadRetries = 2
+
+// uploadRetryDelay is the delay before retrying an upload, doubled for each
+// retry
+var uploadRetryDelay = 100 * time.Millisecond
+
+// uploadClient is shared by uploads, which close its idle connections when
+// done so they don't outlive the invocation. It has its own transport so the
+// idle connections of the handler are left alone.
+var uploadClient = &http.Client{Transport: &http.Transport{}, Timeout: 60 * time.Second}
+
+// Upload uploads a file for the invocation to IOpipe, such as a request dump,
+// a CSV output or a profile. name is appended to the file name and should be
+// unique within the invocation, it is also used as the file's extension.
+// Bodies implementing io.Seeker, such as files, are streamed from their
+// current offset, other bodies are read into memory first so failed uploads
+// can be retried.
+func (hw *HandlerWrapper) Upload(name, contentType string, body io.Reader) error {
+	if hw == nil || hw.report == nil {
+		return fmt.Errorf("Attempting to upload %s before function decorated with IOpipe", name)
+	}
+
+	body, err := hw.report.redactUpload(name, contentType, body)
+	if err != nil {
+		return err
+	}
+
+	return hw.report.upload(handlerUploadsName, name, contentType, body, nil)
+}
+
+// Upload uploads a file for the invocation to IOpipe on behalf of plugin,
+// adding it to the uploads of the plugin's meta data. It is safe to call from
+// PreReport hooks, see HandlerWrapper.Upload for how the body is read.
+func (r *Report) Upload(plugin Plugin, name, contentType string, body io.Reader) error {
+	body, err := r.redactUpload(name, contentType, body)
+	if err != nil {
+		return err
+	}
+
+	return r.upload(pluginName(plugin), name, contentType, body, nil)
+}
+
+// redactUpload returns body with sensitive values replaced if redaction is
+// enabled and body is text, reading it into memory. Other bodies are returned
+// as is.
+func (r *Report) redactUpload(name, contentType string, body io.Reader) (io.Reader, error) {
+	rd := r.agent.redactor()
+	if rd == nil || body == nil || (contentType != "" && !isTextMediaType(contentType)) {
+		return body, nil
+	}
+
+	bodyBytes, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read %s for upload: %v", name, err)
+	}
+
+	if contentType == "" && !isTextMediaType(http.DetectContentType(bodyBytes)) {
+		return bytes.NewReader(bodyBytes), nil
+	}
+
+	bodyBytes, redactions := rd.redactBytes(bodyBytes)
+	r.addRedactions(redactions)
+
+	return bytes.NewReader(bodyBytes), nil
+}
+
+// isTextMediaType returns true if contentType is text, JSON or XML
+func isTextMediaType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(mediaType, "text/") ||
+		mediaType == "application/json" || strings.HasSuffix(mediaType, "+json") ||
+		mediaType == "application/x-ndjson" ||
+		mediaType == "application/xml" || strings.HasSuffix(mediaType, "+xml")
+}
+
+// upload signs and uploads a file, retrying failed attempts, and records it
+// in the uploads of the named plugin
+func (r *Report) upload(pluginName, name, contentType string, body io.Reader, header http.Header) error {
+	reader, start, size, err := uploadBody(body)
+	if err != nil {
+		return fmt.Errorf("Unable to read %s for upload: %v", name, err)
+	}
+
+	defer uploadClient.CloseIdleConnections()
+
+	extension := "." + strings.TrimPrefix(name, ".")
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			time.Sleep(uploadRetryDelay << uint(attempt-1))
+
+			if _, err := reader.Seek(start, io.SeekStart); err != nil {
+				return fmt.Errorf("Unable to rewind %s for upload: %v", name, err)
+			}
+		}
+
+		var jwtAccess string
+		jwtAccess, err = r.uploadOnce(uploadClient, extension, contentType, io.LimitReader(reader, size), size, header)
+		if err == nil {
+			r.addUpload(pluginName, jwtAccess)
+			return nil
+		}
+
+		r.agent.log.Debug(fmt.Sprintf("Upload of %s failed (attempt %d): %v", name, attempt+1, err))
+
+		if attempt == uploadRetries {
+			return fmt.Errorf("Unable to upload %s: %v", name, err)
+		}
+	}
+}
+
+// uploadOnce signs and uploads a file, returning the JWT to access it
+func (r *Report) uploadOnce(client *http.Client, extension, contentType string, body io.Reader, size int64, header http.Header) (string, error) {
+	signedRequest, err := GetSignedRequest(r, extension)
+	if err != nil {
+		return "", err
+	}
+
+	// Empty bodies would otherwise be sent chunked
+	if size == 0 {
+		body = http.NoBody
+	}
+
+	req, err := http.NewRequest("PUT", signedRequest.SignedRequest, body)
+	if err != nil {
+		return "", err
+	}
+
+	req.ContentLength = size
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+
+	defer res.Body.Close()
+	bodyBytes, _ := ioutil.ReadAll(res.Body)
+
+	r.agent.log.Debug("Upload Status: ", res.StatusCode)
+
+	if res.StatusCode > 299 {
+		return "", fmt.Errorf("Response failed: %d %s", res.StatusCode, bodyBytes)
+	}
+
+	return signedRequest.JWTAccess, nil
+}
+
+// uploadBody returns a seekable reader of body with the offset it starts at
+// and its size, reading bodies that can't seek into memory
+func uploadBody(body io.Reader) (io.ReadSeeker, int64, int64, error) {
+	if body == nil {
+		body = bytes.NewReader(nil)
+	}
+
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+
+		end, err := seeker.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return nil, 0, 0, err
+		}
+
+		return seeker, start, end - start, nil
+	}
+
+	bodyBytes, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	return bytes.NewReader(bodyBytes), 0, int64(len(bodyBytes)), nil
+}
@@ -0,0 +1,266 @@
+package iopipe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type uploadServer struct {
+	*httptest.Server
+
+	mutex      sync.Mutex
+	failures   int
+	extensions []string
+	bodies     []string
+	types      []string
+}
+
+// newUploadServer returns a signer and upload server failing the first
+// failures uploads
+func newUploadServer(failures int) *uploadServer {
+	s := &uploadServer{failures: failures}
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		if req.Method == http.MethodPut {
+			body, _ := ioutil.ReadAll(req.Body)
+			if s.failures > 0 {
+				s.failures--
+				res.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			s.bodies = append(s.bodies, string(body))
+			s.types = append(s.types, req.Header.Get("Content-Type"))
+			return
+		}
+
+		var signerRequest SignerRequest
+		json.NewDecoder(req.Body).Decode(&signerRequest)
+		s.extensions = append(s.extensions, signerRequest.Extension)
+
+		signerResponseJSONBytes, _ := json.Marshal(&SignerResponse{
+			JWTAccess:     "jwt" + signerRequest.Extension,
+			SignedRequest: "http://" + req.Host + "/upload",
+		})
+		fmt.Fprintln(res, string(signerResponseJSONBytes))
+	}))
+
+	return s
+}
+
+type uploadPlugin struct {
+	body string
+	err  error
+}
+
+func (p *uploadPlugin) Meta() *PluginMeta {
+	return &PluginMeta{Name: "@iopipe/test-upload", Enabled: true, Uploads: []string{}}
+}
+
+func (p *uploadPlugin) Enabled() bool                                       { return true }
+func (p *uploadPlugin) PreSetup(agent *Agent)                               {}
+func (p *uploadPlugin) PostSetup(agent *Agent)                              {}
+func (p *uploadPlugin) PreInvoke(ctx context.Context, payload interface{})  {}
+func (p *uploadPlugin) PostInvoke(ctx context.Context, payload interface{}) {}
+func (p *uploadPlugin) PostReport(report *Report)                           {}
+
+func (p *uploadPlugin) PreReport(report *Report) {
+	p.err = report.Upload(p, "profile.pprof", "application/octet-stream", strings.NewReader(p.body))
+}
+
+func TestUpload_Upload(t *testing.T) {
+	oldRegion := os.Getenv("AWS_REGION")
+	defer os.Setenv("AWS_REGION", oldRegion)
+	os.Setenv("AWS_REGION", "mock")
+
+	oldRetryDelay := uploadRetryDelay
+	defer func() { uploadRetryDelay = oldRetryDelay }()
+	uploadRetryDelay = time.Millisecond
+
+	invoke := func(a *Agent, handler interface{}) {
+		ctx := lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{AwsRequestID: "request-1"})
+		NewHandlerWrapper(handler, a).Invoke(ctx, nil)
+	}
+
+	Convey("Files uploaded by handlers are registered in the report", t, func() {
+		s := newUploadServer(1)
+		defer s.Close()
+		os.Setenv("MOCK_SERVER", s.URL)
+
+		var (
+			reported  *Report
+			uploadErr error
+		)
+
+		a := NewAgent(Config{
+			Reporter: func(report *Report) error {
+				reported = report
+				return nil
+			},
+		})
+
+		invoke(a, func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+			// Readers that can't seek are retried too
+			uploadErr = context.IOpipe.Upload("response.json", "application/json", io.MultiReader(strings.NewReader(`{"ok":`), strings.NewReader("true}")))
+			return nil
+		})
+
+		So(uploadErr, ShouldBeNil)
+		So(s.extensions, ShouldResemble, []string{".response.json", ".response.json"})
+		So(s.bodies, ShouldResemble, []string{`{"ok":true}`})
+		So(s.types, ShouldResemble, []string{"application/json"})
+
+		meta := reported.Plugins[len(reported.Plugins)-1]
+		So(meta.Name, ShouldEqual, "@iopipe/upload")
+		So(meta.Uploads, ShouldResemble, []string{"jwt.response.json"})
+	})
+
+	Convey("Seekable bodies are streamed from their current offset", t, func() {
+		s := newUploadServer(1)
+		defer s.Close()
+		os.Setenv("MOCK_SERVER", s.URL)
+
+		a := NewAgent(Config{Reporter: func(report *Report) error { return nil }})
+
+		var uploadErr error
+		invoke(a, func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+
+			body := strings.NewReader("skipped,kept")
+			body.Seek(8, io.SeekStart)
+			uploadErr = context.IOpipe.Upload("csv", "text/csv", body)
+			return nil
+		})
+
+		So(uploadErr, ShouldBeNil)
+		So(s.bodies, ShouldResemble, []string{"kept"})
+	})
+
+	Convey("Uploads fail once the retries are exhausted", t, func() {
+		s := newUploadServer(uploadRetries + 1)
+		defer s.Close()
+		os.Setenv("MOCK_SERVER", s.URL)
+
+		var reported *Report
+		a := NewAgent(Config{
+			Reporter: func(report *Report) error {
+				reported = report
+				return nil
+			},
+		})
+
+		var uploadErr error
+		invoke(a, func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+			uploadErr = context.IOpipe.Upload("txt", "text/plain", strings.NewReader("hello"))
+			return nil
+		})
+
+		So(uploadErr, ShouldNotBeNil)
+		So(uploadErr.Error(), ShouldContainSubstring, "Unable to upload txt")
+		So(len(s.extensions), ShouldEqual, uploadRetries+1)
+		So(reported.Plugins, ShouldBeEmpty)
+	})
+
+	Convey("Files uploaded by plugins are registered in their meta data", t, func() {
+		s := newUploadServer(0)
+		defer s.Close()
+		os.Setenv("MOCK_SERVER", s.URL)
+
+		plugin := &uploadPlugin{body: "profile"}
+
+		var reported *Report
+		a := NewAgent(Config{
+			Plugins: []PluginInstantiator{
+				func() Plugin { return plugin },
+			},
+			Reporter: func(report *Report) error {
+				reported = report
+				return nil
+			},
+		})
+
+		invoke(a, func(ctx context.Context) error { return nil })
+
+		So(plugin.err, ShouldBeNil)
+		So(s.bodies, ShouldResemble, []string{"profile"})
+		So(reported.Plugins, ShouldHaveLength, 1)
+		So(reported.Plugins[0].Uploads, ShouldResemble, []string{"jwt.profile.pprof"})
+	})
+
+	Convey("Text uploads are redacted, other uploads are sent as is", t, func() {
+		s := newUploadServer(0)
+		defer s.Close()
+		os.Setenv("MOCK_SERVER", s.URL)
+
+		plugin := &uploadPlugin{body: "password=hunter2"}
+
+		var reported *Report
+		a := NewAgent(Config{
+			Plugins:   []PluginInstantiator{func() Plugin { return plugin }},
+			Redaction: &RedactionConfig{},
+			Reporter: func(report *Report) error {
+				reported = report
+				return nil
+			},
+		})
+
+		invoke(a, func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+			context.IOpipe.Upload("dump.txt", "text/plain; charset=utf-8", strings.NewReader("password=hunter2"))
+			context.IOpipe.Upload("dump", "", strings.NewReader("token for bob@example.com"))
+			return nil
+		})
+
+		So(plugin.err, ShouldBeNil)
+		So(s.bodies, ShouldHaveLength, 3)
+		So(s.bodies[0], ShouldNotContainSubstring, "hunter2")
+		So(s.bodies[1], ShouldNotContainSubstring, "bob@example.com")
+		So(s.bodies[2], ShouldEqual, "password=hunter2")
+		So(metricValue(reported, "@iopipe/redactions"), ShouldEqual, int64(2))
+	})
+
+	Convey("Uploads don't leave connections behind", t, func() {
+		s := newUploadServer(0)
+		defer s.Close()
+		os.Setenv("MOCK_SERVER", s.URL)
+
+		var reported *Report
+		a := NewAgent(Config{
+			Plugins: []PluginInstantiator{LeakDetectorPlugin(LeakDetectorPluginConfig{})},
+			Reporter: func(report *Report) error {
+				reported = report
+				return nil
+			},
+		})
+
+		invoke(a, func(ctx context.Context) error {
+			context, _ := FromContext(ctx)
+			return context.IOpipe.Upload("txt", "text/plain", strings.NewReader("hello"))
+		})
+
+		So(s.bodies, ShouldResemble, []string{"hello"})
+		So(reported.Labels, ShouldNotContain, "@iopipe/goroutine-leak")
+	})
+
+	Convey("Uploading without a handler wrapper returns an error", t, func() {
+		So((*HandlerWrapper)(nil).Upload("txt", "text/plain", strings.NewReader("hello")), ShouldNotBeNil)
+	})
+}